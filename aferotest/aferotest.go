@@ -0,0 +1,886 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package aferotest provides a conformance test suite for afero.Fs
+// implementations. The suite checks that a filesystem behaves like the os
+// package does, so code tested against one backend keeps working on another.
+//
+//	func TestMyFs(t *testing.T) {
+//		aferotest.TestFs(t, func() afero.Fs { return NewMyFs() })
+//	}
+package aferotest
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// fixtureDir is where TestReadOnlyFs puts the fixture in the base
+// filesystem.
+var fixtureDir = filepath.FromSlash("/aferotest")
+
+// fixtureContent is the content of the file named "file" in the fixture.
+const fixtureContent = "Hello, World!\n"
+
+// fixtureDirEntries are the names of the entries of the fixture directory
+// named "dir", which are all files except for "sub".
+var fixtureDirEntries = []string{"a", "b", "c", "d", "e", "sub"}
+
+type fsTest struct {
+	name string
+	fn   func(t *testing.T, fs afero.Fs, dir string)
+}
+
+// readTests only read the fixture.
+var readTests = []fsTest{
+	{"Stat", testStat},
+	{"Open", testOpen},
+	{"OpenNotExist", testOpenNotExist},
+	{"Read", testRead},
+	{"ReadAt", testReadAt},
+	{"SeekPastEnd", testSeekPastEnd},
+	{"Readdir", testReaddir},
+	{"ReaddirPaging", testReaddirPaging},
+	{"Readdirnames", testReaddirnames},
+	{"ReaddirFile", testReaddirFile},
+	{"Lstat", testLstat},
+}
+
+// writeTests modify the filesystem.
+var writeTests = []fsTest{
+	{"Create", testCreate},
+	{"CreateTruncates", testCreateTruncates},
+	{"OpenFileExcl", testOpenFileExcl},
+	{"OpenFileAppend", testOpenFileAppend},
+	{"OpenFileTrunc", testOpenFileTrunc},
+	{"OpenFileReadOnly", testOpenFileReadOnly},
+	{"WritePastEnd", testWritePastEnd},
+	{"WriteAt", testWriteAt},
+	{"Truncate", testTruncate},
+	{"Mkdir", testMkdir},
+	{"MkdirAll", testMkdirAll},
+	{"Remove", testRemove},
+	{"RemoveNonEmptyDir", testRemoveNonEmptyDir},
+	{"RemoveAll", testRemoveAll},
+	{"RemoveAllNotExist", testRemoveAllNotExist},
+	{"Rename", testRename},
+	{"RenameOverExisting", testRenameOverExisting},
+	{"RenameDir", testRenameDir},
+	{"RenameNotExist", testRenameNotExist},
+	{"Chmod", testChmod},
+	{"Chtimes", testChtimes},
+	{"Symlink", testSymlink},
+}
+
+// TestFs runs the conformance suite against writable filesystems. newFs is
+// called for every test and may return a new filesystem or the same one
+// again. The tests work in a directory created with afero.TempDir, which is
+// removed again when they finish.
+func TestFs(t *testing.T, newFs func() afero.Fs) {
+	tests := append(append([]fsTest{}, readTests...), writeTests...)
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			fs := newFs()
+			// the temporary directory may not exist in fresh filesystems
+			if err := fs.MkdirAll(os.TempDir(), 0777); err != nil {
+				t.Fatalf("MkdirAll: %v", err)
+			}
+			dir, err := afero.TempDir(fs, "", "aferotest")
+			if err != nil {
+				t.Fatalf("TempDir: %v", err)
+			}
+			defer fs.RemoveAll(dir)
+			writeFixture(t, fs, dir)
+			test.fn(t, fs, dir)
+		})
+	}
+}
+
+// TestReadOnlyFs runs the part of the conformance suite which only reads
+// against read only filesystems and checks that all modifications fail.
+// newFs is called for every test with a writable filesystem holding the
+// fixture and returns the read only view of it to test.
+func TestReadOnlyFs(t *testing.T, newFs func(base afero.Fs) afero.Fs) {
+	tests := append(append([]fsTest{}, readTests...), fsTest{"WriteFails", testWriteFails})
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			base := afero.NewMemMapFs()
+			if err := base.MkdirAll(fixtureDir, 0777); err != nil {
+				t.Fatal(err)
+			}
+			writeFixture(t, base, fixtureDir)
+			test.fn(t, newFs(base), fixtureDir)
+		})
+	}
+}
+
+// writeFixture creates the files the tests expect in dir.
+func writeFixture(t *testing.T, fs afero.Fs, dir string) {
+	t.Helper()
+	if err := afero.WriteFile(fs, filepath.Join(dir, "file"), []byte(fixtureContent), 0644); err != nil {
+		t.Fatalf("writing fixture: %v", err)
+	}
+	if err := fs.Mkdir(filepath.Join(dir, "dir"), 0755); err != nil {
+		t.Fatalf("writing fixture: %v", err)
+	}
+	for _, name := range fixtureDirEntries {
+		path := filepath.Join(dir, "dir", name)
+		var err error
+		if name == "sub" {
+			err = fs.Mkdir(path, 0755)
+		} else {
+			err = afero.WriteFile(fs, path, []byte(name), 0644)
+		}
+		if err != nil {
+			t.Fatalf("writing fixture: %v", err)
+		}
+	}
+}
+
+// checkNotExist fails the test unless err is an *os.PathError or
+// *os.LinkError reporting a missing file.
+func checkNotExist(t *testing.T, op string, err error) {
+	t.Helper()
+	checkError(t, op, err, os.IsNotExist, "not exist")
+}
+
+// checkExist fails the test unless err is an *os.PathError or *os.LinkError
+// reporting an existing file.
+func checkExist(t *testing.T, op string, err error) {
+	t.Helper()
+	checkError(t, op, err, os.IsExist, "exist")
+}
+
+func checkError(t *testing.T, op string, err error, is func(error) bool, want string) {
+	t.Helper()
+	if err == nil {
+		t.Errorf("%s: got no error, want %q error", op, want)
+		return
+	}
+	switch err.(type) {
+	case *os.PathError, *os.LinkError:
+	default:
+		t.Errorf("%s: got %T error %v, want *os.PathError or *os.LinkError", op, err, err)
+	}
+	if !is(err) {
+		t.Errorf("%s: got %v, want %q error", op, err, want)
+	}
+}
+
+func checkContent(t *testing.T, fs afero.Fs, name, want string) {
+	t.Helper()
+	got, err := afero.ReadFile(fs, name)
+	if err != nil {
+		t.Errorf("ReadFile %s: %v", name, err)
+		return
+	}
+	if string(got) != want {
+		t.Errorf("%s: got content %q, want %q", name, got, want)
+	}
+}
+
+func testStat(t *testing.T, fs afero.Fs, dir string) {
+	fi, err := fs.Stat(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Name() != "file" {
+		t.Errorf("got name %q, want %q", fi.Name(), "file")
+	}
+	if fi.IsDir() || !fi.Mode().IsRegular() {
+		t.Errorf("got mode %v, want a regular file", fi.Mode())
+	}
+	if fi.Size() != int64(len(fixtureContent)) {
+		t.Errorf("got size %d, want %d", fi.Size(), len(fixtureContent))
+	}
+
+	fi, err = fs.Stat(filepath.Join(dir, "dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.IsDir() || !fi.Mode().IsDir() {
+		t.Errorf("got mode %v, want a directory", fi.Mode())
+	}
+
+	_, err = fs.Stat(filepath.Join(dir, "missing"))
+	checkNotExist(t, "Stat", err)
+}
+
+func testOpen(t *testing.T, fs afero.Fs, dir string) {
+	name := filepath.Join(dir, "file")
+	f, err := fs.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if f.Name() != name {
+		t.Errorf("got name %q, want %q", f.Name(), name)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Name() != "file" || fi.Size() != int64(len(fixtureContent)) {
+		t.Errorf("got %q with size %d, want %q with size %d", fi.Name(), fi.Size(), "file", len(fixtureContent))
+	}
+	if _, err := f.Write([]byte("x")); err == nil {
+		t.Error("Write to a file opened with Open succeeded")
+	}
+}
+
+func testOpenNotExist(t *testing.T, fs afero.Fs, dir string) {
+	_, err := fs.Open(filepath.Join(dir, "missing"))
+	checkNotExist(t, "Open", err)
+	_, err = fs.OpenFile(filepath.Join(dir, "missing"), os.O_RDONLY, 0)
+	checkNotExist(t, "OpenFile", err)
+}
+
+func testRead(t *testing.T, fs afero.Fs, dir string) {
+	f, err := fs.Open(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, 5)
+	if n, err := f.Read(buf); n != 5 || err != nil || string(buf) != fixtureContent[:5] {
+		t.Errorf("Read: got %d, %v, %q, want 5, <nil>, %q", n, err, buf[:n], fixtureContent[:5])
+	}
+	rest, err := ioutil.ReadAll(f)
+	if err != nil || string(rest) != fixtureContent[5:] {
+		t.Errorf("ReadAll: got %q, %v, want %q", rest, err, fixtureContent[5:])
+	}
+	if n, err := f.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read at end: got %d, %v, want 0, EOF", n, err)
+	}
+
+	if pos, err := f.Seek(7, io.SeekStart); pos != 7 || err != nil {
+		t.Errorf("Seek: got %d, %v, want 7, <nil>", pos, err)
+	}
+	if pos, err := f.Seek(-2, io.SeekCurrent); pos != 5 || err != nil {
+		t.Errorf("Seek: got %d, %v, want 5, <nil>", pos, err)
+	}
+	if n, err := f.Read(buf[:2]); n != 2 || err != nil || string(buf[:2]) != fixtureContent[5:7] {
+		t.Errorf("Read after Seek: got %d, %v, %q, want 2, <nil>, %q", n, err, buf[:n], fixtureContent[5:7])
+	}
+	end := int64(len(fixtureContent))
+	if pos, err := f.Seek(-1, io.SeekEnd); pos != end-1 || err != nil {
+		t.Errorf("Seek: got %d, %v, want %d, <nil>", pos, err, end-1)
+	}
+}
+
+func testReadAt(t *testing.T, fs afero.Fs, dir string) {
+	f, err := fs.Open(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, 5)
+	if n, err := f.ReadAt(buf, 7); n != 5 || err != nil || string(buf) != fixtureContent[7:12] {
+		t.Errorf("ReadAt: got %d, %v, %q, want 5, <nil>, %q", n, err, buf[:n], fixtureContent[7:12])
+	}
+	if n, err := f.ReadAt(buf, int64(len(fixtureContent)-2)); n != 2 || err != io.EOF {
+		t.Errorf("short ReadAt: got %d, %v, want 2, EOF", n, err)
+	}
+	// ReadAt must not move the offset
+	if n, err := f.Read(buf); n != 5 || err != nil || string(buf) != fixtureContent[:5] {
+		t.Errorf("Read after ReadAt: got %d, %v, %q, want 5, <nil>, %q", n, err, buf[:n], fixtureContent[:5])
+	}
+}
+
+func testSeekPastEnd(t *testing.T, fs afero.Fs, dir string) {
+	f, err := fs.Open(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if pos, err := f.Seek(100, io.SeekStart); pos != 100 || err != nil {
+		t.Fatalf("Seek: got %d, %v, want 100, <nil>", pos, err)
+	}
+	buf := make([]byte, 5)
+	if n, err := f.Read(buf); n != 0 || err != io.EOF {
+		t.Errorf("Read past end: got %d, %v, want 0, EOF", n, err)
+	}
+	if n, err := f.ReadAt(buf, 100); n != 0 || err != io.EOF {
+		t.Errorf("ReadAt past end: got %d, %v, want 0, EOF", n, err)
+	}
+}
+
+func readAllDir(t *testing.T, f afero.File, n int) []string {
+	t.Helper()
+	var names []string
+	for {
+		fis, err := f.Readdir(n)
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		if n <= 0 {
+			if err != nil {
+				t.Errorf("Readdir(%d): %v", n, err)
+			}
+			break
+		}
+		if err == io.EOF {
+			if len(fis) != 0 {
+				t.Errorf("Readdir(%d) returned %d entries with EOF", n, len(fis))
+			}
+			break
+		}
+		if err != nil {
+			t.Fatalf("Readdir(%d): %v", n, err)
+		}
+		if len(fis) == 0 || len(fis) > n {
+			t.Fatalf("Readdir(%d) returned %d entries", n, len(fis))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func checkNames(t *testing.T, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("got entries %q, want %q", got, want)
+		return
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			t.Errorf("got entries %q, want %q", got, want)
+			return
+		}
+	}
+}
+
+func testReaddir(t *testing.T, fs afero.Fs, dir string) {
+	f, err := fs.Open(filepath.Join(dir, "dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	fis, err := f.Readdir(-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+		if (fi.Name() == "sub") != fi.IsDir() {
+			t.Errorf("%s: got IsDir %v", fi.Name(), fi.IsDir())
+		}
+		if !fi.IsDir() && fi.Size() != 1 {
+			t.Errorf("%s: got size %d, want 1", fi.Name(), fi.Size())
+		}
+	}
+	sort.Strings(names)
+	checkNames(t, names, fixtureDirEntries)
+
+	// the directory is read completely
+	fis, err = f.Readdir(-1)
+	if len(fis) != 0 || err != nil {
+		t.Errorf("second Readdir(-1): got %d entries, %v, want 0, <nil>", len(fis), err)
+	}
+	if fis, err := f.Readdir(1); len(fis) != 0 || err != io.EOF {
+		t.Errorf("Readdir(1) at end: got %d entries, %v, want 0, EOF", len(fis), err)
+	}
+}
+
+func testReaddirPaging(t *testing.T, fs afero.Fs, dir string) {
+	for _, n := range []int{1, 2, 4, 100} {
+		f, err := fs.Open(filepath.Join(dir, "dir"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkNames(t, readAllDir(t, f, n), fixtureDirEntries)
+		f.Close()
+	}
+}
+
+func testReaddirnames(t *testing.T, fs afero.Fs, dir string) {
+	f, err := fs.Open(filepath.Join(dir, "dir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var names []string
+	for {
+		page, err := f.Readdirnames(4)
+		names = append(names, page...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(names)
+	checkNames(t, names, fixtureDirEntries)
+}
+
+func testReaddirFile(t *testing.T, fs afero.Fs, dir string) {
+	f, err := fs.Open(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Readdir(-1); err == nil {
+		t.Error("Readdir of a file succeeded")
+	}
+}
+
+func testLstat(t *testing.T, fs afero.Fs, dir string) {
+	lstater, ok := fs.(afero.Lstater)
+	if !ok {
+		t.Skip("not an afero.Lstater")
+	}
+	fi, _, err := lstater.LstatIfPossible(filepath.Join(dir, "file"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Name() != "file" || fi.Size() != int64(len(fixtureContent)) || !fi.Mode().IsRegular() {
+		t.Errorf("got %q with size %d and mode %v, want regular file %q with size %d",
+			fi.Name(), fi.Size(), fi.Mode(), "file", len(fixtureContent))
+	}
+	_, _, err = lstater.LstatIfPossible(filepath.Join(dir, "missing"))
+	checkNotExist(t, "LstatIfPossible", err)
+}
+
+func testCreate(t *testing.T, fs afero.Fs, dir string) {
+	name := filepath.Join(dir, "new")
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name() != name {
+		t.Errorf("got name %q, want %q", f.Name(), name)
+	}
+	if _, err := f.WriteString("content"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(f)
+	if err != nil || string(got) != "content" {
+		t.Errorf("reading back: got %q, %v, want %q", got, err, "content")
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkContent(t, fs, name, "content")
+}
+
+func testCreateTruncates(t *testing.T, fs afero.Fs, dir string) {
+	name := filepath.Join(dir, "file")
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	checkContent(t, fs, name, "")
+}
+
+func testOpenFileExcl(t *testing.T, fs afero.Fs, dir string) {
+	_, err := fs.OpenFile(filepath.Join(dir, "file"), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	checkExist(t, "OpenFile existing with O_EXCL", err)
+	checkContent(t, fs, filepath.Join(dir, "file"), fixtureContent)
+
+	name := filepath.Join(dir, "new")
+	f, err := fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if _, err := fs.Stat(name); err != nil {
+		t.Error(err)
+	}
+}
+
+func testOpenFileAppend(t *testing.T, fs afero.Fs, dir string) {
+	name := filepath.Join(dir, "file")
+	f, err := fs.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("more\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	checkContent(t, fs, name, fixtureContent+"more\n")
+
+	name = filepath.Join(dir, "new")
+	f, err = fs.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("new\n")
+	f.Close()
+	checkContent(t, fs, name, "new\n")
+}
+
+func testOpenFileTrunc(t *testing.T, fs afero.Fs, dir string) {
+	name := filepath.Join(dir, "file")
+	f, err := fs.OpenFile(name, os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := f.Stat(); err != nil || fi.Size() != 0 {
+		t.Errorf("Stat after O_TRUNC: got %v, want size 0", err)
+	}
+	f.WriteString("short")
+	f.Close()
+	checkContent(t, fs, name, "short")
+}
+
+func testOpenFileReadOnly(t *testing.T, fs afero.Fs, dir string) {
+	name := filepath.Join(dir, "file")
+	f, err := fs.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Write([]byte("x")); err == nil {
+		t.Error("Write to a file opened with O_RDONLY succeeded")
+	}
+	f.Close()
+	checkContent(t, fs, name, fixtureContent)
+}
+
+func testWritePastEnd(t *testing.T, fs afero.Fs, dir string) {
+	name := filepath.Join(dir, "new")
+	f, err := fs.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("ab")
+	if pos, err := f.Seek(2, io.SeekCurrent); pos != 4 || err != nil {
+		t.Fatalf("Seek: got %d, %v, want 4, <nil>", pos, err)
+	}
+	f.WriteString("cd")
+	f.Close()
+	checkContent(t, fs, name, "ab\x00\x00cd")
+}
+
+func testWriteAt(t *testing.T, fs afero.Fs, dir string) {
+	name := filepath.Join(dir, "file")
+	f, err := fs.OpenFile(name, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.WriteAt([]byte("J"), 7); n != 1 || err != nil {
+		t.Errorf("WriteAt: got %d, %v, want 1, <nil>", n, err)
+	}
+	f.Close()
+	checkContent(t, fs, name, "Hello, Jorld!\n")
+}
+
+func testTruncate(t *testing.T, fs afero.Fs, dir string) {
+	name := filepath.Join(dir, "file")
+	f, err := fs.OpenFile(name, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Truncate(5); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	checkContent(t, fs, name, fixtureContent[:5])
+}
+
+func testMkdir(t *testing.T, fs afero.Fs, dir string) {
+	name := filepath.Join(dir, "newdir")
+	if err := fs.Mkdir(name, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := fs.Stat(name); err != nil || !fi.IsDir() {
+		t.Errorf("Stat: got %v, want a directory", err)
+	}
+	checkExist(t, "Mkdir existing dir", fs.Mkdir(name, 0755))
+	checkExist(t, "Mkdir existing file", fs.Mkdir(filepath.Join(dir, "file"), 0755))
+}
+
+func testMkdirAll(t *testing.T, fs afero.Fs, dir string) {
+	name := filepath.Join(dir, "x", "y", "z")
+	if err := fs.MkdirAll(name, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{filepath.Join(dir, "x"), filepath.Join(dir, "x", "y"), name} {
+		if fi, err := fs.Stat(p); err != nil || !fi.IsDir() {
+			t.Errorf("Stat %s: got %v, want a directory", p, err)
+		}
+	}
+	if err := fs.MkdirAll(name, 0755); err != nil {
+		t.Errorf("MkdirAll of an existing dir: %v", err)
+	}
+	if err := fs.MkdirAll(filepath.Join(dir, "file"), 0755); err == nil {
+		t.Error("MkdirAll over a file succeeded")
+	}
+}
+
+func testRemove(t *testing.T, fs afero.Fs, dir string) {
+	name := filepath.Join(dir, "file")
+	if err := fs.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	_, err := fs.Stat(name)
+	checkNotExist(t, "Stat of removed file", err)
+	checkNotExist(t, "Remove missing file", fs.Remove(name))
+
+	empty := filepath.Join(dir, "dir", "sub")
+	if err := fs.Remove(empty); err != nil {
+		t.Errorf("Remove empty dir: %v", err)
+	}
+}
+
+func testRemoveNonEmptyDir(t *testing.T, fs afero.Fs, dir string) {
+	if err := fs.Remove(filepath.Join(dir, "dir")); err == nil {
+		t.Error("Remove of a non-empty directory succeeded")
+	}
+	checkContent(t, fs, filepath.Join(dir, "dir", "a"), "a")
+}
+
+func testRemoveAll(t *testing.T, fs afero.Fs, dir string) {
+	// a sibling sharing the name as prefix must survive
+	sibling := filepath.Join(dir, "dirx")
+	if err := afero.WriteFile(fs, sibling, []byte("sibling"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.RemoveAll(filepath.Join(dir, "dir")); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"dir", filepath.Join("dir", "a"), filepath.Join("dir", "sub")} {
+		_, err := fs.Stat(filepath.Join(dir, name))
+		checkNotExist(t, "Stat after RemoveAll", err)
+	}
+	checkContent(t, fs, sibling, "sibling")
+	checkContent(t, fs, filepath.Join(dir, "file"), fixtureContent)
+
+	names, err := afero.ReadDir(fs, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range names {
+		if fi.Name() == "dir" {
+			t.Error("removed directory still listed by its parent")
+		}
+	}
+}
+
+func testRemoveAllNotExist(t *testing.T, fs afero.Fs, dir string) {
+	if err := fs.RemoveAll(filepath.Join(dir, "missing")); err != nil {
+		t.Errorf("RemoveAll of a missing path: %v", err)
+	}
+	if err := fs.RemoveAll(filepath.Join(dir, "missing", "deeper")); err != nil {
+		t.Errorf("RemoveAll of a missing path: %v", err)
+	}
+}
+
+func testRename(t *testing.T, fs afero.Fs, dir string) {
+	oldname, newname := filepath.Join(dir, "file"), filepath.Join(dir, "renamed")
+	if err := fs.Rename(oldname, newname); err != nil {
+		t.Fatal(err)
+	}
+	_, err := fs.Stat(oldname)
+	checkNotExist(t, "Stat of old name", err)
+	checkContent(t, fs, newname, fixtureContent)
+}
+
+func testRenameOverExisting(t *testing.T, fs afero.Fs, dir string) {
+	oldname, newname := filepath.Join(dir, "dir", "a"), filepath.Join(dir, "file")
+	if err := fs.Rename(oldname, newname); err != nil {
+		t.Fatal(err)
+	}
+	_, err := fs.Stat(oldname)
+	checkNotExist(t, "Stat of old name", err)
+	checkContent(t, fs, newname, "a")
+
+	fis, err := afero.ReadDir(fs, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, fi := range fis {
+		if fi.Name() == "file" {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("renamed file is listed %d times", count)
+	}
+}
+
+func testRenameDir(t *testing.T, fs afero.Fs, dir string) {
+	oldname, newname := filepath.Join(dir, "dir"), filepath.Join(dir, "moved")
+	if err := fs.Rename(oldname, newname); err != nil {
+		t.Fatal(err)
+	}
+	_, err := fs.Stat(filepath.Join(oldname, "a"))
+	checkNotExist(t, "Stat below old name", err)
+	checkContent(t, fs, filepath.Join(newname, "a"), "a")
+	if fi, err := fs.Stat(filepath.Join(newname, "sub")); err != nil || !fi.IsDir() {
+		t.Errorf("Stat of moved subdirectory: %v", err)
+	}
+
+	f, err := fs.Open(newname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	checkNames(t, readAllDir(t, f, -1), fixtureDirEntries)
+}
+
+func testRenameNotExist(t *testing.T, fs afero.Fs, dir string) {
+	err := fs.Rename(filepath.Join(dir, "missing"), filepath.Join(dir, "new"))
+	checkNotExist(t, "Rename", err)
+}
+
+func testChmod(t *testing.T, fs afero.Fs, dir string) {
+	name := filepath.Join(dir, "file")
+	if err := fs.Chmod(name, 0600); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := fs.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != 0600 {
+		t.Errorf("got mode %v, want %v", fi.Mode(), os.FileMode(0600))
+	}
+
+	name = filepath.Join(dir, "dir")
+	if err := fs.Chmod(name, 0700); err != nil {
+		t.Fatal(err)
+	}
+	fi, err = fs.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != os.ModeDir|0700 {
+		t.Errorf("got mode %v, want %v", fi.Mode(), os.ModeDir|0700)
+	}
+
+	checkNotExist(t, "Chmod", fs.Chmod(filepath.Join(dir, "missing"), 0600))
+}
+
+func testChtimes(t *testing.T, fs afero.Fs, dir string) {
+	name := filepath.Join(dir, "file")
+	mtime := time.Date(2019, 3, 14, 15, 9, 26, 0, time.UTC)
+	if err := fs.Chtimes(name, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := fs.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Errorf("got mtime %v, want %v", fi.ModTime(), mtime)
+	}
+
+	checkNotExist(t, "Chtimes", fs.Chtimes(filepath.Join(dir, "missing"), mtime, mtime))
+}
+
+func testSymlink(t *testing.T, fs afero.Fs, dir string) {
+	symlinker, ok := fs.(afero.Symlinker)
+	if !ok {
+		t.Skip("not an afero.Symlinker")
+	}
+	link := filepath.Join(dir, "link")
+	if err := symlinker.SymlinkIfPossible("file", link); err != nil {
+		t.Skipf("SymlinkIfPossible: %v", err)
+	}
+
+	target, err := symlinker.ReadlinkIfPossible(link)
+	if err != nil || target != "file" {
+		t.Errorf("ReadlinkIfPossible: got %q, %v, want %q", target, err, "file")
+	}
+	checkContent(t, fs, link, fixtureContent)
+
+	fi, lstatCalled, err := symlinker.LstatIfPossible(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lstatCalled && fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("LstatIfPossible: got mode %v, want a symbolic link", fi.Mode())
+	}
+	if fi, err := fs.Stat(link); err != nil || fi.Mode()&os.ModeSymlink != 0 {
+		t.Errorf("Stat does not follow the link: %v", err)
+	}
+
+	err = symlinker.SymlinkIfPossible("file", link)
+	checkExist(t, "SymlinkIfPossible over existing link", err)
+
+	// removing the link leaves the target alone
+	if err := fs.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+	checkContent(t, fs, filepath.Join(dir, "file"), fixtureContent)
+}
+
+func testWriteFails(t *testing.T, fs afero.Fs, dir string) {
+	name := filepath.Join(dir, "file")
+	if _, err := fs.Create(filepath.Join(dir, "new")); err == nil {
+		t.Error("Create succeeded")
+	}
+	for _, flag := range []int{os.O_WRONLY, os.O_RDWR, os.O_RDWR | os.O_APPEND, os.O_WRONLY | os.O_TRUNC} {
+		if f, err := fs.OpenFile(name, flag, 0644); err == nil {
+			f.Close()
+			t.Errorf("OpenFile with flags %#x succeeded", flag)
+		}
+	}
+	if err := fs.Mkdir(filepath.Join(dir, "newdir"), 0755); err == nil {
+		t.Error("Mkdir succeeded")
+	}
+	if err := fs.MkdirAll(filepath.Join(dir, "newdir", "deeper"), 0755); err == nil {
+		t.Error("MkdirAll succeeded")
+	}
+	if err := fs.Remove(name); err == nil {
+		t.Error("Remove succeeded")
+	}
+	if err := fs.RemoveAll(filepath.Join(dir, "dir")); err == nil {
+		t.Error("RemoveAll succeeded")
+	}
+	if err := fs.Rename(name, filepath.Join(dir, "renamed")); err == nil {
+		t.Error("Rename succeeded")
+	}
+	if err := fs.Chmod(name, 0600); err == nil {
+		t.Error("Chmod succeeded")
+	}
+	if err := fs.Chtimes(name, time.Now(), time.Now()); err == nil {
+		t.Error("Chtimes succeeded")
+	}
+	if symlinker, ok := fs.(afero.Symlinker); ok {
+		if err := symlinker.SymlinkIfPossible("file", filepath.Join(dir, "link")); err == nil {
+			t.Error("SymlinkIfPossible succeeded")
+		}
+	}
+
+	checkContent(t, fs, name, fixtureContent)
+	got, err := afero.ReadFile(fs, filepath.Join(dir, "dir", "a"))
+	if err != nil || !bytes.Equal(got, []byte("a")) {
+		t.Errorf("fixture was modified: %q, %v", got, err)
+	}
+}
@@ -0,0 +1,73 @@
+package aferotest_test
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/afero/aferotest"
+)
+
+func TestOsFs(t *testing.T) {
+	aferotest.TestFs(t, afero.NewOsFs)
+}
+
+func TestMemMapFs(t *testing.T) {
+	aferotest.TestFs(t, afero.NewMemMapFs)
+}
+
+func TestBasePathFs(t *testing.T) {
+	aferotest.TestFs(t, func() afero.Fs {
+		base := afero.NewMemMapFs()
+		base.MkdirAll("/base/path", 0777)
+		return afero.NewBasePathFs(base, "/base/path")
+	})
+}
+
+func TestBasePathFsOverOsFs(t *testing.T) {
+	osFs := afero.NewOsFs()
+	dir, err := afero.TempDir(osFs, "", "aferotest-base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer osFs.RemoveAll(dir)
+	aferotest.TestFs(t, func() afero.Fs {
+		return afero.NewBasePathFs(osFs, dir)
+	})
+}
+
+func TestCopyOnWriteFs(t *testing.T) {
+	aferotest.TestFs(t, func() afero.Fs {
+		return afero.NewCopyOnWriteFs(afero.NewReadOnlyFs(afero.NewMemMapFs()), afero.NewMemMapFs())
+	})
+}
+
+func TestCacheOnReadFs(t *testing.T) {
+	aferotest.TestFs(t, func() afero.Fs {
+		return afero.NewCacheOnReadFs(afero.NewMemMapFs(), afero.NewMemMapFs(), 0)
+	})
+}
+
+func TestCacheOnReadFsWithCacheTime(t *testing.T) {
+	aferotest.TestFs(t, func() afero.Fs {
+		return afero.NewCacheOnReadFs(afero.NewMemMapFs(), afero.NewMemMapFs(), time.Minute)
+	})
+}
+
+func TestRegexpFs(t *testing.T) {
+	aferotest.TestFs(t, func() afero.Fs {
+		// hide editor backup files
+		return afero.NewRegexpFs(afero.NewMemMapFs(), regexp.MustCompile(`[^~]$`))
+	})
+}
+
+func TestReadOnlyFs(t *testing.T) {
+	aferotest.TestReadOnlyFs(t, afero.NewReadOnlyFs)
+}
+
+func TestCopyOnWriteFsReadOnlyLayer(t *testing.T) {
+	aferotest.TestReadOnlyFs(t, func(base afero.Fs) afero.Fs {
+		return afero.NewCopyOnWriteFs(base, afero.NewReadOnlyFs(afero.NewMemMapFs()))
+	})
+}
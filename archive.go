@@ -0,0 +1,448 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrInsecurePath is the error wrapped in an os.PathError by ExtractTar and
+// ExtractZip for entries whose name or link target would leave the
+// directory the archive is extracted to.
+var ErrInsecurePath = errors.New("insecure path in archive")
+
+// ArchiveOptions configures ArchiveTar and ArchiveZip. A nil *ArchiveOptions
+// is valid and means the defaults.
+type ArchiveOptions struct {
+	// Compress gzip compresses tar archives and deflates the files of zip
+	// archives, which are stored as they are otherwise.
+	Compress bool
+}
+
+func (a Afero) ArchiveTar(root string, w io.Writer, opts *ArchiveOptions) error {
+	return ArchiveTar(a.Fs, root, w, opts)
+}
+
+// ArchiveTar writes the tree rooted at root to w as a tar archive. The names
+// in the archive are relative to root. Modes and modification times are
+// preserved and symbolic links are archived as links if the filesystem
+// supports them.
+func ArchiveTar(fs Fs, root string, w io.Writer, opts *ArchiveOptions) (err error) {
+	if opts != nil && opts.Compress {
+		gzw := gzip.NewWriter(w)
+		defer func() {
+			if cerr := gzw.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		w = gzw
+	}
+
+	tw := tar.NewWriter(w)
+	err = walkArchive(fs, root, func(name, path string, info os.FileInfo, link string) error {
+		h, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		h.Name = name
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFileTo(fs, path, tw)
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func (a Afero) ArchiveZip(root string, w io.Writer, opts *ArchiveOptions) error {
+	return ArchiveZip(a.Fs, root, w, opts)
+}
+
+// ArchiveZip writes the tree rooted at root to w as a zip archive. The names
+// in the archive are relative to root. Modes and modification times are
+// preserved and symbolic links are archived as links if the filesystem
+// supports them. Files which are neither regular files, directories nor
+// symbolic links are skipped.
+func ArchiveZip(fs Fs, root string, w io.Writer, opts *ArchiveOptions) error {
+	zw := zip.NewWriter(w)
+	err := walkArchive(fs, root, func(name, path string, info os.FileInfo, link string) error {
+		mode := info.Mode()
+		if !mode.IsRegular() && !mode.IsDir() && mode&os.ModeSymlink == 0 {
+			return nil
+		}
+		h, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		h.Name = name
+		h.Method = zip.Store
+		if opts != nil && opts.Compress && mode.IsRegular() {
+			h.Method = zip.Deflate
+		}
+		fw, err := zw.CreateHeader(h)
+		if err != nil {
+			return err
+		}
+		switch {
+		case mode&os.ModeSymlink != 0:
+			_, err = io.WriteString(fw, link)
+			return err
+		case mode.IsRegular():
+			return copyFileTo(fs, path, fw)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// walkArchive calls fn for every file below root with the slash separated
+// name it gets in an archive and, for symbolic links, the link target.
+func walkArchive(fs Fs, root string, fn func(name, path string, info os.FileInfo, link string) error) error {
+	return Walk(fs, root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			if info.IsDir() {
+				return nil
+			}
+			// root is a single file
+			rel = info.Name()
+		}
+		name := filepath.ToSlash(rel)
+		if info.IsDir() {
+			name += "/"
+		}
+
+		var link string
+		if info.Mode()&os.ModeSymlink != 0 {
+			reader, ok := fs.(LinkReader)
+			if !ok {
+				return &os.PathError{Op: "readlink", Path: path, Err: ErrNoReadlink}
+			}
+			if link, err = reader.ReadlinkIfPossible(path); err != nil {
+				return err
+			}
+		}
+
+		return fn(name, path, info, link)
+	})
+}
+
+func copyFileTo(fs Fs, path string, w io.Writer) error {
+	f, err := fs.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
+
+func (a Afero) ExtractTar(root string, r io.Reader) error {
+	return ExtractTar(a.Fs, root, r)
+}
+
+// ExtractTar extracts the tar archive read from r, which may be gzip
+// compressed, into the directory root. Modes and modification times are
+// restored. Hard links are extracted as copies of the file they link to.
+//
+// Entries whose name or link target would leave root, or which would be
+// written through a symbolic link, are rejected with ErrInsecurePath. A
+// leading slash in names is ignored like tar does.
+func ExtractTar(fs Fs, root string, r io.Reader) error {
+	br := bufio.NewReader(r)
+	r = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gzr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gzr.Close()
+		r = gzr
+	}
+
+	x := newExtractor(fs, root)
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		info := h.FileInfo()
+		switch h.Typeflag {
+		case tar.TypeDir:
+			err = x.dir(h.Name, info)
+		case tar.TypeReg, tar.TypeRegA:
+			err = x.file(h.Name, info, tr)
+		case tar.TypeSymlink:
+			err = x.symlink(h.Name, h.Linkname)
+		case tar.TypeLink:
+			err = x.hardlink(h.Name, h.Linkname, info)
+		default:
+			// devices, fifos and the like cannot be represented in an Fs
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return x.finish()
+}
+
+func (a Afero) ExtractZip(root string, r *zip.Reader) error {
+	return ExtractZip(a.Fs, root, r)
+}
+
+// ExtractZip extracts the zip archive r into the directory root. Modes and
+// modification times are restored.
+//
+// Entries whose name or link target would leave root, or which would be
+// written through a symbolic link, are rejected with ErrInsecurePath.
+func ExtractZip(fs Fs, root string, r *zip.Reader) error {
+	x := newExtractor(fs, root)
+	for _, zf := range r.File {
+		info := zf.FileInfo()
+		var err error
+		switch mode := info.Mode(); {
+		case mode.IsDir():
+			err = x.dir(zf.Name, info)
+		case mode&os.ModeSymlink != 0:
+			err = x.zipSymlink(zf)
+		case mode.IsRegular():
+			err = x.zipFile(zf, info)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return x.finish()
+}
+
+// extractor writes archive entries below a root directory of an Fs.
+type extractor struct {
+	fs   *BasePathFs
+	dirs []extractedDir
+}
+
+// extractedDir remembers the mode and modification time of a directory,
+// which can only be restored once all of its content is written, as a
+// read-only mode would keep it from being written.
+type extractedDir struct {
+	name  string
+	mode  os.FileMode
+	mtime time.Time
+}
+
+func newExtractor(fs Fs, root string) *extractor {
+	return &extractor{fs: &BasePathFs{source: fs, path: root}}
+}
+
+// path validates the name of an archive entry and returns it as path below
+// the root.
+func (x *extractor) path(name string) (string, error) {
+	cleaned := path.Clean("/" + strings.TrimLeft(filepath.ToSlash(name), "/"))
+	if strings.Contains(name, "\x00") || hasDotDot(name) {
+		return "", &os.PathError{Op: "extract", Path: name, Err: ErrInsecurePath}
+	}
+	p := filepath.FromSlash(cleaned)
+	if _, err := x.fs.RealPath(p); err != nil {
+		return "", &os.PathError{Op: "extract", Path: name, Err: ErrInsecurePath}
+	}
+	if err := x.noLinks(cleaned); err != nil {
+		if os.IsNotExist(err) {
+			return p, nil
+		}
+		if err == ErrInsecurePath {
+			err = &os.PathError{Op: "extract", Path: name, Err: ErrInsecurePath}
+		}
+		return "", err
+	}
+	return p, nil
+}
+
+// noLinks returns ErrInsecurePath if a component of the cleaned name is a
+// symbolic link extracted before. Checking the link targets alone is not
+// enough, as links to links which are each inside the root, like "s -> .."
+// and "s2 -> s/..", can still lead out of it.
+func (x *extractor) noLinks(cleaned string) error {
+	p := ""
+	for _, elem := range strings.Split(strings.TrimPrefix(cleaned, "/"), "/") {
+		if elem == "" {
+			continue
+		}
+		p += "/" + elem
+		fi, err := lstatIfPossible(x.fs, filepath.FromSlash(p))
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return ErrInsecurePath
+		}
+	}
+	return nil
+}
+
+// hasDotDot reports whether name has a ".." element which would climb above
+// where it started.
+func hasDotDot(name string) bool {
+	depth := 0
+	for _, elem := range strings.Split(filepath.ToSlash(name), "/") {
+		switch elem {
+		case "", ".":
+		case "..":
+			if depth--; depth < 0 {
+				return true
+			}
+		default:
+			depth++
+		}
+	}
+	return false
+}
+
+func (x *extractor) dir(name string, info os.FileInfo) error {
+	p, err := x.path(name)
+	if err != nil {
+		return err
+	}
+	if err := x.fs.MkdirAll(p, 0755); err != nil {
+		return err
+	}
+	x.dirs = append(x.dirs, extractedDir{name: p, mode: info.Mode(), mtime: info.ModTime()})
+	return nil
+}
+
+func (x *extractor) file(name string, info os.FileInfo, r io.Reader) error {
+	p, err := x.path(name)
+	if err != nil {
+		return err
+	}
+	if err := x.fs.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	f, err := x.fs.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := x.fs.Chmod(p, info.Mode()); err != nil {
+		return err
+	}
+	return x.fs.Chtimes(p, info.ModTime(), info.ModTime())
+}
+
+func (x *extractor) symlink(name, target string) error {
+	p, err := x.path(name)
+	if err != nil {
+		return err
+	}
+	// The link must not lead out of the root, or later entries could be
+	// written through it.
+	dir := path.Dir(strings.TrimPrefix(filepath.ToSlash(p), "/"))
+	if filepath.IsAbs(target) || path.IsAbs(target) || hasDotDot(dir+"/"+target) {
+		return &os.PathError{Op: "extract", Path: name, Err: ErrInsecurePath}
+	}
+	if err := x.fs.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return x.fs.SymlinkIfPossible(target, p)
+}
+
+func (x *extractor) hardlink(name, target string, info os.FileInfo) error {
+	tp, err := x.path(target)
+	if err != nil {
+		return err
+	}
+	f, err := x.fs.Open(tp)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return x.file(name, info, f)
+}
+
+func (x *extractor) zipFile(zf *zip.File, info os.FileInfo) error {
+	r, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return x.file(zf.Name, info, r)
+}
+
+func (x *extractor) zipSymlink(zf *zip.File) error {
+	r, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	target, err := ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return x.symlink(zf.Name, string(target))
+}
+
+// finish restores the modes and modification times of the directories,
+// deepest first like tar does, so a directory is complete by the time its
+// parent becomes read-only. A directory listed twice gets the last entry.
+func (x *extractor) finish() error {
+	sort.SliceStable(x.dirs, func(i, j int) bool {
+		return strings.Count(x.dirs[i].name, string(filepath.Separator)) >
+			strings.Count(x.dirs[j].name, string(filepath.Separator))
+	})
+	for _, d := range x.dirs {
+		if err := x.fs.Chmod(d.name, d.mode); err != nil {
+			return err
+		}
+		if err := x.fs.Chtimes(d.name, d.mtime, d.mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
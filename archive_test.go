@@ -0,0 +1,395 @@
+package afero
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func setupArchiveFixture(t *testing.T) Fs {
+	fs := NewMemMapFs()
+	mtime := time.Date(2019, 5, 1, 12, 0, 0, 0, time.UTC)
+	if err := fs.MkdirAll("/src/sub/empty", 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]os.FileMode{
+		"/src/a.txt":     0644,
+		"/src/sub/b.sh":  0755,
+		"/src/sub/c.dat": 0600,
+	}
+	for name, perm := range files {
+		if err := WriteFile(fs, name, []byte("content of "+name), perm); err != nil {
+			t.Fatal(err)
+		}
+		if err := fs.Chmod(name, perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := fs.(Linker).SymlinkIfPossible("sub/b.sh", "/src/link"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"/src/a.txt", "/src/sub/b.sh", "/src/sub/c.dat", "/src/sub/empty", "/src/sub"} {
+		if err := fs.Chtimes(name, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return fs
+}
+
+func checkArchiveRoundTrip(t *testing.T, src Fs, dst Fs, root string) {
+	t.Helper()
+	for _, name := range []string{"a.txt", "sub", "sub/b.sh", "sub/c.dat", "sub/empty"} {
+		want, err := src.Stat(filepath.Join("/src", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := dst.Stat(filepath.Join(root, name))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if got.Mode() != want.Mode() {
+			t.Errorf("%s: mode %v, want %v", name, got.Mode(), want.Mode())
+		}
+		if !got.ModTime().Equal(want.ModTime()) {
+			t.Errorf("%s: mtime %v, want %v", name, got.ModTime(), want.ModTime())
+		}
+		if want.IsDir() {
+			continue
+		}
+		wantData, _ := ReadFile(src, filepath.Join("/src", name))
+		gotData, err := ReadFile(dst, filepath.Join(root, name))
+		if err != nil || !bytes.Equal(gotData, wantData) {
+			t.Errorf("%s: content %q (%v), want %q", name, gotData, err, wantData)
+		}
+	}
+
+	target, err := dst.(LinkReader).ReadlinkIfPossible(filepath.Join(root, "link"))
+	if err != nil || target != "sub/b.sh" {
+		t.Errorf("link: got %q (%v), want %q", target, err, "sub/b.sh")
+	}
+}
+
+func TestArchiveTar(t *testing.T) {
+	src := setupArchiveFixture(t)
+	for _, compress := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := ArchiveTar(src, "/src", &buf, &ArchiveOptions{Compress: compress}); err != nil {
+			t.Fatal(err)
+		}
+		if gz := bytes.HasPrefix(buf.Bytes(), []byte{0x1f, 0x8b}); gz != compress {
+			t.Errorf("compress %v: archive gzipped: %v", compress, gz)
+		}
+
+		dst := NewMemMapFs()
+		if err := ExtractTar(dst, "/dst", &buf); err != nil {
+			t.Fatal(err)
+		}
+		checkArchiveRoundTrip(t, src, dst, "/dst")
+	}
+}
+
+func TestArchiveZip(t *testing.T) {
+	src := setupArchiveFixture(t)
+	for _, compress := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := ArchiveZip(src, "/src", &buf, &ArchiveOptions{Compress: compress}); err != nil {
+			t.Fatal(err)
+		}
+
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst := NewMemMapFs()
+		if err := ExtractZip(dst, "/dst", zr); err != nil {
+			t.Fatal(err)
+		}
+		checkArchiveRoundTrip(t, src, dst, "/dst")
+	}
+}
+
+func TestArchiveSingleFile(t *testing.T) {
+	src := setupArchiveFixture(t)
+	var buf bytes.Buffer
+	if err := ArchiveTar(src, "/src/a.txt", &buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	h, err := tar.NewReader(&buf).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Name != "a.txt" {
+		t.Errorf("got name %q, want %q", h.Name, "a.txt")
+	}
+}
+
+func TestExtractTarHardLink(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "file", Mode: 0644, Size: 4, Typeflag: tar.TypeReg})
+	tw.Write([]byte("data"))
+	tw.WriteHeader(&tar.Header{Name: "hard", Mode: 0644, Linkname: "file", Typeflag: tar.TypeLink})
+	tw.Close()
+
+	fs := NewMemMapFs()
+	if err := ExtractTar(fs, "/dst", &buf); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ReadFile(fs, "/dst/hard")
+	if err != nil || string(data) != "data" {
+		t.Errorf("got %q (%v), want %q", data, err, "data")
+	}
+}
+
+func TestExtractTarLeadingSlash(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	tw.WriteHeader(&tar.Header{Name: "/etc/passwd", Mode: 0644, Size: 1, Typeflag: tar.TypeReg})
+	tw.Write([]byte("x"))
+	tw.Close()
+
+	fs := NewMemMapFs()
+	if err := ExtractTar(fs, "/dst", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/dst/etc/passwd"); err != nil {
+		t.Error(err)
+	}
+	if _, err := fs.Stat("/etc/passwd"); !os.IsNotExist(err) {
+		t.Errorf("file was written outside of the root: %v", err)
+	}
+}
+
+func TestExtractInsecurePath(t *testing.T) {
+	tests := []struct {
+		name string
+		h    tar.Header
+	}{
+		{"parent", tar.Header{Name: "../evil", Typeflag: tar.TypeReg}},
+		{"nested parent", tar.Header{Name: "a/../../evil", Typeflag: tar.TypeReg}},
+		{"dir", tar.Header{Name: "../evil/", Typeflag: tar.TypeDir}},
+		{"symlink out", tar.Header{Name: "a/link", Linkname: "../../etc", Typeflag: tar.TypeSymlink}},
+		{"symlink absolute", tar.Header{Name: "link", Linkname: "/etc", Typeflag: tar.TypeSymlink}},
+		{"hard link out", tar.Header{Name: "hard", Linkname: "../secret", Typeflag: tar.TypeLink}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			tt.h.Mode = 0644
+			tw.WriteHeader(&tt.h)
+			tw.Close()
+
+			base := NewMemMapFs()
+			WriteFile(base, "/secret", []byte("secret"), 0600)
+			err := ExtractTar(base, "/dst", &buf)
+			if !errors.Is(err, ErrInsecurePath) {
+				t.Fatalf("got %v, want %v", err, ErrInsecurePath)
+			}
+			if _, err := base.Stat("/evil"); !os.IsNotExist(err) {
+				t.Errorf("file was written outside of the root: %v", err)
+			}
+		})
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	zw.Create("../evil")
+	zw.Close()
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ExtractZip(NewMemMapFs(), "/dst", zr); !errors.Is(err, ErrInsecurePath) {
+		t.Errorf("zip: got %v, want %v", err, ErrInsecurePath)
+	}
+}
+
+func TestExtractChainedSymlinks(t *testing.T) {
+	// each link stays inside the root, but d/s2 is the parent of the root
+	entries := []struct {
+		name, link string
+	}{
+		{"d/s", ".."},
+		{"d/s2", "s/.."},
+		{"d/s2/evil", ""},
+	}
+
+	var tbuf bytes.Buffer
+	tw := tar.NewWriter(&tbuf)
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	for _, e := range entries {
+		h := &tar.Header{Name: e.name, Mode: 0644, Typeflag: tar.TypeReg}
+		zh := &zip.FileHeader{Name: e.name}
+		zh.SetMode(0644)
+		if e.link != "" {
+			h.Typeflag, h.Linkname = tar.TypeSymlink, e.link
+			zh.SetMode(os.ModeSymlink | 0777)
+		}
+		tw.WriteHeader(h)
+		w, _ := zw.CreateHeader(zh)
+		w.Write([]byte(e.link))
+	}
+	tw.Close()
+	zw.Close()
+
+	osFs := NewOsFs()
+	extract := map[string]func(root string) error{
+		"tar": func(root string) error {
+			return ExtractTar(osFs, root, bytes.NewReader(tbuf.Bytes()))
+		},
+		"zip": func(root string) error {
+			zr, err := zip.NewReader(bytes.NewReader(zbuf.Bytes()), int64(zbuf.Len()))
+			if err != nil {
+				return err
+			}
+			return ExtractZip(osFs, root, zr)
+		},
+	}
+	for name, fn := range extract {
+		t.Run(name, func(t *testing.T) {
+			dir, err := TempDir(osFs, "", "afero-archive")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer osFs.RemoveAll(dir)
+			root := filepath.Join(dir, "root")
+
+			if err := fn(root); !errors.Is(err, ErrInsecurePath) {
+				t.Errorf("got %v, want %v", err, ErrInsecurePath)
+			}
+			if _, err := osFs.Stat(filepath.Join(dir, "evil")); !os.IsNotExist(err) {
+				t.Errorf("file was written outside of the root: %v", err)
+			}
+		})
+	}
+}
+
+func TestExtractReadOnlyDir(t *testing.T) {
+	var tbuf bytes.Buffer
+	tw := tar.NewWriter(&tbuf)
+	tw.WriteHeader(&tar.Header{Name: "ro/", Mode: 0555, Typeflag: tar.TypeDir})
+	tw.WriteHeader(&tar.Header{Name: "ro/sub/", Mode: 0500, Typeflag: tar.TypeDir})
+	tw.WriteHeader(&tar.Header{Name: "ro/sub/f", Mode: 0444, Size: 1, Typeflag: tar.TypeReg})
+	tw.Write([]byte("f"))
+	tw.WriteHeader(&tar.Header{Name: "ro/g", Mode: 0644, Size: 1, Typeflag: tar.TypeReg})
+	tw.Write([]byte("g"))
+	tw.Close()
+
+	var zbuf bytes.Buffer
+	zw := zip.NewWriter(&zbuf)
+	for _, e := range []struct {
+		name string
+		mode os.FileMode
+	}{{"ro/", os.ModeDir | 0555}, {"ro/sub/", os.ModeDir | 0500}, {"ro/sub/f", 0444}, {"ro/g", 0644}} {
+		h := &zip.FileHeader{Name: e.name}
+		h.SetMode(e.mode)
+		w, _ := zw.CreateHeader(h)
+		if !e.mode.IsDir() {
+			w.Write([]byte(e.name[len(e.name)-1:]))
+		}
+	}
+	zw.Close()
+	zr, err := zip.NewReader(bytes.NewReader(zbuf.Bytes()), int64(zbuf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for kind, extract := range map[string]func(Fs) error{
+		"tar": func(fs Fs) error { return ExtractTar(fs, "/dst", bytes.NewReader(tbuf.Bytes())) },
+		"zip": func(fs Fs) error { return ExtractZip(fs, "/dst", zr) },
+	} {
+		fs := &permFs{NewMemMapFs()}
+		if err := extract(fs); err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		for name, want := range map[string]os.FileMode{
+			"/dst/ro":       os.ModeDir | 0555,
+			"/dst/ro/sub":   os.ModeDir | 0500,
+			"/dst/ro/sub/f": 0444,
+			"/dst/ro/g":     0644,
+		} {
+			if fi, err := fs.Stat(name); err != nil || fi.Mode() != want {
+				t.Errorf("%s: %s: got %v, %v, want mode %v", kind, name, fi, err, want)
+			}
+		}
+	}
+}
+
+// permFs fails creating entries in directories without write permission,
+// like OsFs does for users other than root.
+type permFs struct {
+	Fs
+}
+
+func (fs *permFs) writable(name string) error {
+	fi, err := fs.Fs.Stat(filepath.Dir(filepath.Clean(name)))
+	if err == nil && fi.Mode().Perm()&0200 == 0 {
+		return &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	return nil
+}
+
+func (fs *permFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if _, err := fs.Fs.Stat(name); os.IsNotExist(err) && flag&os.O_CREATE != 0 {
+		if err := fs.writable(name); err != nil {
+			return nil, err
+		}
+	}
+	return fs.Fs.OpenFile(name, flag, perm)
+}
+
+func (fs *permFs) Mkdir(name string, perm os.FileMode) error {
+	if err := fs.writable(name); err != nil {
+		return err
+	}
+	return fs.Fs.Mkdir(name, perm)
+}
+
+func (fs *permFs) MkdirAll(name string, perm os.FileMode) error {
+	if fi, err := fs.Fs.Stat(name); err == nil && fi.IsDir() {
+		return nil
+	}
+	if err := fs.MkdirAll(filepath.Dir(filepath.Clean(name)), perm); err != nil {
+		return err
+	}
+	return fs.Mkdir(name, perm)
+}
+
+func TestArchiveOsFs(t *testing.T) {
+	src := setupArchiveFixture(t)
+	var buf bytes.Buffer
+	if err := ArchiveTar(src, "/src", &buf, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	osFs := NewOsFs()
+	dir, err := TempDir(osFs, "", "afero-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer osFs.RemoveAll(dir)
+
+	if err := ExtractTar(osFs, dir, &buf); err != nil {
+		t.Fatal(err)
+	}
+	checkArchiveRoundTrip(t, src, osFs, dir)
+
+	var again bytes.Buffer
+	if err := ArchiveTar(osFs, dir, &again, nil); err != nil {
+		t.Fatal(err)
+	}
+	dst := NewMemMapFs()
+	if err := ExtractTar(dst, "/dst", &again); err != nil {
+		t.Fatal(err)
+	}
+	checkArchiveRoundTrip(t, src, dst, "/dst")
+}
@@ -8,7 +8,9 @@ import (
 	"time"
 )
 
-var _ Lstater = (*BasePathFs)(nil)
+var _ Symlinker = (*BasePathFs)(nil)
+var _ Chowner = (*BasePathFs)(nil)
+var _ Watcher = (*BasePathFs)(nil)
 
 // The BasePathFs restricts all operations to a given path within an Fs.
 // The given file name to the operations on this Fs will be prepended with
@@ -16,11 +18,16 @@ var _ Lstater = (*BasePathFs)(nil)
 // Any file name (after filepath.Clean()) outside this base path will be
 // treated as non existing file.
 //
-// Note that it does not clean the error messages on return, so you may
-// reveal the real path on errors.
+// The symbolic links are followed by the source Fs, including the ones
+// pointing out of the base path. NewSecureBasePathFs returns a BasePathFs
+// which keeps them in.
+//
+// The paths in the errors returned, and the names of the files opened, are
+// the names given to the BasePathFs, not the real paths.
 type BasePathFs struct {
 	source Fs
 	path   string
+	secure bool
 }
 
 type BasePathFile struct {
@@ -29,14 +36,98 @@ type BasePathFile struct {
 }
 
 func (f *BasePathFile) Name() string {
-	sourcename := f.File.Name()
-	return strings.TrimPrefix(sourcename, filepath.Clean(f.path))
+	return virtualPath(filepath.Clean(f.path), f.File.Name())
+}
+
+func (f *BasePathFile) pathErr(err error) error {
+	return basePathErr(filepath.Clean(f.path), err)
+}
+
+func (f *BasePathFile) Close() error {
+	return f.pathErr(f.File.Close())
+}
+
+func (f *BasePathFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	return n, f.pathErr(err)
+}
+
+func (f *BasePathFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(p, off)
+	return n, f.pathErr(err)
+}
+
+func (f *BasePathFile) Seek(offset int64, whence int) (int64, error) {
+	n, err := f.File.Seek(offset, whence)
+	return n, f.pathErr(err)
+}
+
+func (f *BasePathFile) Write(p []byte) (int, error) {
+	n, err := f.File.Write(p)
+	return n, f.pathErr(err)
+}
+
+func (f *BasePathFile) WriteAt(p []byte, off int64) (int, error) {
+	n, err := f.File.WriteAt(p, off)
+	return n, f.pathErr(err)
+}
+
+func (f *BasePathFile) WriteString(s string) (int, error) {
+	n, err := f.File.WriteString(s)
+	return n, f.pathErr(err)
+}
+
+func (f *BasePathFile) Readdir(count int) ([]os.FileInfo, error) {
+	fis, err := f.File.Readdir(count)
+	return fis, f.pathErr(err)
+}
+
+func (f *BasePathFile) Readdirnames(n int) ([]string, error) {
+	names, err := f.File.Readdirnames(n)
+	return names, f.pathErr(err)
+}
+
+func (f *BasePathFile) Stat() (os.FileInfo, error) {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return nil, f.pathErr(err)
+	}
+	return basePathInfo(f.path, f.File.Name(), fi), nil
+}
+
+func (f *BasePathFile) Sync() error {
+	return f.pathErr(f.File.Sync())
+}
+
+func (f *BasePathFile) Truncate(size int64) error {
+	return f.pathErr(f.File.Truncate(size))
 }
 
 func NewBasePathFs(source Fs, path string) Fs {
 	return &BasePathFs{source: source, path: path}
 }
 
+// NewSecureBasePathFs returns a BasePathFs which follows the symbolic links
+// itself, so that they cannot lead out of path: a name is resolved one
+// component at a time, with the absolute links taken relative to path and
+// ".." stopping at path, like in a chroot.
+//
+// Over an OsFs on Linux, the files are then opened from path one directory
+// at a time with O_NOFOLLOW, with the openat family of calls, so a link
+// put in place of a directory meanwhile fails the call instead of leading
+// out. On other systems, and over other file systems, the resolution is
+// not atomic with the call.
+func NewSecureBasePathFs(source Fs, path string) Fs {
+	b := &BasePathFs{source: source, path: path, secure: true}
+	switch source.(type) {
+	case *OsFs, OsFs:
+		if root := newOsRootFs(filepath.Clean(path)); root != nil {
+			b.source = root
+		}
+	}
+	return b
+}
+
 // on a file outside the base path it returns the given file name and an error,
 // else the given file with the base path prepended
 func (b *BasePathFs) RealPath(name string) (path string, err error) {
@@ -46,13 +137,20 @@ func (b *BasePathFs) RealPath(name string) (path string, err error) {
 
 	bpath := filepath.Clean(b.path)
 	path = filepath.Clean(filepath.Join(bpath, name))
-	if !strings.HasPrefix(path, bpath) {
+	if !inBasePath(bpath, path) {
 		return name, os.ErrNotExist
 	}
 
 	return path, nil
 }
 
+// inBasePath reports whether path is bpath or below it. /base/x is in /base,
+// /basement is not.
+func inBasePath(bpath, path string) bool {
+	rel, err := filepath.Rel(bpath, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 func validateBasePathName(name string) error {
 	if runtime.GOOS != "windows" {
 		// Not much to do here;
@@ -70,17 +168,37 @@ func validateBasePathName(name string) error {
 }
 
 func (b *BasePathFs) Chtimes(name string, atime, mtime time.Time) (err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if name, err = b.realPath(name, true); err != nil {
 		return &os.PathError{Op: "chtimes", Path: name, Err: err}
 	}
-	return b.source.Chtimes(name, atime, mtime)
+	return b.pathErr(b.source.Chtimes(name, atime, mtime))
 }
 
 func (b *BasePathFs) Chmod(name string, mode os.FileMode) (err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if name, err = b.realPath(name, true); err != nil {
 		return &os.PathError{Op: "chmod", Path: name, Err: err}
 	}
-	return b.source.Chmod(name, mode)
+	return b.pathErr(b.source.Chmod(name, mode))
+}
+
+func (b *BasePathFs) Chown(name string, uid, gid int) (err error) {
+	if name, err = b.realPath(name, true); err != nil {
+		return &os.PathError{Op: "chown", Path: name, Err: err}
+	}
+	if chowner, ok := b.source.(Chowner); ok {
+		return b.pathErr(chowner.Chown(name, uid, gid))
+	}
+	return b.pathErr(&os.PathError{Op: "chown", Path: name, Err: ErrNoChown})
+}
+
+func (b *BasePathFs) Lchown(name string, uid, gid int) (err error) {
+	if name, err = b.realPath(name, false); err != nil {
+		return &os.PathError{Op: "lchown", Path: name, Err: err}
+	}
+	if chowner, ok := b.source.(Chowner); ok {
+		return b.pathErr(chowner.Lchown(name, uid, gid))
+	}
+	return b.pathErr(&os.PathError{Op: "lchown", Path: name, Err: ErrNoChown})
 }
 
 func (b *BasePathFs) Name() string {
@@ -88,93 +206,213 @@ func (b *BasePathFs) Name() string {
 }
 
 func (b *BasePathFs) Stat(name string) (fi os.FileInfo, err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if name, err = b.realPath(name, true); err != nil {
 		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
 	}
-	return b.source.Stat(name)
+	fi, err = b.source.Stat(name)
+	if err != nil {
+		return nil, b.pathErr(err)
+	}
+	return basePathInfo(b.path, name, fi), nil
 }
 
 func (b *BasePathFs) Rename(oldname, newname string) (err error) {
-	if oldname, err = b.RealPath(oldname); err != nil {
+	if oldname, err = b.realPath(oldname, false); err != nil {
 		return &os.PathError{Op: "rename", Path: oldname, Err: err}
 	}
-	if newname, err = b.RealPath(newname); err != nil {
+	if newname, err = b.realPath(newname, false); err != nil {
 		return &os.PathError{Op: "rename", Path: newname, Err: err}
 	}
-	return b.source.Rename(oldname, newname)
+	return b.pathErr(b.source.Rename(oldname, newname))
 }
 
 func (b *BasePathFs) RemoveAll(name string) (err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if name, err = b.realPath(name, false); err != nil {
 		return &os.PathError{Op: "remove_all", Path: name, Err: err}
 	}
-	return b.source.RemoveAll(name)
+	return b.pathErr(b.source.RemoveAll(name))
 }
 
 func (b *BasePathFs) Remove(name string) (err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if name, err = b.realPath(name, false); err != nil {
 		return &os.PathError{Op: "remove", Path: name, Err: err}
 	}
-	return b.source.Remove(name)
+	return b.pathErr(b.source.Remove(name))
 }
 
 func (b *BasePathFs) OpenFile(name string, flag int, mode os.FileMode) (f File, err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if name, err = b.realPath(name, true); err != nil {
 		return nil, &os.PathError{Op: "openfile", Path: name, Err: err}
 	}
 	sourcef, err := b.source.OpenFile(name, flag, mode)
 	if err != nil {
-		return nil, err
+		return nil, b.pathErr(err)
 	}
 	return &BasePathFile{sourcef, b.path}, nil
 }
 
 func (b *BasePathFs) Open(name string) (f File, err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if name, err = b.realPath(name, true); err != nil {
 		return nil, &os.PathError{Op: "open", Path: name, Err: err}
 	}
 	sourcef, err := b.source.Open(name)
 	if err != nil {
-		return nil, err
+		return nil, b.pathErr(err)
 	}
 	return &BasePathFile{File: sourcef, path: b.path}, nil
 }
 
 func (b *BasePathFs) Mkdir(name string, mode os.FileMode) (err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if name, err = b.realPath(name, false); err != nil {
 		return &os.PathError{Op: "mkdir", Path: name, Err: err}
 	}
-	return b.source.Mkdir(name, mode)
+	return b.pathErr(b.source.Mkdir(name, mode))
 }
 
 func (b *BasePathFs) MkdirAll(name string, mode os.FileMode) (err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if name, err = b.realPath(name, true); err != nil {
 		return &os.PathError{Op: "mkdir", Path: name, Err: err}
 	}
-	return b.source.MkdirAll(name, mode)
+	return b.pathErr(b.source.MkdirAll(name, mode))
 }
 
 func (b *BasePathFs) Create(name string) (f File, err error) {
-	if name, err = b.RealPath(name); err != nil {
+	if name, err = b.realPath(name, true); err != nil {
 		return nil, &os.PathError{Op: "create", Path: name, Err: err}
 	}
 	sourcef, err := b.source.Create(name)
 	if err != nil {
-		return nil, err
+		return nil, b.pathErr(err)
 	}
 	return &BasePathFile{File: sourcef, path: b.path}, nil
 }
 
 func (b *BasePathFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
-	name, err := b.RealPath(name)
+	name, err := b.realPath(name, false)
 	if err != nil {
 		return nil, false, &os.PathError{Op: "lstat", Path: name, Err: err}
 	}
+	var fi os.FileInfo
+	lstat := false
 	if lstater, ok := b.source.(Lstater); ok {
-		return lstater.LstatIfPossible(name)
+		fi, lstat, err = lstater.LstatIfPossible(name)
+	} else {
+		fi, err = b.source.Stat(name)
+	}
+	if err != nil {
+		return nil, lstat, b.pathErr(err)
+	}
+	return basePathInfo(b.path, name, fi), lstat, nil
+}
+
+// SymlinkIfPossible creates newname as a symbolic link to oldname. An
+// absolute oldname is taken to be inside the base path, relative ones are
+// stored as they are.
+func (b *BasePathFs) SymlinkIfPossible(oldname, newname string) error {
+	if filepath.IsAbs(oldname) {
+		var err error
+		if oldname, err = b.RealPath(oldname); err != nil {
+			return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+		}
+	}
+	newname, err := b.realPath(newname, false)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	if linker, ok := b.source.(Linker); ok {
+		return b.pathErr(linker.SymlinkIfPossible(oldname, newname))
+	}
+	return b.pathErr(&os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink})
+}
+
+// ReadlinkIfPossible returns the target of the named symbolic link. Absolute
+// targets inside the base path are returned relative to it.
+func (b *BasePathFs) ReadlinkIfPossible(name string) (string, error) {
+	name, err := b.realPath(name, false)
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	reader, ok := b.source.(LinkReader)
+	if !ok {
+		return "", b.pathErr(&os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink})
+	}
+	target, err := reader.ReadlinkIfPossible(name)
+	if err != nil {
+		return "", b.pathErr(err)
 	}
-	fi, err := b.source.Stat(name)
-	return fi, false, err
+	if !filepath.IsAbs(target) {
+		return target, nil
+	}
+	return virtualPath(filepath.Clean(b.path), target), nil
+}
+
+// pathErr returns err with the real paths in it replaced by the names given
+// to b, so that the errors do not reveal the base path.
+func (b *BasePathFs) pathErr(err error) error {
+	return basePathErr(filepath.Clean(b.path), err)
+}
+
+// basePathErr replaces the paths below bpath in the *os.PathError,
+// *os.LinkError and *os.SyscallError err, and in the errors they wrap, by
+// the paths relative to bpath.
+func basePathErr(bpath string, err error) error {
+	switch e := err.(type) {
+	case *os.PathError:
+		return &os.PathError{Op: e.Op, Path: virtualPath(bpath, e.Path), Err: basePathErr(bpath, e.Err)}
+	case *os.LinkError:
+		// the target of a link is not a path of the source when relative
+		old := e.Old
+		if e.Op != "symlink" || filepath.IsAbs(old) {
+			old = virtualPath(bpath, old)
+		}
+		return &os.LinkError{Op: e.Op, Old: old, New: virtualPath(bpath, e.New), Err: basePathErr(bpath, e.Err)}
+	case *os.SyscallError:
+		return &os.SyscallError{Syscall: e.Syscall, Err: basePathErr(bpath, e.Err)}
+	}
+	return err
+}
+
+// virtualPath returns the path below bpath relative to it, and any other
+// path as it is.
+func virtualPath(bpath, path string) string {
+	if !inBasePath(bpath, path) {
+		return path
+	}
+	rel, _ := filepath.Rel(bpath, path)
+	return filepath.Join(FilePathSeparator, rel)
+}
+
+// basePathInfo names the os.FileInfo fi of the base path itself after the
+// root, instead of the base directory.
+func basePathInfo(bpath, name string, fi os.FileInfo) os.FileInfo {
+	if filepath.Clean(name) == filepath.Clean(bpath) && fi.Name() != FilePathSeparator {
+		return namedInfo{FileInfo: fi, name: FilePathSeparator}
+	}
+	return fi
+}
+
+// Watch watches name inside the base path. The names of the events are
+// relative to the base path, like the names given to b.
+func (b *BasePathFs) Watch(name string, recursive bool) (Watch, error) {
+	name, err := b.realPath(name, true)
+	if err != nil {
+		return nil, &os.PathError{Op: "watch", Path: name, Err: err}
+	}
+	watcher, ok := b.source.(Watcher)
+	if !ok {
+		return nil, b.pathErr(&os.PathError{Op: "watch", Path: name, Err: ErrNoWatch})
+	}
+	w, err := watcher.Watch(name, recursive)
+	if err != nil {
+		return nil, b.pathErr(err)
+	}
+	bpath := filepath.Clean(b.path)
+	return mapWatch(w, func(name string) (string, bool) {
+		if !inBasePath(bpath, name) {
+			return "", false
+		}
+		return virtualPath(bpath, name), true
+	}), nil
 }
 
 // vim: ts=4 sw=4 noexpandtab nolist syn=go
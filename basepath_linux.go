@@ -0,0 +1,374 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// missing from the syscall package
+const (
+	oPath             = 0x200000
+	atRemoveDir       = 0x200
+	atSymlinkNoFollow = 0x100
+)
+
+var _ Symlinker = (*osRootFs)(nil)
+var _ Chowner = (*osRootFs)(nil)
+var _ Watcher = (*osRootFs)(nil)
+
+// osRootFs is the OsFs below root, for a secure BasePathFs. The names are
+// the real paths, with the symbolic links resolved. Every name is opened
+// from root one directory at a time with O_NOFOLLOW, so a directory
+// replaced by a link fails the call with ELOOP or ENOTDIR, and the last
+// component is never followed either.
+type osRootFs struct {
+	root string
+}
+
+func newOsRootFs(root string) Fs {
+	return &osRootFs{root: root}
+}
+
+// parent opens the directory holding name and returns it with the last
+// component of name, "." for root itself.
+func (r *osRootFs) parent(name string) (int, string, error) {
+	if !inBasePath(r.root, name) {
+		return -1, "", os.ErrNotExist
+	}
+	rel, _ := filepath.Rel(r.root, name)
+	fd, err := syscall.Open(r.root, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, "", err
+	}
+	parts := strings.Split(rel, FilePathSeparator)
+	for _, p := range parts[:len(parts)-1] {
+		next, err := openDirAt(fd, p)
+		syscall.Close(fd)
+		if err != nil {
+			return -1, "", err
+		}
+		fd = next
+	}
+	return fd, parts[len(parts)-1], nil
+}
+
+func openDirAt(fd int, name string) (int, error) {
+	return syscall.Openat(fd, name, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+}
+
+// at calls fn with the directory holding name and the last component of
+// name. The errors are returned as *os.PathError of op.
+func (r *osRootFs) at(op, name string, fn func(dirfd int, last string) error) error {
+	fd, last, err := r.parent(name)
+	if err == nil {
+		err = fn(fd, last)
+		syscall.Close(fd)
+	}
+	if err != nil {
+		return &os.PathError{Op: op, Path: name, Err: err}
+	}
+	return nil
+}
+
+func (r *osRootFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	var f *os.File
+	err := r.at("open", name, func(dirfd int, last string) error {
+		fd, err := syscall.Openat(dirfd, last, flag|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, uint32(perm.Perm()))
+		if err != nil {
+			return err
+		}
+		f = os.NewFile(uintptr(fd), name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (r *osRootFs) Open(name string) (File, error) {
+	return r.OpenFile(name, os.O_RDONLY, 0)
+}
+
+func (r *osRootFs) Create(name string) (File, error) {
+	return r.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+}
+
+// inode calls fn with the path in /proc of name opened with O_PATH, which
+// must not be a symbolic link. This is how a file is changed without
+// following a link put in its place, where there is no call taking a
+// file descriptor which works on any file.
+func (r *osRootFs) inode(op, name string, fn func(proc string) error) error {
+	return r.at(op, name, func(dirfd int, last string) error {
+		fd, err := syscall.Openat(dirfd, last, oPath|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return err
+		}
+		defer syscall.Close(fd)
+		var st syscall.Stat_t
+		if err := syscall.Fstat(fd, &st); err != nil {
+			return err
+		}
+		if st.Mode&syscall.S_IFMT == syscall.S_IFLNK {
+			return syscall.ELOOP
+		}
+		err = fn("/proc/self/fd/" + strconv.Itoa(fd))
+		if e, ok := err.(*os.PathError); ok {
+			err = e.Err
+		}
+		return err
+	})
+}
+
+func (r *osRootFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	var fi os.FileInfo
+	err := r.at("lstat", name, func(dirfd int, last string) error {
+		fd, err := syscall.Openat(dirfd, last, oPath|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+		if err != nil {
+			return err
+		}
+		f := os.NewFile(uintptr(fd), name)
+		defer f.Close()
+		if fi, err = f.Stat(); err != nil {
+			return err.(*os.PathError).Err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, true, err
+	}
+	return fi, true, nil
+}
+
+// Stat is LstatIfPossible, the names given have no links left to follow.
+func (r *osRootFs) Stat(name string) (os.FileInfo, error) {
+	fi, _, err := r.LstatIfPossible(name)
+	return fi, err
+}
+
+func (r *osRootFs) Mkdir(name string, perm os.FileMode) error {
+	return r.at("mkdir", name, func(dirfd int, last string) error {
+		return syscall.Mkdirat(dirfd, last, uint32(perm.Perm()))
+	})
+}
+
+func (r *osRootFs) MkdirAll(path string, perm os.FileMode) error {
+	if !inBasePath(r.root, path) {
+		return &os.PathError{Op: "mkdir", Path: path, Err: os.ErrNotExist}
+	}
+	rel, _ := filepath.Rel(r.root, path)
+	fd, err := syscall.Open(r.root, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: path, Err: err}
+	}
+	for _, p := range splitPath(rel) {
+		if p == "." {
+			continue
+		}
+		if err := syscall.Mkdirat(fd, p, uint32(perm.Perm())); err != nil && err != syscall.EEXIST {
+			syscall.Close(fd)
+			return &os.PathError{Op: "mkdir", Path: path, Err: err}
+		}
+		next, err := openDirAt(fd, p)
+		syscall.Close(fd)
+		if err != nil {
+			return &os.PathError{Op: "mkdir", Path: path, Err: err}
+		}
+		fd = next
+	}
+	syscall.Close(fd)
+	return nil
+}
+
+func unlinkat(dirfd int, name string, flags int) error {
+	p, err := syscall.BytePtrFromString(name)
+	if err != nil {
+		return err
+	}
+	_, _, e := syscall.Syscall(syscall.SYS_UNLINKAT, uintptr(dirfd), uintptr(unsafe.Pointer(p)), uintptr(flags))
+	if e != 0 {
+		return e
+	}
+	return nil
+}
+
+// removeAt removes the file or empty directory name, like os.Remove.
+func removeAt(dirfd int, name string) error {
+	err := unlinkat(dirfd, name, 0)
+	if err == nil {
+		return nil
+	}
+	err1 := unlinkat(dirfd, name, atRemoveDir)
+	if err1 == nil {
+		return nil
+	}
+	if err1 != syscall.ENOTDIR {
+		err = err1
+	}
+	return err
+}
+
+func (r *osRootFs) Remove(name string) error {
+	return r.at("remove", name, removeAt)
+}
+
+// removeAllAt removes name and everything below it, without following the
+// links in it.
+func removeAllAt(dirfd int, name string) error {
+	err := removeAt(dirfd, name)
+	if err == nil || err == syscall.ENOENT {
+		return nil
+	}
+	fd, err1 := openDirAt(dirfd, name)
+	if err1 != nil {
+		return err
+	}
+	dir := os.NewFile(uintptr(fd), name)
+	names, err := dir.Readdirnames(-1)
+	for _, n := range names {
+		if err1 := removeAllAt(fd, n); err1 != nil && err == nil {
+			err = err1
+		}
+	}
+	dir.Close()
+	if err != nil {
+		if e, ok := err.(*os.PathError); ok {
+			err = e.Err
+		}
+		return err
+	}
+	if err := unlinkat(dirfd, name, atRemoveDir); err != nil && err != syscall.ENOENT {
+		return err
+	}
+	return nil
+}
+
+func (r *osRootFs) RemoveAll(path string) error {
+	err := r.at("removeall", path, removeAllAt)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (r *osRootFs) Rename(oldname, newname string) error {
+	err := r.at("rename", oldname, func(olddirfd int, oldlast string) error {
+		return r.at("rename", newname, func(newdirfd int, newlast string) error {
+			return syscall.Renameat(olddirfd, oldlast, newdirfd, newlast)
+		})
+	})
+	if err != nil {
+		for {
+			e, ok := err.(*os.PathError)
+			if !ok {
+				break
+			}
+			err = e.Err
+		}
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	return nil
+}
+
+func (r *osRootFs) Chmod(name string, mode os.FileMode) error {
+	return r.inode("chmod", name, func(proc string) error {
+		return os.Chmod(proc, mode)
+	})
+}
+
+func (r *osRootFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return r.inode("chtimes", name, func(proc string) error {
+		return os.Chtimes(proc, atime, mtime)
+	})
+}
+
+// Chown is Lchown, the names given have no links left to follow.
+func (r *osRootFs) Chown(name string, uid, gid int) error {
+	return r.at("chown", name, func(dirfd int, last string) error {
+		return syscall.Fchownat(dirfd, last, uid, gid, atSymlinkNoFollow)
+	})
+}
+
+func (r *osRootFs) Lchown(name string, uid, gid int) error {
+	return r.at("lchown", name, func(dirfd int, last string) error {
+		return syscall.Fchownat(dirfd, last, uid, gid, atSymlinkNoFollow)
+	})
+}
+
+func (r *osRootFs) SymlinkIfPossible(oldname, newname string) error {
+	err := r.at("symlink", newname, func(dirfd int, last string) error {
+		o, err := syscall.BytePtrFromString(oldname)
+		if err != nil {
+			return err
+		}
+		n, err := syscall.BytePtrFromString(last)
+		if err != nil {
+			return err
+		}
+		_, _, e := syscall.Syscall(syscall.SYS_SYMLINKAT, uintptr(unsafe.Pointer(o)), uintptr(dirfd), uintptr(unsafe.Pointer(n)))
+		if e != 0 {
+			return e
+		}
+		return nil
+	})
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err.(*os.PathError).Err}
+	}
+	return nil
+}
+
+func (r *osRootFs) ReadlinkIfPossible(name string) (string, error) {
+	var target string
+	err := r.at("readlink", name, func(dirfd int, last string) error {
+		p, err := syscall.BytePtrFromString(last)
+		if err != nil {
+			return err
+		}
+		for size := 128; ; size *= 2 {
+			buf := make([]byte, size)
+			n, _, e := syscall.Syscall6(syscall.SYS_READLINKAT, uintptr(dirfd), uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&buf[0])), uintptr(size), 0, 0)
+			if e != 0 {
+				return e
+			}
+			if int(n) < size {
+				target = string(buf[:n])
+				return nil
+			}
+		}
+	})
+	return target, err
+}
+
+// Watch watches name with inotify, which does not follow the links below
+// name either.
+func (r *osRootFs) Watch(name string, recursive bool) (Watch, error) {
+	if !inBasePath(r.root, name) {
+		return nil, &os.PathError{Op: "watch", Path: name, Err: os.ErrNotExist}
+	}
+	return watchOs(name, recursive)
+}
+
+func (r *osRootFs) Name() string {
+	return "OsFs"
+}
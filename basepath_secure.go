@@ -0,0 +1,119 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// maxSymlinks is the number of symbolic links followed in a name before
+// giving up with ELOOP, like the 40 of Linux.
+const maxSymlinks = 40
+
+// realPath is RealPath for a BasePathFs which is not secure. A secure one
+// resolves the symbolic links in name inside the base path, the last
+// component only if follow is set.
+func (b *BasePathFs) realPath(name string, follow bool) (string, error) {
+	if !b.secure {
+		return b.RealPath(name)
+	}
+	if err := validateBasePathName(name); err != nil {
+		return name, err
+	}
+	path, err := b.resolve(name, follow)
+	if err != nil {
+		// the path in it is a real one
+		if e, ok := err.(*os.PathError); ok {
+			err = e.Err
+		}
+		return name, err
+	}
+	return path, nil
+}
+
+// resolve follows the symbolic links in name like a chroot to the base path
+// would: the absolute links are taken relative to the base path, unless
+// they point inside it, and ".." does not go above it. The components
+// which do not exist are kept as they are.
+func (b *BasePathFs) resolve(name string, follow bool) (string, error) {
+	bpath := filepath.Clean(b.path)
+	sep := string(filepath.Separator)
+	todo := splitPath(filepath.Clean(sep + name))
+	var done []string
+	links := 0
+	for len(todo) > 0 {
+		c := todo[0]
+		todo = todo[1:]
+		switch c {
+		case ".":
+			continue
+		case "..":
+			if len(done) > 0 {
+				done = done[:len(done)-1]
+			}
+			continue
+		}
+
+		path := filepath.Join(append([]string{bpath}, append(done, c)...)...)
+		if len(todo) == 0 && !follow {
+			done = append(done, c)
+			continue
+		}
+		fi, err := lstatIfPossible(b.source, path)
+		if os.IsNotExist(err) {
+			done = append(done, c)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			done = append(done, c)
+			continue
+		}
+
+		if links++; links > maxSymlinks {
+			return "", syscall.ELOOP
+		}
+		target, err := readlinkIfPossible(b.source, path)
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			// a link made by SymlinkIfPossible points into the base path
+			if rel, err := filepath.Rel(bpath, target); err == nil && inBasePath(bpath, target) {
+				target = rel
+			} else if vol := filepath.VolumeName(target); vol != "" {
+				target = strings.TrimPrefix(target, vol)
+			}
+			done = nil
+		}
+		todo = append(splitPath(target), todo...)
+	}
+	return filepath.Join(append([]string{bpath}, done...)...), nil
+}
+
+// splitPath returns the components of path, without the empty ones.
+func splitPath(path string) []string {
+	var parts []string
+	for _, p := range strings.Split(filepath.ToSlash(path), "/") {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
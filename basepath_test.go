@@ -4,7 +4,9 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestBasePath(t *testing.T) {
@@ -188,3 +190,168 @@ func TestBasePathTempFile(t *testing.T) {
 		t.Fatalf("TempFile realpath leaked: expected %s, got %s", expected, actual)
 	}
 }
+
+func TestBasePathPrefix(t *testing.T) {
+	bp := NewBasePathFs(&MemMapFs{}, "/base").(*BasePathFs)
+	if _, err := bp.RealPath("../basement/x"); err != os.ErrNotExist {
+		t.Errorf("/basement is not in /base, got %v", err)
+	}
+	if _, err := bp.RealPath("x/../../base/x"); err != nil {
+		t.Errorf("got %v", err)
+	}
+}
+
+// linkingOsFs is an OsFs the secure BasePathFs does not recognize.
+type linkingOsFs struct {
+	*OsFs
+}
+
+func TestSecureBasePath(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symbolic links need privileges on Windows")
+	}
+	osFs := &OsFs{}
+	dir, err := TempDir(osFs, "", "afero-secure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer osFs.RemoveAll(dir)
+	base, outside := filepath.Join(dir, "base"), filepath.Join(dir, "outside")
+	osFs.MkdirAll(filepath.Join(base, "dir"), 0755)
+	osFs.MkdirAll(outside, 0755)
+	WriteFile(osFs, filepath.Join(base, "dir", "file"), []byte("inside"), 0644)
+	WriteFile(osFs, filepath.Join(outside, "secret"), []byte("secret"), 0644)
+	for link, target := range map[string]string{
+		"rel":  "../outside",
+		"abs":  outside,
+		"good": "dir/file",
+		"root": "/dir",
+		"loop": "loop",
+	} {
+		if err := os.Symlink(target, filepath.Join(base, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for name, fs := range map[string]Fs{
+		"OsFs":        NewSecureBasePathFs(osFs, base),
+		"linkingOsFs": NewSecureBasePathFs(linkingOsFs{osFs}, base),
+	} {
+		for _, file := range []string{"/rel/secret", "/abs/secret", "/dir/../rel/secret"} {
+			if _, err := ReadFile(fs, file); !os.IsNotExist(err) {
+				t.Errorf("%s: %s: expected not exist, got %v", name, file, err)
+			}
+		}
+		for _, file := range []string{"/good", "/root/file", "/dir/file"} {
+			if data, err := ReadFile(fs, file); err != nil || string(data) != "inside" {
+				t.Errorf("%s: %s: got %q, %v", name, file, data, err)
+			}
+		}
+		if err := WriteFile(fs, "/rel/new", []byte("x"), 0644); err == nil {
+			t.Errorf("%s: wrote through the link", name)
+		}
+		if err := fs.MkdirAll("/abs/sub", 0755); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		for _, file := range []string{"new", "sub"} {
+			if _, err := os.Lstat(filepath.Join(outside, file)); !os.IsNotExist(err) {
+				t.Errorf("%s: %s created outside of the base path", name, file)
+			}
+		}
+		if _, err := fs.Stat("/loop"); err == nil {
+			t.Errorf("%s: followed a link loop", name)
+		}
+		if fi, _, err := fs.(Lstater).LstatIfPossible("/rel"); err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("%s: lstat of the link: %v, %v", name, fi, err)
+		}
+		// made inside the base path, like in a chroot
+		if ok, _ := DirExists(osFs, filepath.Join(base, outside, "sub")); !ok {
+			t.Errorf("%s: sub not created in the base path", name)
+		}
+		osFs.RemoveAll(filepath.Join(base, splitPath(outside)[0]))
+	}
+
+	if runtime.GOOS == "linux" {
+		// a directory replaced by a link after the name was resolved
+		root := NewSecureBasePathFs(osFs, base).(*BasePathFs).source
+		if _, err := root.Open(filepath.Join(base, "rel", "secret")); err == nil {
+			t.Error("opened through a link")
+		}
+	}
+}
+
+func TestBasePathErrors(t *testing.T) {
+	osFs := &OsFs{}
+	dir, err := TempDir(osFs, "", "afero-errors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer osFs.RemoveAll(dir)
+
+	for name, bp := range map[string]Fs{
+		"BasePathFs":       NewBasePathFs(osFs, dir),
+		"SecureBasePathFs": NewSecureBasePathFs(osFs, dir),
+		"nested":           NewBasePathFs(NewBasePathFs(osFs, filepath.Dir(dir)), string(os.PathSeparator)+filepath.Base(dir)),
+	} {
+		WriteFile(bp, "/file", []byte("data"), 0644)
+		bp.Mkdir("/dir", 0755)
+		missing := filepath.Join(string(os.PathSeparator), "missing")
+		file := filepath.Join(string(os.PathSeparator), "file")
+		ro, err := bp.Open("/file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		closed, _ := bp.Open("/file")
+		closed.Close()
+
+		errs := map[string]error{
+			"Chmod":     bp.Chmod("/missing", 0644),
+			"Chtimes":   bp.Chtimes("/missing", time.Now(), time.Now()),
+			"Mkdir":     bp.Mkdir("/dir", 0755),
+			"MkdirAll":  bp.MkdirAll("/file/sub", 0755),
+			"Remove":    bp.Remove("/missing"),
+			"RemoveAll": bp.RemoveAll("/file/sub"),
+			"Rename":    bp.Rename("/missing", "/other"),
+			"Symlink":   bp.(Linker).SymlinkIfPossible("file", "/file"),
+			"Write":     func() error { _, err := ro.Write([]byte("x")); return err }(),
+			"Truncate":  ro.Truncate(0),
+			"Readdir":   func() error { _, err := ro.Readdir(-1); return err }(),
+			"ReadAt":    func() error { _, err := closed.ReadAt(make([]byte, 1), 0); return err }(),
+			"Close":     closed.Close(),
+		}
+		_, errs["Stat"] = bp.Stat("/missing")
+		_, errs["Open"] = bp.Open("/missing")
+		_, errs["OpenFile"] = bp.OpenFile("/file", os.O_CREATE|os.O_EXCL, 0644)
+		_, errs["Create"] = bp.Create("/dir")
+		_, _, errs["Lstat"] = bp.(Lstater).LstatIfPossible("/missing")
+		_, errs["Readlink"] = bp.(LinkReader).ReadlinkIfPossible("/file")
+		ro.Close()
+
+		for op, err := range errs {
+			if err == nil {
+				t.Errorf("%s: %s: expected an error", name, op)
+				continue
+			}
+			if strings.Contains(err.Error(), dir) {
+				t.Errorf("%s: %s: the base path leaked: %v", name, op, err)
+			}
+			switch e := err.(type) {
+			case *os.PathError:
+				if e.Path != missing && e.Path != file && !strings.HasPrefix(e.Path, string(os.PathSeparator)) {
+					t.Errorf("%s: %s: got path %s", name, op, e.Path)
+				}
+			case *os.LinkError:
+			default:
+				t.Errorf("%s: %s: got %T", name, op, err)
+			}
+		}
+		if !os.IsNotExist(errs["Stat"]) || !os.IsExist(errs["Mkdir"]) {
+			t.Errorf("%s: the errors changed: %v, %v", name, errs["Stat"], errs["Mkdir"])
+		}
+
+		fi, err := bp.Stat("/")
+		if err != nil || fi.Name() != string(os.PathSeparator) {
+			t.Errorf("%s: the root is named %s, %v", name, fi.Name(), err)
+		}
+	}
+}
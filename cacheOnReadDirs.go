@@ -0,0 +1,259 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// cacheLookups keeps the names missing in the base and the directory
+// listings of a CacheOnReadFs in memory, for a limited time.
+type cacheLookups struct {
+	missingTime time.Duration
+	dirTime     time.Duration
+
+	mu      sync.Mutex
+	missing map[string]time.Time // name -> expiry
+	dirs    map[string]*cacheListing
+}
+
+// cacheListing is a directory listing, sorted by name.
+type cacheListing struct {
+	fi      os.FileInfo
+	entries []os.FileInfo
+	expires time.Time
+}
+
+// stat answers a Stat of name in the base from the cached lookups: name is
+// known to be missing, a listed directory or an entry of a listed directory.
+func (c *cacheLookups) stat(name string) (fi os.FileInfo, err error, ok bool) {
+	if c.missingTime <= 0 && c.dirTime <= 0 {
+		return nil, nil, false
+	}
+	name = filepath.Clean(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+
+	if expires, found := c.missing[name]; found {
+		if now.Before(expires) {
+			return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}, true
+		}
+		delete(c.missing, name)
+	}
+	if l := c.listing(name, now); l != nil {
+		return l.fi, nil, true
+	}
+	dir := filepath.Dir(name)
+	if dir == name {
+		return nil, nil, false
+	}
+	if l := c.listing(dir, now); l != nil {
+		base := filepath.Base(name)
+		for _, fi := range l.entries {
+			if fi.Name() == base {
+				return fi, nil, true
+			}
+		}
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}, true
+	}
+	return nil, nil, false
+}
+
+// listing returns the valid listing of the directory name, or nil. The
+// caller must hold c.mu.
+func (c *cacheLookups) listing(name string, now time.Time) *cacheListing {
+	l, found := c.dirs[name]
+	if !found {
+		return nil
+	}
+	if !now.Before(l.expires) {
+		delete(c.dirs, name)
+		return nil
+	}
+	return l
+}
+
+// notExist records that name is missing in the base.
+func (c *cacheLookups) notExist(name string) {
+	if c.missingTime <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.missing == nil {
+		c.missing = make(map[string]time.Time)
+	}
+	c.missing[filepath.Clean(name)] = time.Now().Add(c.missingTime)
+}
+
+// openDir returns the cached listing of the directory name as a File, or
+// fills the cache with the one of the directory opened by open.
+func (c *cacheLookups) openDir(name string, open func() (File, error)) (File, error) {
+	if c.dirTime <= 0 {
+		return open()
+	}
+	name = filepath.Clean(name)
+	c.mu.Lock()
+	l := c.listing(name, time.Now())
+	c.mu.Unlock()
+
+	if l == nil {
+		f, err := open()
+		if err != nil {
+			return nil, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		entries, err := f.Readdir(-1)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+		l = &cacheListing{fi: fi, entries: entries, expires: time.Now().Add(c.dirTime)}
+
+		c.mu.Lock()
+		if c.dirs == nil {
+			c.dirs = make(map[string]*cacheListing)
+		}
+		c.dirs[name] = l
+		c.mu.Unlock()
+	}
+
+	// a copy, the callers may sort it in place
+	entries := make([]os.FileInfo, len(l.entries))
+	copy(entries, l.entries)
+	return &cacheDirFile{name: name, fi: l.fi, entries: entries}, nil
+}
+
+// forget drops what is known about name, the files below it, its parents
+// and their listings, as name was changed.
+func (c *cacheLookups) forget(name string) {
+	if c.missingTime <= 0 && c.dirTime <= 0 {
+		return
+	}
+	name = filepath.Clean(name)
+	related := func(p string) bool {
+		return p == name ||
+			strings.HasPrefix(p, strings.TrimSuffix(name, FilePathSeparator)+FilePathSeparator) ||
+			strings.HasPrefix(name, strings.TrimSuffix(p, FilePathSeparator)+FilePathSeparator)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for p := range c.missing {
+		if related(p) {
+			delete(c.missing, p)
+		}
+	}
+	for p := range c.dirs {
+		if related(p) {
+			delete(c.dirs, p)
+		}
+	}
+}
+
+// cacheDirFile is a directory served from a cached listing.
+type cacheDirFile struct {
+	name    string
+	fi      os.FileInfo
+	entries []os.FileInfo
+	off     int
+	closed  bool
+}
+
+func (f *cacheDirFile) Close() error {
+	if f.closed {
+		return ErrFileClosed
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *cacheDirFile) Read(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+}
+
+func (f *cacheDirFile) ReadAt(p []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+}
+
+func (f *cacheDirFile) Seek(offset int64, whence int) (int64, error) {
+	if offset == 0 && whence == io.SeekStart {
+		f.off = 0
+		return 0, nil
+	}
+	return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+}
+
+func (f *cacheDirFile) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EISDIR}
+}
+
+func (f *cacheDirFile) WriteAt(p []byte, off int64) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: syscall.EISDIR}
+}
+
+func (f *cacheDirFile) Name() string { return f.name }
+
+func (f *cacheDirFile) Readdir(count int) ([]os.FileInfo, error) {
+	if f.closed {
+		return nil, ErrFileClosed
+	}
+	rest := f.entries[f.off:]
+	if count <= 0 {
+		f.off = len(f.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(rest) {
+		count = len(rest)
+	}
+	f.off += count
+	return rest[:count], nil
+}
+
+func (f *cacheDirFile) Readdirnames(count int) ([]string, error) {
+	fis, err := f.Readdir(count)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(fis))
+	for i, fi := range fis {
+		names[i] = fi.Name()
+	}
+	return names, nil
+}
+
+func (f *cacheDirFile) Stat() (os.FileInfo, error) { return f.fi, nil }
+
+func (f *cacheDirFile) Sync() error { return nil }
+
+func (f *cacheDirFile) Truncate(size int64) error {
+	return &os.PathError{Op: "truncate", Path: f.name, Err: syscall.EISDIR}
+}
+
+func (f *cacheDirFile) WriteString(s string) (int, error) { return f.Write([]byte(s)) }
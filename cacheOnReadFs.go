@@ -2,6 +2,8 @@ package afero
 
 import (
 	"os"
+	"path/filepath"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -19,16 +21,80 @@ import (
 // system first. To prevent writing to the base Fs, wrap it in a read-only
 // filter - Note: this will also make the overlay read-only, for writing files
 // in the overlay, use the overlay Fs directly, not via the union Fs.
+//
+// The layer grows without limit, unless a maximum size or number of files is
+// set with NewCacheOnReadFsWithOptions. The files evicted to stay within the
+// limits are removed from the layer. The bookkeeping of the eviction is kept
+// in a file of the layer, so a persistent layer, e.g. a BasePathFs on an OsFs
+// directory, can be reused after a restart. That file is not seen through
+// the CacheOnReadFs. Reading a cached file updates it with the next change
+// of the layer only, or by Close.
+//
+// Stats returns how effective the cache is, a hook set in the CacheOptions
+// is told about every lookup, copy and eviction.
+//
+// Goroutines missing the same file at the same time share a single copy of
+// it to the layer.
+//
+// Only the content of files is cached by default. The names missing in the
+// base and the directory listings can be cached in memory as well, for the
+// durations set in the CacheOptions.
+//
+// In write-back mode, see CacheOptions.WriteBack, written files go to the
+// layer only and are written to the base later, by Flush. Changes of
+// metadata, directories and removals still go to the base at once.
 type CacheOnReadFs struct {
 	base      Fs
 	layer     Fs
 	cacheTime time.Duration
+
+	// index is nil without limits
+	index   *cacheIndex
+	stats   cacheStats
+	lookups cacheLookups
+	wb      writeBack
+	merger  DirsMerger
+
+	fillMu sync.Mutex
+	fills  map[string]*cacheFill
+}
+
+// cacheFill is a copy of a file to the layer in progress, waited for by the
+// goroutines needing the same file.
+type cacheFill struct {
+	done chan struct{}
+	err  error
 }
 
 func NewCacheOnReadFs(base Fs, layer Fs, cacheTime time.Duration) Fs {
 	return &CacheOnReadFs{base: base, layer: layer, cacheTime: cacheTime}
 }
 
+// SetMerger sets how the directories present in both the base and the layer
+// are merged, see DirsMerger. A nil merger restores the default, which keeps
+// the entry of the layer for a name present in both.
+func (u *CacheOnReadFs) SetMerger(merge DirsMerger) {
+	u.merger = merge
+}
+
+// NewCacheOnReadFsWithOptions returns a CacheOnReadFs configured by opts.
+func NewCacheOnReadFsWithOptions(base Fs, layer Fs, opts CacheOptions) Fs {
+	u := &CacheOnReadFs{base: base, layer: layer, cacheTime: opts.CacheTime}
+	u.stats.hook = opts.Hook
+	u.lookups.missingTime = opts.NotExistTime
+	u.lookups.dirTime = opts.DirTime
+	u.wb.enabled = opts.WriteBack
+	u.wb.maxDirty = opts.MaxDirtyBytes
+	u.wb.interval = opts.FlushInterval
+	if opts.MaxBytes > 0 || opts.MaxEntries > 0 {
+		u.index = newCacheIndex(layer, opts)
+		if u.wb.enabled {
+			u.index.pinned = u.wb.isDirty
+		}
+	}
+	return u
+}
+
 type cacheState int
 
 const (
@@ -45,11 +111,44 @@ const (
 	cacheLocal
 )
 
+// Stats returns the counters of u since its creation.
+func (u *CacheOnReadFs) Stats() CacheStats {
+	return u.stats.snapshot()
+}
+
+// Close flushes u, see Flush, before exiting. u can still be used
+// afterwards.
+func (u *CacheOnReadFs) Close() error {
+	return u.Flush()
+}
+
+// hidden reports whether name is a file of the bookkeeping of the eviction,
+// which is not part of the tree seen through u.
+func (u *CacheOnReadFs) hidden(name string) bool {
+	return u.index != nil && u.index.isIndex(filepath.Clean(name))
+}
+
+// dirMerger returns the DirsMerger for the directory name, which hides the
+// files of the bookkeeping in the directory holding them.
+func (u *CacheOnReadFs) dirMerger(name string) DirsMerger {
+	if u.index == nil || filepath.Clean(name) != filepath.Dir(u.index.name) {
+		return u.merger
+	}
+	return u.index.hideIndex(u.merger)
+}
+
 func (u *CacheOnReadFs) cacheStatus(name string) (state cacheState, fi os.FileInfo, err error) {
+	start := time.Now()
+	state, fi, err = u.lookup(name)
+	u.stats.lookup(name, state, time.Since(start), err)
+	return state, fi, err
+}
+
+func (u *CacheOnReadFs) lookup(name string) (state cacheState, fi os.FileInfo, err error) {
 	var lfi, bfi os.FileInfo
 	lfi, err = u.layer.Stat(name)
 	if err == nil {
-		if u.cacheTime == 0 {
+		if u.cacheTime == 0 || u.wb.isDirty(name) {
 			return cacheHit, lfi, nil
 		}
 		if lfi.ModTime().Add(u.cacheTime).Before(time.Now()) {
@@ -72,10 +171,152 @@ func (u *CacheOnReadFs) cacheStatus(name string) (state cacheState, fi os.FileIn
 }
 
 func (u *CacheOnReadFs) copyToLayer(name string) error {
-	return copyToLayer(u.base, u.layer, name)
+	name = filepath.Clean(name)
+	u.fillMu.Lock()
+	if f, ok := u.fills[name]; ok {
+		u.fillMu.Unlock()
+		<-f.done
+		return f.err
+	}
+	if u.fills == nil {
+		u.fills = make(map[string]*cacheFill)
+	}
+	f := &cacheFill{done: make(chan struct{})}
+	u.fills[name] = f
+	u.fillMu.Unlock()
+
+	f.err = u.fill(name)
+
+	u.fillMu.Lock()
+	delete(u.fills, name)
+	u.fillMu.Unlock()
+	close(f.done)
+	return f.err
+}
+
+func (u *CacheOnReadFs) fill(name string) error {
+	start := time.Now()
+	n, err := copyFileToLayer(u.base, u.layer, name)
+	if err != nil && os.IsNotExist(err) {
+		// nothing to copy, e.g. a file about to be created
+		return err
+	}
+	u.stats.copied(name, n, time.Since(start), err)
+	if err != nil {
+		return err
+	}
+	u.added(name)
+	return nil
+}
+
+// added records name in the index, if any, and reports the files evicted.
+func (u *CacheOnReadFs) added(name string) {
+	if u.index == nil {
+		return
+	}
+	for _, e := range u.index.added(name) {
+		u.stats.evicted(e.name, e.size)
+	}
+}
+
+// cacheWriteFile updates the bookkeeping of the cache when a file written
+// through the CacheOnReadFs is changed and closed.
+type cacheWriteFile struct {
+	File
+	fs   *CacheOnReadFs
+	name string
+
+	// whether the file was changed through this handle
+	dirty bool
+}
+
+func (f *cacheWriteFile) Write(b []byte) (int, error) {
+	n, err := f.File.Write(b)
+	return n, f.wrote(n, err)
+}
+
+func (f *cacheWriteFile) WriteAt(b []byte, off int64) (int, error) {
+	n, err := f.File.WriteAt(b, off)
+	return n, f.wrote(n, err)
+}
+
+func (f *cacheWriteFile) WriteString(s string) (int, error) {
+	n, err := f.File.WriteString(s)
+	return n, f.wrote(n, err)
+}
+
+func (f *cacheWriteFile) Truncate(size int64) error {
+	if err := f.File.Truncate(size); err != nil {
+		return err
+	}
+	return f.changed()
+}
+
+// wrote records a write of n bytes which failed with err, if any.
+func (f *cacheWriteFile) wrote(n int, err error) error {
+	if n == 0 {
+		return err
+	}
+	if cerr := f.changed(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// changed marks the file dirty. In write-back mode, its size is counted in
+// the dirty bytes at once, see written.
+func (f *cacheWriteFile) changed() error {
+	f.dirty = true
+	if !f.fs.wb.enabled {
+		return nil
+	}
+	fi, err := f.File.Stat()
+	if err != nil {
+		return err
+	}
+	return f.fs.written(f.name, fi.Size())
+}
+
+func (f *cacheWriteFile) Close() error {
+	err := f.File.Close()
+	if f.dirty {
+		f.fs.added(f.name)
+	}
+	return err
+}
+
+// writeFile wraps f, opened for writing, if the cache needs to know about
+// its changes. changed tells whether opening it changed it already, by
+// creating or truncating it.
+func (u *CacheOnReadFs) writeFile(name string, f File, changed bool) (File, error) {
+	if u.index == nil && !u.wb.enabled {
+		return f, nil
+	}
+	wf := &cacheWriteFile{File: f, fs: u, name: name}
+	if changed {
+		if err := wf.changed(); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return wf, nil
+}
+
+// mkdirLayerParent makes sure the parent of name, which must exist in the
+// base or the layer, exists in the layer.
+func (u *CacheOnReadFs) mkdirLayerParent(name string) error {
+	dir := filepath.Dir(name)
+	if _, err := u.Stat(dir); err != nil {
+		return err
+	}
+	return mkdirAllFromBase(u.base, u.layer, dir, 0777)
 }
 
 func (u *CacheOnReadFs) Chtimes(name string, atime, mtime time.Time) error {
+	defer u.lookups.forget(name)
+	if err := u.flushDirty(name); err != nil {
+		return err
+	}
 	st, _, err := u.cacheStatus(name)
 	if err != nil {
 		return err
@@ -97,6 +338,10 @@ func (u *CacheOnReadFs) Chtimes(name string, atime, mtime time.Time) error {
 }
 
 func (u *CacheOnReadFs) Chmod(name string, mode os.FileMode) error {
+	defer u.lookups.forget(name)
+	if err := u.flushDirty(name); err != nil {
+		return err
+	}
 	st, _, err := u.cacheStatus(name)
 	if err != nil {
 		return err
@@ -118,19 +363,27 @@ func (u *CacheOnReadFs) Chmod(name string, mode os.FileMode) error {
 }
 
 func (u *CacheOnReadFs) Stat(name string) (os.FileInfo, error) {
+	if u.hidden(name) {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
 	st, fi, err := u.cacheStatus(name)
 	if err != nil {
 		return nil, err
 	}
 	switch st {
 	case cacheMiss:
-		return u.base.Stat(name)
+		return u.baseStat(name)
 	default: // cacheStale has base, cacheHit and cacheLocal the layer os.FileInfo
 		return fi, nil
 	}
 }
 
 func (u *CacheOnReadFs) Rename(oldname, newname string) error {
+	defer u.lookups.forget(oldname)
+	defer u.lookups.forget(newname)
+	if err := u.flushDirty(oldname); err != nil {
+		return err
+	}
 	st, _, err := u.cacheStatus(oldname)
 	if err != nil {
 		return err
@@ -148,26 +401,40 @@ func (u *CacheOnReadFs) Rename(oldname, newname string) error {
 	if err != nil {
 		return err
 	}
+	if u.index != nil {
+		defer u.index.renamed(oldname, newname)
+	}
 	return u.layer.Rename(oldname, newname)
 }
 
 func (u *CacheOnReadFs) Remove(name string) error {
+	defer u.lookups.forget(name)
 	st, _, err := u.cacheStatus(name)
 	if err != nil {
 		return err
 	}
+	dirty := u.removedDirty(name)
 	switch st {
 	case cacheLocal:
 	case cacheHit, cacheStale, cacheMiss:
 		err = u.base.Remove(name)
+		if dirty && os.IsNotExist(err) {
+			// never flushed
+			err = nil
+		}
 	}
 	if err != nil {
 		return err
 	}
+	if u.index != nil {
+		defer u.index.removed(name)
+	}
 	return u.layer.Remove(name)
 }
 
 func (u *CacheOnReadFs) RemoveAll(name string) error {
+	defer u.lookups.forget(name)
+	u.removedDirty(name)
 	st, _, err := u.cacheStatus(name)
 	if err != nil {
 		return err
@@ -180,22 +447,48 @@ func (u *CacheOnReadFs) RemoveAll(name string) error {
 	if err != nil {
 		return err
 	}
+	if u.index != nil {
+		defer u.index.removed(name)
+	}
 	return u.layer.RemoveAll(name)
 }
 
 func (u *CacheOnReadFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if u.hidden(name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
 	st, _, err := u.cacheStatus(name)
 	if err != nil {
 		return nil, err
 	}
-	switch st {
-	case cacheLocal, cacheHit:
+	truncated := flag&(os.O_CREATE|os.O_TRUNC) == os.O_CREATE|os.O_TRUNC
+	created := false
+	switch {
+	case st == cacheLocal, st == cacheHit:
+	case u.wb.enabled && truncated:
+		// replaced in the layer only, nothing to copy
 	default:
 		if err := u.copyToLayer(name); err != nil {
-			return nil, err
+			// a file which is about to be created has nothing to cache
+			if flag&os.O_CREATE == 0 || !os.IsNotExist(err) {
+				return nil, err
+			}
+			created = true
 		}
 	}
+	changed := created || flag&os.O_TRUNC != 0
 	if flag&(os.O_WRONLY|syscall.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		defer u.lookups.forget(name)
+		if u.wb.enabled {
+			if err := u.mkdirLayerParent(name); err != nil {
+				return nil, err
+			}
+			lfi, err := u.layer.OpenFile(name, flag, perm)
+			if err != nil {
+				return nil, err
+			}
+			return u.writeFile(name, lfi, changed)
+		}
 		bfi, err := u.base.OpenFile(name, flag, perm)
 		if err != nil {
 			return nil, err
@@ -205,12 +498,15 @@ func (u *CacheOnReadFs) OpenFile(name string, flag int, perm os.FileMode) (File,
 			bfi.Close() // oops, what if O_TRUNC was set and file opening in the layer failed...?
 			return nil, err
 		}
-		return &UnionFile{Base: bfi, Layer: lfi}, nil
+		return u.writeFile(name, &UnionFile{Base: bfi, Layer: lfi}, changed)
 	}
 	return u.layer.OpenFile(name, flag, perm)
 }
 
 func (u *CacheOnReadFs) Open(name string) (File, error) {
+	if u.hidden(name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
 	st, fi, err := u.cacheStatus(name)
 	if err != nil {
 		return nil, err
@@ -218,15 +514,19 @@ func (u *CacheOnReadFs) Open(name string) (File, error) {
 
 	switch st {
 	case cacheLocal:
-		return u.layer.Open(name)
+		if !fi.IsDir() {
+			return u.layer.Open(name)
+		}
 
 	case cacheMiss:
-		bfi, err := u.base.Stat(name)
+		bfi, err := u.baseStat(name)
 		if err != nil {
 			return nil, err
 		}
 		if bfi.IsDir() {
-			return u.base.Open(name)
+			return u.lookups.openDir(name, func() (File, error) {
+				return u.base.Open(name)
+			})
 		}
 		if err := u.copyToLayer(name); err != nil {
 			return nil, err
@@ -242,19 +542,38 @@ func (u *CacheOnReadFs) Open(name string) (File, error) {
 		}
 	case cacheHit:
 		if !fi.IsDir() {
+			if u.index != nil {
+				u.index.used(name)
+			}
 			return u.layer.Open(name)
 		}
 	}
-	// the dirs from cacheHit, cacheStale fall down here:
-	bfile, _ := u.base.Open(name)
-	lfile, err := u.layer.Open(name)
-	if err != nil && bfile == nil {
-		return nil, err
+	// the dirs from cacheLocal, cacheHit, cacheStale fall down here:
+	return u.lookups.openDir(name, func() (File, error) {
+		bfile, _ := u.base.Open(name)
+		lfile, err := u.layer.Open(name)
+		if err != nil && bfile == nil {
+			return nil, err
+		}
+		return &UnionFile{Base: bfile, Layer: lfile, Merger: u.dirMerger(name)}, nil
+	})
+}
+
+// baseStat is Stat on the base, answered from the cached lookups if
+// possible.
+func (u *CacheOnReadFs) baseStat(name string) (os.FileInfo, error) {
+	if fi, err, ok := u.lookups.stat(name); ok {
+		return fi, err
+	}
+	fi, err := u.base.Stat(name)
+	if err != nil && os.IsNotExist(err) {
+		u.lookups.notExist(name)
 	}
-	return &UnionFile{Base: bfile, Layer: lfile}, nil
+	return fi, err
 }
 
 func (u *CacheOnReadFs) Mkdir(name string, perm os.FileMode) error {
+	defer u.lookups.forget(name)
 	err := u.base.Mkdir(name, perm)
 	if err != nil {
 		return err
@@ -267,6 +586,7 @@ func (u *CacheOnReadFs) Name() string {
 }
 
 func (u *CacheOnReadFs) MkdirAll(name string, perm os.FileMode) error {
+	defer u.lookups.forget(name)
 	err := u.base.MkdirAll(name, perm)
 	if err != nil {
 		return err
@@ -275,6 +595,10 @@ func (u *CacheOnReadFs) MkdirAll(name string, perm os.FileMode) error {
 }
 
 func (u *CacheOnReadFs) Create(name string) (File, error) {
+	defer u.lookups.forget(name)
+	if u.wb.enabled {
+		return u.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	}
 	bfh, err := u.base.Create(name)
 	if err != nil {
 		return nil, err
@@ -286,5 +610,5 @@ func (u *CacheOnReadFs) Create(name string) (File, error) {
 		bfh.Close()
 		return nil, err
 	}
-	return &UnionFile{Base: bfh, Layer: lfh}, nil
+	return u.writeFile(name, &UnionFile{Base: bfh, Layer: lfh}, true)
 }
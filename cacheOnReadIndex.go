@@ -0,0 +1,343 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheEviction selects the files a size bounded CacheOnReadFs removes from
+// its layer first.
+type CacheEviction int
+
+const (
+	// EvictLRU removes the least recently used files first.
+	EvictLRU CacheEviction = iota
+	// EvictLFU removes the least frequently used files first, the least
+	// recently used of them on a tie.
+	EvictLFU
+)
+
+// defaultCacheIndexFile is where the bookkeeping of the eviction is kept in
+// the layer by default.
+const defaultCacheIndexFile = "/.afero-cache-index"
+
+// CacheOptions configure a CacheOnReadFs created with
+// NewCacheOnReadFsWithOptions.
+type CacheOptions struct {
+	// CacheTime is the cache duration, see CacheOnReadFs.
+	CacheTime time.Duration
+
+	// MaxBytes limits the total size of the files in the layer, 0 means no
+	// limit.
+	MaxBytes int64
+
+	// MaxEntries limits the number of files in the layer, 0 means no
+	// limit.
+	MaxEntries int
+
+	// Eviction selects the files removed from the layer when a limit is
+	// exceeded.
+	Eviction CacheEviction
+
+	// IndexFile is the file of the layer the bookkeeping of the eviction is
+	// kept in, so it survives restarts with a persistent layer. It is saved
+	// by CacheOnReadFs.Flush and Close. It defaults to "/.afero-cache-index".
+	IndexFile string
+
+	// NotExistTime is how long a name missing in the base is remembered,
+	// 0 means it is not.
+	NotExistTime time.Duration
+
+	// DirTime is how long the listing of a directory is cached in memory,
+	// 0 means it is not. The entries of a cached listing are not looked up
+	// in the base either.
+	DirTime time.Duration
+
+	// WriteBack makes the files written through the CacheOnReadFs go to
+	// the layer only. They are written to the base later by Flush.
+	WriteBack bool
+
+	// MaxDirtyBytes limits the size of the files written in write-back
+	// mode, but not to the base yet. A write or truncation going over it
+	// flushes at once, and returns the error of the flush. 0 means no limit.
+	MaxDirtyBytes int64
+
+	// FlushInterval is the time after which files written in write-back
+	// mode are flushed in the background. 0 means they are flushed
+	// explicitly or when MaxDirtyBytes is exceeded only.
+	FlushInterval time.Duration
+
+	// Hook, if set, is called with every CacheEvent, synchronously from the
+	// goroutine causing it. See also CacheOnReadFs.Stats.
+	Hook func(CacheEvent)
+}
+
+// cacheIndex keeps track of the files in the layer of a CacheOnReadFs and
+// removes the ones to evict when the limits are exceeded.
+type cacheIndex struct {
+	layer      Fs
+	name       string
+	maxBytes   int64
+	maxEntries int
+	eviction   CacheEviction
+	// pinned reports the files which must not be evicted, if set
+	pinned func(name string) bool
+
+	once    sync.Once
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+	size    int64
+	// dirty is set when the changes recorded are not persisted yet
+	dirty bool
+}
+
+// cacheEntry is the bookkeeping of a file of the layer, as it is persisted.
+type cacheEntry struct {
+	Size    int64 `json:"size"`
+	LastUse int64 `json:"lastUse"` // in nanoseconds since the epoch
+	Uses    int64 `json:"uses"`
+}
+
+func newCacheIndex(layer Fs, opts CacheOptions) *cacheIndex {
+	name := opts.IndexFile
+	if name == "" {
+		name = defaultCacheIndexFile
+	}
+	return &cacheIndex{
+		layer:      layer,
+		name:       filepath.Clean(name),
+		maxBytes:   opts.MaxBytes,
+		maxEntries: opts.MaxEntries,
+		eviction:   opts.Eviction,
+	}
+}
+
+// load reads the persisted bookkeeping once, checking it against the layer.
+// Files of the layer missing from it, e.g. after a crash, are adopted. The
+// caller must hold c.mu.
+func (c *cacheIndex) load() {
+	c.once.Do(func() {
+		c.entries = make(map[string]*cacheEntry)
+		if data, err := ReadFile(c.layer, c.name); err == nil {
+			json.Unmarshal(data, &c.entries)
+		}
+		for name, e := range c.entries {
+			fi, err := c.layer.Stat(name)
+			if err != nil || fi.IsDir() {
+				delete(c.entries, name)
+				continue
+			}
+			e.Size = fi.Size()
+		}
+
+		// a bare OsFs is the whole disk, it is not walked
+		switch c.layer.(type) {
+		case OsFs, *OsFs:
+		default:
+			Walk(c.layer, FilePathSeparator, func(path string, info os.FileInfo, err error) error {
+				if err != nil || !info.Mode().IsRegular() || c.isIndex(path) {
+					return nil
+				}
+				if _, ok := c.entries[path]; !ok {
+					c.entries[path] = &cacheEntry{Size: info.Size(), LastUse: info.ModTime().UnixNano()}
+				}
+				return nil
+			})
+		}
+
+		c.size = 0
+		for _, e := range c.entries {
+			c.size += e.Size
+		}
+	})
+}
+
+// isIndex reports whether the cleaned name is the file the bookkeeping is
+// kept in, or the one it is written to first.
+func (c *cacheIndex) isIndex(name string) bool {
+	return name == c.name || name == c.name+".tmp"
+}
+
+// hideIndex returns merge dropping the index files from the entries of the
+// layer, for the directory holding them.
+func (c *cacheIndex) hideIndex(merge DirsMerger) DirsMerger {
+	if merge == nil {
+		merge = defaultUnionMergeDirsFn
+	}
+	dir := filepath.Dir(c.name)
+	return func(lofi, bofi []os.FileInfo) ([]os.FileInfo, error) {
+		var visible []os.FileInfo
+		for _, fi := range lofi {
+			if !c.isIndex(filepath.Join(dir, fi.Name())) {
+				visible = append(visible, fi)
+			}
+		}
+		return merge(visible, bofi)
+	}
+}
+
+// added records that name was written to the layer and evicts other files
+// if needed. It returns the evicted files.
+func (c *cacheIndex) added(name string) (evicted []evictedFile) {
+	name = filepath.Clean(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	fi, err := c.layer.Stat(name)
+	if err != nil || fi.IsDir() {
+		return nil
+	}
+	e, ok := c.entries[name]
+	if !ok {
+		e = &cacheEntry{}
+		c.entries[name] = e
+	}
+	c.size += fi.Size() - e.Size
+	e.Size = fi.Size()
+	e.LastUse = time.Now().UnixNano()
+	e.Uses++
+	c.dirty = true
+	return c.evict(name)
+}
+
+// used records that name was read from the layer.
+func (c *cacheIndex) used(name string) {
+	name = filepath.Clean(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	if e, ok := c.entries[name]; ok {
+		e.LastUse = time.Now().UnixNano()
+		e.Uses++
+		c.dirty = true
+	}
+}
+
+// sync persists the changes recorded since the last save, if any. They
+// are not saved as they happen, which would rewrite the whole index with
+// every file. Files of the layer missing from a stale index are adopted
+// by load, and the ones gone from it are dropped.
+func (c *cacheIndex) sync() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.dirty {
+		return nil
+	}
+	return c.save()
+}
+
+// removed forgets name and, for a directory, the files below it.
+func (c *cacheIndex) removed(name string) {
+	name = filepath.Clean(name)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	for p, e := range c.entries {
+		if p == name || strings.HasPrefix(p, name+FilePathSeparator) {
+			c.size -= e.Size
+			delete(c.entries, p)
+			c.dirty = true
+		}
+	}
+}
+
+// renamed moves the bookkeeping of oldname, and of the files below it for a
+// directory, to newname.
+func (c *cacheIndex) renamed(oldname, newname string) {
+	oldname, newname = filepath.Clean(oldname), filepath.Clean(newname)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+	moved := make(map[string]*cacheEntry)
+	for p, e := range c.entries {
+		if p == oldname || strings.HasPrefix(p, oldname+FilePathSeparator) {
+			moved[newname+strings.TrimPrefix(p, oldname)] = e
+			delete(c.entries, p)
+		}
+	}
+	for p, e := range moved {
+		if prev, ok := c.entries[p]; ok {
+			c.size -= prev.Size
+		}
+		c.entries[p] = e
+		c.dirty = true
+	}
+}
+
+// evictedFile is a file removed from the layer by the eviction.
+type evictedFile struct {
+	name string
+	size int64
+}
+
+// evict removes files from the layer until the limits are met again, never
+// keep, which was just added. The caller must hold c.mu.
+func (c *cacheIndex) evict(keep string) (evicted []evictedFile) {
+	for (c.maxBytes > 0 && c.size > c.maxBytes) ||
+		(c.maxEntries > 0 && len(c.entries) > c.maxEntries) {
+		victim := ""
+		for p, e := range c.entries {
+			if p == keep || (c.pinned != nil && c.pinned(p)) {
+				continue
+			}
+			if victim == "" || c.before(e, c.entries[victim]) {
+				victim = p
+			}
+		}
+		if victim == "" {
+			return evicted
+		}
+		if err := c.layer.Remove(victim); err != nil && !os.IsNotExist(err) {
+			return evicted
+		}
+		size := c.entries[victim].Size
+		c.size -= size
+		delete(c.entries, victim)
+		evicted = append(evicted, evictedFile{name: victim, size: size})
+	}
+	return evicted
+}
+
+// before reports whether a is evicted before b.
+func (c *cacheIndex) before(a, b *cacheEntry) bool {
+	if c.eviction == EvictLFU && a.Uses != b.Uses {
+		return a.Uses < b.Uses
+	}
+	return a.LastUse < b.LastUse
+}
+
+// save persists the bookkeeping, replacing the previous one at once. The
+// caller must hold c.mu.
+func (c *cacheIndex) save() error {
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	tmp := c.name + ".tmp"
+	c.layer.MkdirAll(filepath.Dir(tmp), 0777)
+	if err := WriteFile(c.layer, tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := c.layer.Rename(tmp, c.name); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
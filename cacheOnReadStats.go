@@ -0,0 +1,183 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"sync"
+	"time"
+)
+
+// CacheStats are the counters of a CacheOnReadFs, as returned by its Stats
+// method.
+type CacheStats struct {
+	// Hits, Misses, Stale and Local count the lookups of a name in the
+	// layer by their outcome: found in the layer, not found, found but
+	// older than the base and found in the layer only.
+	Hits   uint64
+	Misses uint64
+	Stale  uint64
+	Local  uint64
+
+	// Copies counts the files copied from the base to the layer,
+	// CopyErrors the copies which failed.
+	Copies      uint64
+	CopyErrors  uint64
+	BytesCopied int64
+	// CopyTime is the total time spent copying files to the layer.
+	CopyTime time.Duration
+
+	// Evictions counts the files removed from the layer to stay within the
+	// limits, see CacheOptions.
+	Evictions    uint64
+	EvictedBytes int64
+
+	// Flushes counts the dirty files written to the base in write-back
+	// mode, FlushErrors the writes which failed.
+	Flushes      uint64
+	FlushErrors  uint64
+	BytesFlushed int64
+	// FlushTime is the total time spent writing dirty files to the base.
+	FlushTime time.Duration
+}
+
+// CacheEventKind is the kind of a CacheEvent.
+type CacheEventKind int
+
+const (
+	// CacheEventHit is a lookup finding a valid file in the layer.
+	CacheEventHit CacheEventKind = iota + 1
+	// CacheEventMiss is a lookup not finding the file in the layer.
+	CacheEventMiss
+	// CacheEventStale is a lookup finding a file in the layer older than
+	// in the base.
+	CacheEventStale
+	// CacheEventLocal is a lookup finding a file in the layer only.
+	CacheEventLocal
+	// CacheEventCopy is a file copied from the base to the layer.
+	CacheEventCopy
+	// CacheEventEvict is a file removed from the layer to stay within the
+	// limits.
+	CacheEventEvict
+	// CacheEventFlush is a dirty file written to the base in write-back
+	// mode.
+	CacheEventFlush
+)
+
+func (k CacheEventKind) String() string {
+	switch k {
+	case CacheEventHit:
+		return "hit"
+	case CacheEventMiss:
+		return "miss"
+	case CacheEventStale:
+		return "stale"
+	case CacheEventLocal:
+		return "local"
+	case CacheEventCopy:
+		return "copy"
+	case CacheEventEvict:
+		return "evict"
+	case CacheEventFlush:
+		return "flush"
+	}
+	return "unknown"
+}
+
+// CacheEvent is passed to the hook of a CacheOnReadFs, see CacheOptions.
+type CacheEvent struct {
+	Kind CacheEventKind
+	Name string
+	// Bytes is the size of the file copied, evicted or flushed.
+	Bytes int64
+	// Duration is the time taken by the lookup, the copy or the flush.
+	Duration time.Duration
+	// Err is the error of a failed lookup, copy or flush.
+	Err error
+}
+
+// cacheStats collects the CacheStats of a CacheOnReadFs and passes the
+// events to the hook.
+type cacheStats struct {
+	hook func(CacheEvent)
+
+	mu    sync.Mutex
+	stats CacheStats
+}
+
+func (s *cacheStats) lookup(name string, st cacheState, d time.Duration, err error) {
+	var kind CacheEventKind
+	s.mu.Lock()
+	switch st {
+	case cacheHit:
+		s.stats.Hits++
+		kind = CacheEventHit
+	case cacheMiss:
+		s.stats.Misses++
+		kind = CacheEventMiss
+	case cacheStale:
+		s.stats.Stale++
+		kind = CacheEventStale
+	case cacheLocal:
+		s.stats.Local++
+		kind = CacheEventLocal
+	}
+	s.mu.Unlock()
+	s.emit(CacheEvent{Kind: kind, Name: name, Duration: d, Err: err})
+}
+
+func (s *cacheStats) copied(name string, n int64, d time.Duration, err error) {
+	s.mu.Lock()
+	if err != nil {
+		s.stats.CopyErrors++
+	} else {
+		s.stats.Copies++
+	}
+	s.stats.BytesCopied += n
+	s.stats.CopyTime += d
+	s.mu.Unlock()
+	s.emit(CacheEvent{Kind: CacheEventCopy, Name: name, Bytes: n, Duration: d, Err: err})
+}
+
+func (s *cacheStats) flushed(name string, n int64, d time.Duration, err error) {
+	s.mu.Lock()
+	if err != nil {
+		s.stats.FlushErrors++
+	} else {
+		s.stats.Flushes++
+	}
+	s.stats.BytesFlushed += n
+	s.stats.FlushTime += d
+	s.mu.Unlock()
+	s.emit(CacheEvent{Kind: CacheEventFlush, Name: name, Bytes: n, Duration: d, Err: err})
+}
+
+func (s *cacheStats) evicted(name string, n int64) {
+	s.mu.Lock()
+	s.stats.Evictions++
+	s.stats.EvictedBytes += n
+	s.mu.Unlock()
+	s.emit(CacheEvent{Kind: CacheEventEvict, Name: name, Bytes: n})
+}
+
+func (s *cacheStats) emit(e CacheEvent) {
+	if s.hook != nil {
+		s.hook(e)
+	}
+}
+
+func (s *cacheStats) snapshot() CacheStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stats
+}
@@ -0,0 +1,237 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FlushError is returned by CacheOnReadFs.Flush when files could not be
+// written to the base. They stay dirty and are retried by the next flush.
+type FlushError struct {
+	// Errs are the errors by path.
+	Errs map[string]error
+}
+
+func (e *FlushError) Error() string {
+	paths := make([]string, 0, len(e.Errs))
+	for p := range e.Errs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	msg := "flush failed:"
+	for _, p := range paths {
+		msg += " " + p + ": " + e.Errs[p].Error() + ";"
+	}
+	return strings.TrimSuffix(msg, ";")
+}
+
+// writeBack keeps the files of a CacheOnReadFs in write-back mode written to
+// the layer only.
+type writeBack struct {
+	enabled  bool
+	maxDirty int64
+	interval time.Duration
+
+	mu    sync.Mutex
+	dirty map[string]*dirtyFile
+	bytes int64
+	gen   uint64
+	timer *time.Timer
+
+	// flushMu serializes the flushes
+	flushMu sync.Mutex
+}
+
+// dirtyFile is a file of the layer not written to the base yet.
+type dirtyFile struct {
+	size int64
+	gen  uint64 // changes with every write
+}
+
+func (w *writeBack) isDirty(name string) bool {
+	if !w.enabled {
+		return false
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, ok := w.dirty[filepath.Clean(name)]
+	return ok
+}
+
+// Flush writes the dirty files of a CacheOnReadFs in write-back mode to the
+// base, and persists the bookkeeping of the eviction, if any. Every file is
+// written next to its destination and renamed into place. If some files
+// fail, the others are written anyway and a *FlushError is returned.
+func (u *CacheOnReadFs) Flush() error {
+	err := u.flushFiles()
+	if u.index != nil {
+		if ierr := u.index.sync(); err == nil {
+			err = ierr
+		}
+	}
+	return err
+}
+
+// flushFiles writes the dirty files to the base, see Flush.
+func (u *CacheOnReadFs) flushFiles() error {
+	if !u.wb.enabled {
+		return nil
+	}
+	u.wb.mu.Lock()
+	names := make([]string, 0, len(u.wb.dirty))
+	for name := range u.wb.dirty {
+		names = append(names, name)
+	}
+	u.wb.mu.Unlock()
+	sort.Strings(names)
+
+	errs := make(map[string]error)
+	for _, name := range names {
+		if err := u.flushFile(name); err != nil {
+			errs[name] = err
+		}
+	}
+	if len(errs) > 0 {
+		return &FlushError{Errs: errs}
+	}
+	return nil
+}
+
+// flushFile writes name to the base if it is dirty.
+func (u *CacheOnReadFs) flushFile(name string) error {
+	name = filepath.Clean(name)
+	u.wb.flushMu.Lock()
+	defer u.wb.flushMu.Unlock()
+
+	u.wb.mu.Lock()
+	d, ok := u.wb.dirty[name]
+	var gen uint64
+	if ok {
+		gen = d.gen
+	}
+	u.wb.mu.Unlock()
+	if !ok {
+		return nil
+	}
+
+	start := time.Now()
+	fi, err := u.layer.Stat(name)
+	if err == nil {
+		err = commitFile(u.layer, u.base, name, fi)
+	}
+	var n int64
+	if err == nil {
+		n = fi.Size()
+		u.wb.mu.Lock()
+		// written again meanwhile, it stays dirty
+		if d, ok := u.wb.dirty[name]; ok && d.gen == gen {
+			u.wb.bytes -= d.size
+			delete(u.wb.dirty, name)
+		}
+		u.wb.mu.Unlock()
+		u.lookups.forget(name)
+	}
+	u.stats.flushed(name, n, time.Since(start), err)
+	return err
+}
+
+// written records that name, now size bytes long, was changed in the
+// layer. Going over the dirty bytes allowed flushes at once, and fails if
+// that fails.
+func (u *CacheOnReadFs) written(name string, size int64) error {
+	name = filepath.Clean(name)
+	u.wb.mu.Lock()
+	if u.wb.dirty == nil {
+		u.wb.dirty = make(map[string]*dirtyFile)
+	}
+	d, ok := u.wb.dirty[name]
+	if !ok {
+		d = &dirtyFile{}
+		u.wb.dirty[name] = d
+	}
+	u.wb.gen++
+	u.wb.bytes += size - d.size
+	d.size, d.gen = size, u.wb.gen
+	over := u.wb.maxDirty > 0 && u.wb.bytes > u.wb.maxDirty
+	if !over && u.wb.interval > 0 && u.wb.timer == nil {
+		u.wb.timer = time.AfterFunc(u.wb.interval, u.flushLater)
+	}
+	u.wb.mu.Unlock()
+
+	if over {
+		return u.Flush()
+	}
+	return nil
+}
+
+// flushLater is the asynchronous flush. The errors are reported to the
+// hook, the files failed are retried after another interval.
+func (u *CacheOnReadFs) flushLater() {
+	u.Flush()
+	u.wb.mu.Lock()
+	u.wb.timer = nil
+	if len(u.wb.dirty) > 0 {
+		u.wb.timer = time.AfterFunc(u.wb.interval, u.flushLater)
+	}
+	u.wb.mu.Unlock()
+}
+
+// removedDirty drops name and the files below it from the dirty files and
+// reports whether there were any.
+func (u *CacheOnReadFs) removedDirty(name string) bool {
+	if !u.wb.enabled {
+		return false
+	}
+	name = filepath.Clean(name)
+	u.wb.mu.Lock()
+	defer u.wb.mu.Unlock()
+	found := false
+	for p, d := range u.wb.dirty {
+		if p == name || strings.HasPrefix(p, strings.TrimSuffix(name, FilePathSeparator)+FilePathSeparator) {
+			u.wb.bytes -= d.size
+			delete(u.wb.dirty, p)
+			found = true
+		}
+	}
+	return found
+}
+
+// flushDirty flushes name and the files below it, before a change of their
+// metadata goes to the base.
+func (u *CacheOnReadFs) flushDirty(name string) error {
+	if !u.wb.enabled {
+		return nil
+	}
+	name = filepath.Clean(name)
+	u.wb.mu.Lock()
+	var names []string
+	for p := range u.wb.dirty {
+		if p == name || strings.HasPrefix(p, strings.TrimSuffix(name, FilePathSeparator)+FilePathSeparator) {
+			names = append(names, p)
+		}
+	}
+	u.wb.mu.Unlock()
+	sort.Strings(names)
+	for _, p := range names {
+		if err := u.flushFile(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
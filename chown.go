@@ -0,0 +1,33 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"errors"
+)
+
+// Chowner is an optional interface in Afero. It is only implemented by the
+// filesystems saying so.
+// It changes the numeric uid and gid of the named file, a uid or gid of -1
+// means to not change that value. Lchown changes the symbolic link itself
+// rather than the file it points to.
+type Chowner interface {
+	Chown(name string, uid, gid int) error
+	Lchown(name string, uid, gid int) error
+}
+
+// ErrNoChown is the error that will be wrapped in an os.PathError if a file
+// system does not support changing the ownership of files either directly or
+// through its delegated filesystem.
+var ErrNoChown = errors.New("chown not supported")
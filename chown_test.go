@@ -0,0 +1,157 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/afero/mem"
+)
+
+func checkOwner(t *testing.T, fi os.FileInfo, uid, gid int) {
+	t.Helper()
+	st, ok := fi.Sys().(*mem.Stat_t)
+	if !ok {
+		t.Fatalf("%s: expected *mem.Stat_t, got %T", fi.Name(), fi.Sys())
+	}
+	if int(st.Uid) != uid || int(st.Gid) != gid {
+		t.Errorf("%s: expected owner %d:%d, got %d:%d", fi.Name(), uid, gid, st.Uid, st.Gid)
+	}
+}
+
+func TestMemMapFsChown(t *testing.T) {
+	fs := &MemMapFs{}
+	WriteFile(fs, "/file.txt", []byte("content"), 0644)
+	fs.SymlinkIfPossible("/file.txt", "/link.txt")
+
+	fi, _ := fs.Stat("/file.txt")
+	checkOwner(t, fi, 0, 0)
+
+	if err := fs.Chown("/file.txt", 1000, 100); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ = fs.Stat("/file.txt")
+	checkOwner(t, fi, 1000, 100)
+
+	// -1 leaves the value alone
+	if err := fs.Chown("/file.txt", -1, 200); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ = fs.Stat("/file.txt")
+	checkOwner(t, fi, 1000, 200)
+
+	// Chown follows links, Lchown does not.
+	if err := fs.Chown("/link.txt", 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Lchown("/link.txt", 2, 2); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ = fs.Stat("/file.txt")
+	checkOwner(t, fi, 1, 1)
+	fi, _, _ = fs.LstatIfPossible("/link.txt")
+	checkOwner(t, fi, 2, 2)
+
+	err := fs.Chown("/missing.txt", 1, 1)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+	checkPathError(t, err, "Chown")
+}
+
+func TestChownWrappers(t *testing.T) {
+	base := &MemMapFs{}
+	WriteFile(base, "/base/file.txt", []byte("content"), 0644)
+
+	bp := NewBasePathFs(base, "/base")
+	if err := bp.(Chowner).Chown("/file.txt", 10, 20); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := base.Stat("/base/file.txt")
+	checkOwner(t, fi, 10, 20)
+
+	ro := NewReadOnlyFs(base)
+	if err := ro.(Chowner).Chown("/base/file.txt", 1, 1); err != syscall.EPERM {
+		t.Errorf("expected EPERM, got %v", err)
+	}
+
+	layer := &MemMapFs{}
+	cow := NewCopyOnWriteFs(base, layer)
+	if err := cow.(Chowner).Chown("/base/file.txt", 30, 40); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ = layer.Stat("/base/file.txt")
+	checkOwner(t, fi, 30, 40)
+	fi, _ = base.Stat("/base/file.txt")
+	checkOwner(t, fi, 10, 20)
+
+	notSupported := NewBasePathFs(nonLinkingFs{base}, "/base")
+	err := notSupported.(Chowner).Chown("/file.txt", 1, 1)
+	if perr, ok := err.(*os.PathError); !ok || perr.Err != ErrNoChown {
+		t.Errorf("expected ErrNoChown, got %v", err)
+	}
+}
+
+func TestCopyOnWriteLchownBaseLink(t *testing.T) {
+	base := &MemMapFs{}
+	WriteFile(base, "/dir/file.txt", []byte("content"), 0644)
+	base.SymlinkIfPossible("file.txt", "/dir/link.txt")
+
+	layer := &MemMapFs{}
+	cow := NewCopyOnWriteFs(base, layer)
+	if err := cow.(Chowner).Lchown("/dir/link.txt", 5, 6); err != nil {
+		t.Fatal(err)
+	}
+
+	target, err := layer.ReadlinkIfPossible("/dir/link.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "file.txt" {
+		t.Errorf("expected link to file.txt in the overlay, got %s", target)
+	}
+	fi, _, _ := layer.LstatIfPossible("/dir/link.txt")
+	checkOwner(t, fi, 5, 6)
+	if _, err := layer.Stat("/dir/file.txt"); !os.IsNotExist(err) {
+		t.Errorf("expected the link target to stay in the base, got %v", err)
+	}
+}
+
+func TestOsFsChown(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("chown is not supported on windows")
+	}
+	fs := &OsFs{}
+	dir, err := TempDir(fs, "", "afero-chown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fs.RemoveAll(dir)
+
+	name := filepath.Join(dir, "file.txt")
+	WriteFile(fs, name, []byte("content"), 0644)
+	if err := fs.Chown(name, os.Getuid(), os.Getgid()); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Lchown(name, -1, -1); err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.Chown(filepath.Join(dir, "missing.txt"), -1, -1); !os.IsNotExist(err) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -403,6 +406,450 @@ func TestCacheOnReadFsNotInLayer(t *testing.T) {
 	fh.Close()
 }
 
+func TestCacheOnReadFsEviction(t *testing.T) {
+	base := NewMemMapFs()
+	for _, name := range []string{"/a", "/b", "/c", "/d"} {
+		WriteFile(base, name, []byte("0123456789"), 0644)
+	}
+	exists := func(fs Fs, name string) bool {
+		ok, _ := Exists(fs, name)
+		return ok
+	}
+
+	// LRU by entries: reading /a again makes /b the least recently used
+	layer := NewMemMapFs()
+	ufs := NewCacheOnReadFsWithOptions(base, layer, CacheOptions{MaxEntries: 2})
+	for _, name := range []string{"/a", "/b", "/a", "/c"} {
+		if _, err := ReadFile(ufs, name); err != nil {
+			t.Fatal(name, err)
+		}
+	}
+	if !exists(layer, "/a") || exists(layer, "/b") || !exists(layer, "/c") {
+		t.Error("expected /b to be evicted")
+	}
+
+	// LRU by size
+	layer = NewMemMapFs()
+	ufs = NewCacheOnReadFsWithOptions(base, layer, CacheOptions{MaxBytes: 25})
+	for _, name := range []string{"/a", "/b", "/c"} {
+		ReadFile(ufs, name)
+	}
+	if exists(layer, "/a") || !exists(layer, "/b") || !exists(layer, "/c") {
+		t.Error("expected /a to be evicted")
+	}
+
+	// LFU: /a is used the most, /b is evicted before the newer /c
+	layer = NewMemMapFs()
+	ufs = NewCacheOnReadFsWithOptions(base, layer, CacheOptions{MaxEntries: 3, Eviction: EvictLFU})
+	for _, name := range []string{"/a", "/a", "/a", "/b", "/c", "/d"} {
+		ReadFile(ufs, name)
+	}
+	if !exists(layer, "/a") || exists(layer, "/b") || !exists(layer, "/c") || !exists(layer, "/d") {
+		t.Error("expected /b to be evicted")
+	}
+
+	// files written through the union count as well
+	layer = NewMemMapFs()
+	ufs = NewCacheOnReadFsWithOptions(base, layer, CacheOptions{MaxBytes: 15})
+	ReadFile(ufs, "/a")
+	if err := WriteFile(ufs, "/e", []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if exists(layer, "/a") || !exists(layer, "/e") {
+		t.Error("expected /a to be evicted")
+	}
+}
+
+func TestCacheOnReadFsEvictionPersistent(t *testing.T) {
+	base := NewMemMapFs()
+	for _, name := range []string{"/a", "/b", "/c"} {
+		WriteFile(base, name, []byte("0123456789"), 0644)
+	}
+	layer := NewTempOsBaseFs(t)
+	defer CleanupTempDirs(t)
+	opts := CacheOptions{MaxEntries: 2}
+
+	ufs := NewCacheOnReadFsWithOptions(base, layer, opts)
+	ReadFile(ufs, "/a")
+	ReadFile(ufs, "/b")
+	ReadFile(ufs, "/a")
+	if err := ufs.(*CacheOnReadFs).Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restart: /b is still the least recently used
+	ufs = NewCacheOnReadFsWithOptions(base, layer, opts)
+	ReadFile(ufs, "/c")
+	for name, want := range map[string]bool{"/a": true, "/b": false, "/c": true} {
+		if ok, _ := Exists(layer, name); ok != want {
+			t.Errorf("%s in layer: got %t, want %t", name, ok, want)
+		}
+	}
+}
+
+func TestCacheOnReadFsIndex(t *testing.T) {
+	base := NewMemMapFs()
+	WriteFile(base, "/a", []byte("0123456789"), 0644)
+	base.Mkdir("/dir", 0755)
+	layer := NewMemMapFs()
+	ufs := NewCacheOnReadFsWithOptions(base, layer, CacheOptions{MaxEntries: 2})
+	ReadFile(ufs, "/a")
+
+	// the index is saved by Flush, in the layer, but not seen through the
+	// union
+	if ok, _ := Exists(layer, defaultCacheIndexFile); ok {
+		t.Error("index saved on a copy")
+	}
+	if err := ufs.(*CacheOnReadFs).Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := Exists(layer, defaultCacheIndexFile); !ok {
+		t.Fatal("no index in the layer")
+	}
+	if got := listNames(t, ufs, "/"); got != "a dir" {
+		t.Errorf("listed %q", got)
+	}
+	for _, name := range []string{defaultCacheIndexFile, defaultCacheIndexFile + ".tmp"} {
+		if _, err := ufs.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("stat %s: got %v", name, err)
+		}
+		if _, err := ufs.Open(name); !os.IsNotExist(err) {
+			t.Errorf("open %s: got %v", name, err)
+		}
+	}
+
+	// a hit is persisted by Close only
+	saved, _ := ReadFile(layer, defaultCacheIndexFile)
+	ReadFile(ufs, "/a")
+	if data, _ := ReadFile(layer, defaultCacheIndexFile); string(data) != string(saved) {
+		t.Error("index saved on a hit")
+	}
+	if err := ufs.(*CacheOnReadFs).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ReadFile(layer, defaultCacheIndexFile); string(data) == string(saved) {
+		t.Error("index not saved by Close")
+	}
+
+	// failing to save the index is reported
+	ufs = NewCacheOnReadFsWithOptions(base, &rwFilterFs{Fs: NewMemMapFs(), readOnly: "/ro"}, CacheOptions{
+		MaxEntries: 2,
+		IndexFile:  "/ro/index",
+	})
+	ReadFile(ufs, "/a")
+	if err := ufs.(*CacheOnReadFs).Close(); !os.IsPermission(err) {
+		t.Errorf("got %v, want a permission error", err)
+	}
+}
+
+func TestCacheOnReadFsStats(t *testing.T) {
+	base := NewMemMapFs()
+	WriteFile(base, "/a", []byte("0123456789"), 0644)
+	WriteFile(base, "/b", []byte("01234"), 0644)
+
+	var events []CacheEvent
+	ufs := NewCacheOnReadFsWithOptions(base, NewMemMapFs(), CacheOptions{
+		MaxEntries: 1,
+		Hook:       func(e CacheEvent) { events = append(events, e) },
+	}).(*CacheOnReadFs)
+	for _, name := range []string{"/a", "/a", "/b"} {
+		if _, err := ReadFile(ufs, name); err != nil {
+			t.Fatal(name, err)
+		}
+	}
+
+	stats := ufs.Stats()
+	if stats.Hits != 1 || stats.Misses != 2 || stats.Stale != 0 || stats.Local != 0 {
+		t.Errorf("unexpected lookups: %+v", stats)
+	}
+	if stats.Copies != 2 || stats.CopyErrors != 0 || stats.BytesCopied != 15 {
+		t.Errorf("unexpected copies: %+v", stats)
+	}
+	if stats.Evictions != 1 || stats.EvictedBytes != 10 {
+		t.Errorf("unexpected evictions: %+v", stats)
+	}
+
+	var kinds []string
+	for _, e := range events {
+		kinds = append(kinds, e.Kind.String()+" "+e.Name)
+	}
+	want := "[miss /a copy /a hit /a miss /b copy /b evict /a]"
+	if got := fmt.Sprint(kinds); got != want {
+		t.Errorf("got events %s, want %s", got, want)
+	}
+}
+
+// slowOpenFs counts the files opened and takes its time doing it.
+type slowOpenFs struct {
+	Fs
+	opens int32
+}
+
+func (fs *slowOpenFs) Open(name string) (File, error) {
+	atomic.AddInt32(&fs.opens, 1)
+	time.Sleep(50 * time.Millisecond)
+	return fs.Fs.Open(name)
+}
+
+func TestCacheOnReadFsConcurrentMiss(t *testing.T) {
+	mfs := NewMemMapFs()
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	WriteFile(mfs, "/asset", content, 0644)
+	base := &slowOpenFs{Fs: mfs}
+	layer := NewMemMapFs()
+	ufs := NewCacheOnReadFs(base, layer, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := ReadFile(ufs, "/asset")
+			if err != nil {
+				t.Error(err)
+			} else if !bytes.Equal(data, content) {
+				t.Errorf("read %d bytes, want %d", len(data), len(content))
+			}
+		}()
+	}
+	wg.Wait()
+
+	if opens := atomic.LoadInt32(&base.opens); opens != 1 {
+		t.Errorf("base opened %d times, want once", opens)
+	}
+	fis, _ := ReadDir(layer, "/")
+	if len(fis) != 1 || fis[0].Name() != "asset" {
+		t.Errorf("unexpected files in layer: %v", fis)
+	}
+}
+
+// countingFs counts the Stat and Open calls.
+type countingFs struct {
+	Fs
+	calls int32
+}
+
+func (fs *countingFs) Stat(name string) (os.FileInfo, error) {
+	atomic.AddInt32(&fs.calls, 1)
+	return fs.Fs.Stat(name)
+}
+
+func (fs *countingFs) Open(name string) (File, error) {
+	atomic.AddInt32(&fs.calls, 1)
+	return fs.Fs.Open(name)
+}
+
+func TestCacheOnReadFsNotExist(t *testing.T) {
+	base := &countingFs{Fs: NewMemMapFs()}
+	ufs := NewCacheOnReadFsWithOptions(base, NewMemMapFs(), CacheOptions{NotExistTime: time.Minute})
+
+	for i := 0; i < 3; i++ {
+		if _, err := ufs.Stat("/missing"); !os.IsNotExist(err) {
+			t.Fatalf("expected not exist, got %v", err)
+		}
+	}
+	if base.calls != 1 {
+		t.Errorf("base called %d times, want once", base.calls)
+	}
+
+	if err := WriteFile(ufs, "/missing", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ufs.Stat("/missing"); err != nil {
+		t.Errorf("created file not found: %v", err)
+	}
+}
+
+func TestCacheOnReadFsDirs(t *testing.T) {
+	mfs := NewMemMapFs()
+	mfs.MkdirAll("/d/e", 0755)
+	WriteFile(mfs, "/a", []byte("a"), 0644)
+	WriteFile(mfs, "/d/b", []byte("b"), 0644)
+	WriteFile(mfs, "/d/e/c", []byte("c"), 0644)
+	base := &countingFs{Fs: mfs}
+	ufs := NewCacheOnReadFsWithOptions(base, NewMemMapFs(), CacheOptions{DirTime: time.Minute})
+
+	walk := func() []string {
+		var paths []string
+		err := Walk(ufs, "/", func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			paths = append(paths, path)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return paths
+	}
+	want := "[/ /a /d /d/b /d/e /d/e/c]"
+	if got := fmt.Sprint(walk()); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	calls := base.calls
+	if got := fmt.Sprint(walk()); got != want {
+		t.Fatalf("got %s, want %s", got, want)
+	}
+	if base.calls != calls {
+		t.Errorf("second walk called the base %d times", base.calls-calls)
+	}
+	if _, err := ufs.Stat("/d/missing"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+
+	// changes through the union are seen at once
+	if err := ufs.MkdirAll("/d/f", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ufs.RemoveAll("/a"); err != nil {
+		t.Fatal(err)
+	}
+	want = "[/ /d /d/b /d/e /d/e/c /d/f]"
+	if got := fmt.Sprint(walk()); got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestCacheOnReadFsWriteBack(t *testing.T) {
+	base := NewMemMapFs()
+	base.Mkdir("/out", 0755)
+	ufs := NewCacheOnReadFsWithOptions(base, NewMemMapFs(), CacheOptions{WriteBack: true}).(*CacheOnReadFs)
+
+	if err := WriteFile(ufs, "/out/a", []byte("aaa"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := Exists(base, "/out/a"); ok {
+		t.Error("written to the base before the flush")
+	}
+	if data, err := ReadFile(ufs, "/out/a"); err != nil || string(data) != "aaa" {
+		t.Errorf("read %q, %v", data, err)
+	}
+	if err := ufs.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ReadFile(base, "/out/a"); err != nil || string(data) != "aaa" {
+		t.Errorf("flushed %q, %v", data, err)
+	}
+	if stats := ufs.Stats(); stats.Flushes != 1 || stats.BytesFlushed != 3 {
+		t.Errorf("unexpected flushes: %+v", stats)
+	}
+
+	// a file opened for writing but not changed is not flushed again
+	f, err := ufs.OpenFile("/out/a", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := ufs.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if stats := ufs.Stats(); stats.Flushes != 1 {
+		t.Errorf("unchanged file flushed: %+v", stats)
+	}
+
+	// a file removed before the flush never reaches the base
+	WriteFile(ufs, "/out/b", []byte("b"), 0644)
+	if err := ufs.Remove("/out/b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ufs.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := Exists(base, "/out/b"); ok {
+		t.Error("removed file flushed")
+	}
+}
+
+func TestCacheOnReadFsWriteBackMaxDirty(t *testing.T) {
+	base := NewMemMapFs()
+	ufs := NewCacheOnReadFsWithOptions(base, NewMemMapFs(), CacheOptions{
+		WriteBack:     true,
+		MaxDirtyBytes: 10,
+	})
+
+	WriteFile(ufs, "/a", []byte("012345"), 0644)
+	if ok, _ := Exists(base, "/a"); ok {
+		t.Error("flushed below the limit")
+	}
+	WriteFile(ufs, "/b", []byte("012345"), 0644)
+	for _, name := range []string{"/a", "/b"} {
+		if ok, _ := Exists(base, name); !ok {
+			t.Errorf("%s not flushed over the limit", name)
+		}
+	}
+
+	// the limit holds for files still open
+	f, err := ufs.Create("/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write([]byte("012345"))
+	f.Write([]byte("012345"))
+	if data, _ := ReadFile(base, "/c"); string(data) != "012345012345" {
+		t.Errorf("open file not flushed over the limit, base has %q", data)
+	}
+}
+
+func TestCacheOnReadFsWriteBackErrors(t *testing.T) {
+	mfs := NewMemMapFs()
+	mfs.Mkdir("/ro", 0755)
+	mfs.Mkdir("/rw", 0755)
+	// only /ro is read-only
+	base := &rwFilterFs{Fs: mfs, readOnly: "/ro"}
+	ufs := NewCacheOnReadFsWithOptions(base, NewMemMapFs(), CacheOptions{WriteBack: true}).(*CacheOnReadFs)
+
+	WriteFile(ufs, "/ro/a", []byte("a"), 0644)
+	WriteFile(ufs, "/rw/b", []byte("b"), 0644)
+	for i := 0; i < 2; i++ {
+		err := ufs.Flush()
+		ferr, ok := err.(*FlushError)
+		if !ok {
+			t.Fatalf("expected a FlushError, got %v", err)
+		}
+		if len(ferr.Errs) != 1 || ferr.Errs["/ro/a"] == nil {
+			t.Errorf("unexpected errors: %v", ferr)
+		}
+	}
+	if ok, _ := Exists(mfs, "/rw/b"); !ok {
+		t.Error("/rw/b not flushed")
+	}
+}
+
+// rwFilterFs fails creating files below readOnly.
+type rwFilterFs struct {
+	Fs
+	readOnly string
+}
+
+func (fs *rwFilterFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&os.O_CREATE != 0 && strings.HasPrefix(name, fs.readOnly+"/") {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	return fs.Fs.OpenFile(name, flag, perm)
+}
+
+func TestCacheOnReadFsWriteBackInterval(t *testing.T) {
+	base := NewMemMapFs()
+	ufs := NewCacheOnReadFsWithOptions(base, NewMemMapFs(), CacheOptions{
+		WriteBack:     true,
+		FlushInterval: 10 * time.Millisecond,
+	})
+
+	WriteFile(ufs, "/a", []byte("a"), 0644)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if ok, _ := Exists(base, "/a"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("not flushed in the background")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
 // #194
 func TestUniontFileReaddirEmpty(t *testing.T) {
 	osFs := NewOsFs()
@@ -471,3 +918,68 @@ func TestUniontFileReaddirAskForTooMany(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestUnionFileReaddirSorted(t *testing.T) {
+	base := &MemMapFs{}
+	overlay := &MemMapFs{}
+	for i := 0; i < 20; i++ {
+		fs := Fs(base)
+		if i%2 == 0 {
+			fs = overlay
+		}
+		WriteFile(fs, fmt.Sprintf("/dir/file%02d.txt", i), []byte("afero"), 0777)
+	}
+	ufs := &CopyOnWriteFs{base: base, layer: overlay}
+
+	f, err := ufs.Open("/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var names []string
+	for {
+		page, err := f.Readdirnames(3)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, page...)
+	}
+	if len(names) != 20 {
+		t.Fatalf("got %d names: %v", len(names), names)
+	}
+	for i, name := range names {
+		if want := fmt.Sprintf("file%02d.txt", i); name != want {
+			t.Errorf("entry %d: got %s, want %s", i, name, want)
+		}
+	}
+}
+
+func TestUnionSetMerger(t *testing.T) {
+	base := &MemMapFs{}
+	overlay := &MemMapFs{}
+	WriteFile(base, "/dir/b", []byte("base"), 0777)
+	WriteFile(overlay, "/dir/b", []byte("overlay"), 0777)
+	WriteFile(overlay, "/dir/a", []byte("overlay"), 0777)
+
+	// keeps the entries of the base for names present in both
+	baseFirst := func(lofi, bofi []os.FileInfo) ([]os.FileInfo, error) {
+		return defaultUnionMergeDirsFn(bofi, lofi)
+	}
+	cow := &CopyOnWriteFs{base: base, layer: overlay}
+	cow.SetMerger(baseFirst)
+	cache := &CacheOnReadFs{base: base, layer: overlay}
+	cache.SetMerger(baseFirst)
+
+	for _, fs := range []Fs{cow, cache} {
+		fis, err := ReadDir(fs, "/dir")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fis) != 2 || fis[0].Name() != "a" || fis[1].Name() != "b" || fis[1].Size() != 4 {
+			t.Errorf("%s: unexpected entries %v", fs.Name(), fis)
+		}
+	}
+}
@@ -0,0 +1,481 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrOsFsLayer is returned by the methods of a CopyOnWriteFs going through
+// its whole layer when the layer is an OsFs, which is the whole disk. Use a
+// BasePathFs on a directory of its own as layer instead.
+var ErrOsFsLayer = errors.New("the layer is an OsFs")
+
+// ChangeKind is the kind of a Change.
+type ChangeKind int
+
+const (
+	// ChangeAdded is a path not present in the base.
+	ChangeAdded ChangeKind = iota + 1
+	// ChangeModified is a path whose content, link target or type differs
+	// from the base.
+	ChangeModified
+	// ChangeDeleted is a path of the base removed in the layer.
+	ChangeDeleted
+	// ChangeModeChanged is a path whose permission bits differ from the
+	// base, with the same content.
+	ChangeModeChanged
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdded:
+		return "added"
+	case ChangeModified:
+		return "modified"
+	case ChangeDeleted:
+		return "deleted"
+	case ChangeModeChanged:
+		return "mode changed"
+	}
+	return "unknown"
+}
+
+// Change is a difference between the layer of a CopyOnWriteFs and its base.
+type Change struct {
+	Path string
+	Kind ChangeKind
+}
+
+func (c Change) String() string {
+	return c.Kind.String() + " " + c.Path
+}
+
+func (u *CopyOnWriteFs) checkLayer() error {
+	switch u.layer.(type) {
+	case OsFs, *OsFs:
+		return ErrOsFsLayer
+	}
+	return nil
+}
+
+// Diff lists the changes made in the layer compared to the base, sorted by
+// path. A removed directory is reported alone, not with its content.
+// Directories which only exist in the layer to hold changed files are not
+// reported, nor the owner permissions added to them in the layer, unless
+// they were set with Chmod.
+func (u *CopyOnWriteFs) Diff() ([]Change, error) {
+	if err := u.checkLayer(); err != nil {
+		return nil, err
+	}
+	var changes []Change
+	err := Walk(u.layer, FilePathSeparator, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == FilePathSeparator {
+			return nil
+		}
+		dir, name := filepath.Dir(path), filepath.Base(path)
+		switch {
+		case name == whiteoutOpaque:
+			deleted, err := u.opaqueDeletions(dir)
+			changes = append(changes, deleted...)
+			return err
+		case strings.HasPrefix(name, whiteoutPrefix):
+			target := filepath.Join(dir, strings.TrimPrefix(name, whiteoutPrefix))
+			if _, err := lstatIfPossible(u.base, target); err == nil {
+				changes = append(changes, Change{Path: target, Kind: ChangeDeleted})
+			}
+			return nil
+		}
+		kind, err := u.compare(path, info)
+		if kind != 0 {
+			changes = append(changes, Change{Path: path, Kind: kind})
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	// a whiteout may remain in an opaque directory
+	uniq := changes[:0]
+	for i, c := range changes {
+		if i == 0 || c.Path != changes[i-1].Path {
+			uniq = append(uniq, c)
+		}
+	}
+	return uniq, nil
+}
+
+// opaqueDeletions lists the entries of the base directory dir hidden by the
+// opaque directory of the layer.
+func (u *CopyOnWriteFs) opaqueDeletions(dir string) ([]Change, error) {
+	f, err := u.base.Open(dir)
+	if err != nil {
+		if u.isNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if !u.inLayer(path) {
+			changes = append(changes, Change{Path: path, Kind: ChangeDeleted})
+		}
+	}
+	return changes, nil
+}
+
+// compare returns how the file of the layer at path differs from the base,
+// or 0.
+func (u *CopyOnWriteFs) compare(path string, info os.FileInfo) (ChangeKind, error) {
+	bfi, err := lstatIfPossible(u.base, path)
+	if err != nil {
+		if u.isNotExist(err) {
+			return ChangeAdded, nil
+		}
+		return 0, err
+	}
+	if info.Mode()&os.ModeType != bfi.Mode()&os.ModeType {
+		return ChangeModified, nil
+	}
+	switch {
+	case info.IsDir():
+	case info.Mode()&os.ModeSymlink != 0:
+		ltarget, err := readlinkIfPossible(u.layer, path)
+		if err != nil {
+			return 0, err
+		}
+		btarget, err := readlinkIfPossible(u.base, path)
+		if err != nil {
+			return 0, err
+		}
+		if ltarget != btarget {
+			return ChangeModified, nil
+		}
+		return 0, nil
+	default:
+		same, err := sameContent(u.layer, u.base, path)
+		if err != nil {
+			return 0, err
+		}
+		if !same {
+			return ChangeModified, nil
+		}
+	}
+	perm, bperm := info.Mode().Perm(), bfi.Mode().Perm()
+	if perm == bperm || (info.IsDir() && u.copiedUpMode(path, perm, bperm)) {
+		return 0, nil
+	}
+	return ChangeModeChanged, nil
+}
+
+func readlinkIfPossible(fs Fs, name string) (string, error) {
+	reader, ok := fs.(LinkReader)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+	}
+	return reader.ReadlinkIfPossible(name)
+}
+
+// sameContent reports whether name has the same content in a and b.
+func sameContent(a, b Fs, name string) (bool, error) {
+	fa, err := a.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := b.Open(name)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	bufa := make([]byte, 32*1024)
+	bufb := make([]byte, 32*1024)
+	for {
+		na, erra := io.ReadFull(fa, bufa)
+		nb, errb := io.ReadFull(fb, bufb)
+		if !bytes.Equal(bufa[:na], bufb[:nb]) {
+			return false, nil
+		}
+		endA := erra == io.EOF || erra == io.ErrUnexpectedEOF
+		endB := errb == io.EOF || errb == io.ErrUnexpectedEOF
+		switch {
+		case erra != nil && !endA:
+			return false, erra
+		case errb != nil && !endB:
+			return false, errb
+		case endA || endB:
+			return endA == endB, nil
+		}
+	}
+}
+
+// Commit applies the changes of the layer to the base and empties the
+// layer. A base wrapped in a ReadOnlyFs, the usual setup, is written
+// through.
+//
+// The commit is all or nothing: the files are first copied to temporary
+// files of the base, then all changes are swapped in by renames, which keep
+// what they replace aside until the end. If anything fails, the changes
+// made to the base so far are rolled back, the layer is kept and Commit can
+// be retried. The changes are still made one at a time, so others using the
+// base meanwhile can see some of them only, and a crash can leave the
+// temporary files behind.
+func (u *CopyOnWriteFs) Commit() error {
+	changes, err := u.Diff()
+	if err != nil {
+		return err
+	}
+	base := u.base
+	if ro, ok := base.(*ReadOnlyFs); ok {
+		base = ro.source
+	}
+	tx := &commitTx{layer: u.layer, base: base, staged: make(map[string]string)}
+	if err := tx.stage(changes); err != nil {
+		tx.rollback()
+		return err
+	}
+	// sorted by path, parents are handled before their content
+	for _, c := range changes {
+		if err := tx.apply(c); err != nil {
+			tx.rollback()
+			return err
+		}
+	}
+	tx.finish()
+	return u.Discard()
+}
+
+// commitTx is a Commit in progress.
+type commitTx struct {
+	layer, base Fs
+	// staged are the temporary files holding the new content by path
+	staged map[string]string
+	// undo reverts the changes applied, in reverse order
+	undo []func() error
+	// aside are the files replaced, removed once everything is applied
+	aside []string
+}
+
+// stage copies the files added or modified in the layer to temporary files
+// in the nearest directory of the base they will be in.
+func (tx *commitTx) stage(changes []Change) error {
+	for _, c := range changes {
+		if c.Kind != ChangeAdded && c.Kind != ChangeModified {
+			continue
+		}
+		fi, err := lstatIfPossible(tx.layer, c.Path)
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		dir := filepath.Dir(c.Path)
+		for dir != filepath.Dir(dir) {
+			if dfi, err := lstatIfPossible(tx.base, dir); err == nil && dfi.IsDir() {
+				break
+			}
+			dir = filepath.Dir(dir)
+		}
+		tmp, err := stageFile(tx.layer, tx.base, c.Path, dir, fi)
+		if err != nil {
+			return err
+		}
+		tx.staged[c.Path] = tmp
+	}
+	return nil
+}
+
+// apply makes the change c to the base, recording how to undo it.
+func (tx *commitTx) apply(c Change) error {
+	base := tx.base
+	bfi, err := lstatIfPossible(base, c.Path)
+	exists := err == nil
+	if c.Kind == ChangeDeleted {
+		if !exists {
+			return nil
+		}
+		return tx.putAside(c.Path)
+	}
+	fi, err := lstatIfPossible(tx.layer, c.Path)
+	if err != nil {
+		return err
+	}
+	if c.Kind == ChangeModeChanged || (exists && bfi.IsDir() && fi.IsDir()) {
+		mode := bfi.Mode()
+		if err := base.Chmod(c.Path, fi.Mode()); err != nil {
+			return err
+		}
+		tx.undo = append(tx.undo, func() error { return base.Chmod(c.Path, mode) })
+		return nil
+	}
+	if exists {
+		if err := tx.putAside(c.Path); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case fi.IsDir():
+		err = base.Mkdir(c.Path, fi.Mode().Perm())
+		if err == nil {
+			err = base.Chmod(c.Path, fi.Mode())
+		}
+	case fi.Mode()&os.ModeSymlink != 0:
+		var target string
+		if target, err = readlinkIfPossible(tx.layer, c.Path); err != nil {
+			return err
+		}
+		linker, ok := base.(Linker)
+		if !ok {
+			return &os.LinkError{Op: "symlink", Old: target, New: c.Path, Err: ErrNoSymlink}
+		}
+		err = linker.SymlinkIfPossible(target, c.Path)
+	default:
+		err = base.Rename(tx.staged[c.Path], c.Path)
+		if err == nil {
+			delete(tx.staged, c.Path)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	tx.undo = append(tx.undo, func() error { return base.RemoveAll(c.Path) })
+	return nil
+}
+
+// putAside renames name to a free name next to it, to be removed by finish
+// or renamed back by rollback.
+func (tx *commitTx) putAside(name string) error {
+	var aside string
+	for {
+		aside = filepath.Join(filepath.Dir(name), "."+filepath.Base(name)+".old."+nextSuffix())
+		if _, err := lstatIfPossible(tx.base, aside); os.IsNotExist(err) {
+			break
+		}
+	}
+	if err := tx.base.Rename(name, aside); err != nil {
+		return err
+	}
+	tx.aside = append(tx.aside, aside)
+	tx.undo = append(tx.undo, func() error { return tx.base.Rename(aside, name) })
+	return nil
+}
+
+// rollback reverts the changes applied and removes the staged files. It
+// goes on after errors, to restore as much as possible.
+func (tx *commitTx) rollback() {
+	for i := len(tx.undo) - 1; i >= 0; i-- {
+		tx.undo[i]()
+	}
+	for _, tmp := range tx.staged {
+		tx.base.Remove(tmp)
+	}
+}
+
+// finish removes the files which were replaced.
+func (tx *commitTx) finish() {
+	for _, aside := range tx.aside {
+		tx.base.RemoveAll(aside)
+	}
+}
+
+// commitFile copies the file name of the layer to a temporary file next to
+// its destination in the base and renames it into place.
+func commitFile(layer, base Fs, name string, fi os.FileInfo) error {
+	tmp, err := stageFile(layer, base, name, filepath.Dir(name), fi)
+	if err != nil {
+		return err
+	}
+	if err := base.Rename(tmp, name); err != nil {
+		base.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// stageFile copies the file name of the layer to a temporary file of the
+// directory dir of the base, with the mode and modification time of fi, and
+// returns its name.
+func stageFile(layer, base Fs, name, dir string, fi os.FileInfo) (string, error) {
+	src, err := layer.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	tmp, err := TempFile(base, dir, "."+filepath.Base(name)+".")
+	if err != nil {
+		return "", err
+	}
+	tmpName := tmp.Name()
+	_, err = io.Copy(tmp, src)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = base.Chmod(tmpName, fi.Mode())
+	}
+	if err == nil {
+		err = base.Chtimes(tmpName, fi.ModTime(), fi.ModTime())
+	}
+	if err != nil {
+		base.Remove(tmpName)
+		return "", err
+	}
+	return tmpName, nil
+}
+
+// Discard drops the changes made through u by emptying its layer.
+func (u *CopyOnWriteFs) Discard() error {
+	if err := u.checkLayer(); err != nil {
+		return err
+	}
+	u.mu.Lock()
+	u.chmodded = nil
+	u.mu.Unlock()
+	f, err := u.layer.Open(FilePathSeparator)
+	if err != nil {
+		return err
+	}
+	names, err := f.Readdirnames(-1)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := u.layer.RemoveAll(filepath.Join(FilePathSeparator, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,13 +2,17 @@ package afero
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
 
-var _ Lstater = (*CopyOnWriteFs)(nil)
+var _ Symlinker = (*CopyOnWriteFs)(nil)
+var _ Chowner = (*CopyOnWriteFs)(nil)
 
 // The CopyOnWriteFs is a union filesystem: a read only base file system with
 // a possibly writeable layer on top. Changes to the file system will only
@@ -16,22 +20,141 @@ var _ Lstater = (*CopyOnWriteFs)(nil)
 // is not present in the overlay will copy the file to the overlay ("changing"
 // includes also calls to e.g. Chtimes() and Chmod()).
 //
-// Reading directories is currently only supported via Open(), not OpenFile().
+// Removing or renaming a file of the base layer records a whiteout in the
+// overlay, an empty file named ".wh.<name>" next to where the file would be,
+// which hides it from then on. A directory of the overlay replacing a removed
+// directory of the base is marked opaque by a ".wh..wh..opq" file in it, the
+// entries of the base directory are hidden then. Like with aufs, names
+// starting with ".wh." are reserved.
 type CopyOnWriteFs struct {
 	base  Fs
 	layer Fs
+
+	// merger is the DirsMerger of the directories opened, nil for the
+	// default
+	merger DirsMerger
+
+	mu sync.Mutex
+	// chmodded are the directories of the layer whose mode was set, the
+	// others have the mode of the base plus copyUpDirPerm
+	chmodded map[string]bool
 }
 
+// copyUpDirPerm are the permission bits added to the directories copied up
+// to the layer, for the files to be copied in them.
+const copyUpDirPerm = 0700
+
 func NewCopyOnWriteFs(base Fs, layer Fs) Fs {
 	return &CopyOnWriteFs{base: base, layer: layer}
 }
 
+// SetMerger sets how the directories present in both layers are merged, see
+// DirsMerger. A nil merger restores the default, which keeps the entry of
+// the overlay for a name present in both.
+func (u *CopyOnWriteFs) SetMerger(merge DirsMerger) {
+	u.merger = merge
+}
+
+const (
+	whiteoutPrefix = ".wh."
+	whiteoutOpaque = whiteoutPrefix + whiteoutPrefix + ".opq"
+)
+
+// isWhiteoutName reports whether name is reserved for the whiteouts.
+func isWhiteoutName(name string) bool {
+	return strings.HasPrefix(filepath.Base(name), whiteoutPrefix)
+}
+
+func whiteoutName(name string) string {
+	return filepath.Join(filepath.Dir(name), whiteoutPrefix+filepath.Base(name))
+}
+
+func (u *CopyOnWriteFs) inLayer(name string) bool {
+	_, err := lstatIfPossible(u.layer, name)
+	return err == nil
+}
+
+// isHidden reports whether name in the base is hidden by a whiteout of it
+// or of one of its parents, or by an opaque parent.
+func (u *CopyOnWriteFs) isHidden(name string) bool {
+	return hiddenBy(u.layer, name)
+}
+
+// hiddenBy reports whether the whiteouts and opaque directories of layer
+// hide name in the layers below it.
+func hiddenBy(layer Fs, name string) bool {
+	exists := func(name string) bool {
+		_, err := lstatIfPossible(layer, name)
+		return err == nil
+	}
+	name = filepath.Clean(name)
+	for p := name; ; {
+		parent := filepath.Dir(p)
+		if parent == p {
+			return false
+		}
+		if exists(whiteoutName(p)) || exists(filepath.Join(parent, whiteoutOpaque)) {
+			return true
+		}
+		p = parent
+	}
+}
+
+// inBase reports whether name is a visible file of the base.
+func (u *CopyOnWriteFs) inBase(name string) bool {
+	if _, err := lstatIfPossible(u.base, name); err != nil {
+		return false
+	}
+	return !u.isHidden(name)
+}
+
+// whiteout hides name of the base.
+func (u *CopyOnWriteFs) whiteout(name string) error {
+	if err := mkdirAllFromBase(u.base, u.layer, filepath.Dir(name), 0777); err != nil {
+		return err
+	}
+	f, err := u.layer.Create(whiteoutName(name))
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// clearWhiteouts removes the whiteouts of name and its parents after name
+// was created in the overlay. The directories which replace a whiteout are
+// made opaque, so the base directories they replace stay hidden.
+func (u *CopyOnWriteFs) clearWhiteouts(name string) error {
+	name = filepath.Clean(name)
+	for p := name; ; {
+		parent := filepath.Dir(p)
+		if parent == p {
+			return nil
+		}
+		if wh := whiteoutName(p); u.inLayer(wh) {
+			if err := u.layer.Remove(wh); err != nil {
+				return err
+			}
+			if isDir, _ := IsDir(u.layer, p); isDir {
+				f, err := u.layer.Create(filepath.Join(p, whiteoutOpaque))
+				if err != nil {
+					return err
+				}
+				f.Close()
+			}
+		}
+		p = parent
+	}
+}
+
 // Returns true if the file is not in the overlay
 func (u *CopyOnWriteFs) isBaseFile(name string) (bool, error) {
 	if _, err := u.layer.Stat(name); err == nil {
 		return false, nil
 	}
 	_, err := u.base.Stat(name)
+	if err == nil && u.isHidden(name) {
+		return false, nil
+	}
 	if err != nil {
 		if oerr, ok := err.(*os.PathError); ok {
 			if oerr.Err == os.ErrNotExist || oerr.Err == syscall.ENOENT || oerr.Err == syscall.ENOTDIR {
@@ -72,14 +195,83 @@ func (u *CopyOnWriteFs) Chmod(name string, mode os.FileMode) error {
 			return err
 		}
 	}
-	return u.layer.Chmod(name, mode)
+	if err := u.layer.Chmod(name, mode); err != nil {
+		return err
+	}
+	u.mu.Lock()
+	if u.chmodded == nil {
+		u.chmodded = make(map[string]bool)
+	}
+	u.chmodded[filepath.Clean(name)] = true
+	u.mu.Unlock()
+	return nil
+}
+
+// copiedUpMode reports whether the directory name of the layer has the mode
+// perm only because it was copied up from the base, where it has bperm.
+func (u *CopyOnWriteFs) copiedUpMode(name string, perm, bperm os.FileMode) bool {
+	if perm != bperm|copyUpDirPerm {
+		return false
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return !u.chmodded[filepath.Clean(name)]
+}
+
+func (u *CopyOnWriteFs) Chown(name string, uid, gid int) error {
+	chowner, ok := u.layer.(Chowner)
+	if !ok {
+		return &os.PathError{Op: "chown", Path: name, Err: ErrNoChown}
+	}
+	b, err := u.isBaseFile(name)
+	if err != nil {
+		return err
+	}
+	if b {
+		if err := u.copyToLayer(name); err != nil {
+			return err
+		}
+	}
+	return chowner.Chown(name, uid, gid)
+}
+
+// Lchown copies a symbolic link present only in the base layer as a link,
+// not the file it points to, to the overlay before changing its ownership.
+func (u *CopyOnWriteFs) Lchown(name string, uid, gid int) error {
+	chowner, ok := u.layer.(Chowner)
+	if !ok {
+		return &os.PathError{Op: "lchown", Path: name, Err: ErrNoChown}
+	}
+	if _, err := lstatIfPossible(u.layer, name); err == nil {
+		return chowner.Lchown(name, uid, gid)
+	}
+	if u.isHidden(name) {
+		return &os.PathError{Op: "lchown", Path: name, Err: os.ErrNotExist}
+	}
+	fi, err := lstatIfPossible(u.base, name)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return u.Chown(name, uid, gid)
+	}
+	if err := copyLinkToLayer(u.base, u.layer, name); err != nil {
+		return err
+	}
+	return chowner.Lchown(name, uid, gid)
 }
 
 func (u *CopyOnWriteFs) Stat(name string) (os.FileInfo, error) {
+	if isWhiteoutName(name) {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+	}
 	fi, err := u.layer.Stat(name)
 	if err != nil {
 		isNotExist := u.isNotExist(err)
 		if isNotExist {
+			if u.isHidden(name) {
+				return nil, &os.PathError{Op: "stat", Path: name, Err: os.ErrNotExist}
+			}
 			return u.base.Stat(name)
 		}
 		return nil, err
@@ -88,6 +280,9 @@ func (u *CopyOnWriteFs) Stat(name string) (os.FileInfo, error) {
 }
 
 func (u *CopyOnWriteFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	if isWhiteoutName(name) {
+		return nil, false, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+	}
 	llayer, ok1 := u.layer.(Lstater)
 	lbase, ok2 := u.base.(Lstater)
 
@@ -103,6 +298,9 @@ func (u *CopyOnWriteFs) LstatIfPossible(name string) (os.FileInfo, bool, error)
 	}
 
 	if ok2 {
+		if u.isHidden(name) {
+			return nil, true, &os.PathError{Op: "lstat", Path: name, Err: os.ErrNotExist}
+		}
 		fi, b, err := lbase.LstatIfPossible(name)
 		if err == nil {
 			return fi, b, nil
@@ -117,6 +315,53 @@ func (u *CopyOnWriteFs) LstatIfPossible(name string) (os.FileInfo, bool, error)
 	return fi, false, err
 }
 
+// SymlinkIfPossible creates the symbolic link in the overlay, the base is
+// never modified.
+func (u *CopyOnWriteFs) SymlinkIfPossible(oldname, newname string) error {
+	if _, _, err := u.LstatIfPossible(newname); err == nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrFileExists}
+	} else if !u.isNotExist(err) {
+		return err
+	}
+	if isWhiteoutName(newname) {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.EINVAL}
+	}
+	linker, ok := u.layer.(Linker)
+	if !ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+	}
+	dir := filepath.Dir(newname)
+	isaDir, err := IsDir(u, dir)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if isaDir {
+		if err = mkdirAllFromBase(u.base, u.layer, dir, 0777); err != nil {
+			return err
+		}
+	}
+	if err := linker.SymlinkIfPossible(oldname, newname); err != nil {
+		return err
+	}
+	return u.clearWhiteouts(newname)
+}
+
+func (u *CopyOnWriteFs) ReadlinkIfPossible(name string) (string, error) {
+	if reader, ok := u.layer.(LinkReader); ok {
+		target, err := reader.ReadlinkIfPossible(name)
+		if err == nil || !u.isNotExist(err) {
+			return target, err
+		}
+	}
+	if u.isHidden(name) {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: os.ErrNotExist}
+	}
+	if reader, ok := u.base.(LinkReader); ok {
+		return reader.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
+
 func (u *CopyOnWriteFs) isNotExist(err error) bool {
 	if e, ok := err.(*os.PathError); ok {
 		err = e.Err
@@ -127,89 +372,212 @@ func (u *CopyOnWriteFs) isNotExist(err error) bool {
 	return false
 }
 
-// Renaming files present only in the base layer is not permitted
+// Rename moves oldname in the overlay. A file or directory of the base is
+// copied to newname in the overlay and whited out at oldname, which is not
+// atomic.
 func (u *CopyOnWriteFs) Rename(oldname, newname string) error {
-	b, err := u.isBaseFile(oldname)
+	oldname, newname = filepath.Clean(oldname), filepath.Clean(newname)
+	linkErr := func(err error) error {
+		if e, ok := err.(*os.PathError); ok {
+			err = e.Err
+		}
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	if isWhiteoutName(newname) {
+		return linkErr(syscall.EINVAL)
+	}
+	ofi, _, err := u.LstatIfPossible(oldname)
 	if err != nil {
-		return err
+		return linkErr(err)
 	}
-	if b {
-		return syscall.EPERM
+	if oldname == newname {
+		return nil
+	}
+	if ofi.IsDir() && strings.HasPrefix(newname, oldname+FilePathSeparator) {
+		return linkErr(syscall.EINVAL)
+	}
+	if isDir, err := IsDir(u, filepath.Dir(newname)); err != nil || !isDir {
+		return linkErr(os.ErrNotExist)
 	}
-	return u.layer.Rename(oldname, newname)
-}
 
-// Removing files present only in the base layer is not permitted. If
-// a file is present in the base layer and the overlay, only the overlay
-// will be removed.
-func (u *CopyOnWriteFs) Remove(name string) error {
-	err := u.layer.Remove(name)
-	switch err {
-	case syscall.ENOENT:
-		_, err = u.base.Stat(name)
-		if err == nil {
-			return syscall.EPERM
+	// Like rename(2), replace an existing file or empty directory of the
+	// same kind.
+	if nfi, _, err := u.LstatIfPossible(newname); err == nil {
+		switch {
+		case ofi.IsDir() && !nfi.IsDir():
+			return linkErr(syscall.ENOTDIR)
+		case !ofi.IsDir() && nfi.IsDir():
+			return linkErr(syscall.EISDIR)
+		}
+		if err := u.Remove(newname); err != nil {
+			return linkErr(err)
+		}
+	}
+
+	if err := mkdirAllFromBase(u.base, u.layer, filepath.Dir(newname), 0777); err != nil {
+		return err
+	}
+	if u.inBase(oldname) {
+		if err := u.copyUp(oldname, newname); err != nil {
+			return err
+		}
+		if err := u.clearWhiteouts(newname); err != nil {
+			return err
 		}
-		return syscall.ENOENT
-	default:
+		return u.RemoveAll(oldname)
+	}
+	if err := u.layer.Rename(oldname, newname); err != nil {
 		return err
 	}
+	return u.clearWhiteouts(newname)
 }
 
-func (u *CopyOnWriteFs) RemoveAll(name string) error {
-	err := u.layer.RemoveAll(name)
-	switch err {
-	case syscall.ENOENT:
-		_, err = u.base.Stat(name)
-		if err == nil {
-			return syscall.EPERM
+// copyUp copies the tree at oldname, as seen through u, to newname in the
+// overlay.
+func (u *CopyOnWriteFs) copyUp(oldname, newname string) error {
+	var dirs []string
+	var modes []os.FileInfo
+	err := Walk(u, oldname, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		target := newname + strings.TrimPrefix(path, oldname)
+		switch {
+		case info.IsDir():
+			dirs = append(dirs, target)
+			modes = append(modes, info)
+			return u.layer.MkdirAll(target, 0777)
+		case info.Mode()&os.ModeSymlink != 0:
+			linker, ok := u.layer.(Linker)
+			if !ok {
+				return &os.LinkError{Op: "symlink", New: target, Err: ErrNoSymlink}
+			}
+			link, err := u.ReadlinkIfPossible(path)
+			if err != nil {
+				return err
+			}
+			return linker.SymlinkIfPossible(link, target)
 		}
-		return syscall.ENOENT
-	default:
+		return u.copyFileUp(path, target, info)
+	})
+	if err != nil {
 		return err
 	}
+	// set the modes last, a read only directory is still filled
+	for i := len(dirs) - 1; i >= 0; i-- {
+		u.layer.Chmod(dirs[i], modes[i].Mode())
+		u.layer.Chtimes(dirs[i], modes[i].ModTime(), modes[i].ModTime())
+	}
+	return nil
 }
 
-func (u *CopyOnWriteFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
-	b, err := u.isBaseFile(name)
+func (u *CopyOnWriteFs) copyFileUp(name, target string, info os.FileInfo) error {
+	src, err := u.Open(name)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	defer src.Close()
+	dst, err := u.layer.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
 	}
+	return u.layer.Chtimes(target, info.ModTime(), info.ModTime())
+}
 
-	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
-		if b {
-			if err = u.copyToLayer(name); err != nil {
-				return nil, err
-			}
-			return u.layer.OpenFile(name, flag, perm)
+// Remove removes name from the overlay and whites it out if it is present
+// in the base.
+func (u *CopyOnWriteFs) Remove(name string) error {
+	fi, _, err := u.LstatIfPossible(name)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	if fi.IsDir() {
+		f, err := u.Open(name)
+		if err != nil {
+			return err
 		}
-
-		dir := filepath.Dir(name)
-		isaDir, err := IsDir(u.base, dir)
-		if err != nil && !os.IsNotExist(err) {
-			return nil, err
+		names, err := f.Readdirnames(-1)
+		f.Close()
+		if err != nil {
+			return err
 		}
-		if isaDir {
-			if err = u.layer.MkdirAll(dir, 0777); err != nil {
-				return nil, err
-			}
-			return u.layer.OpenFile(name, flag, perm)
+		if len(names) > 0 {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
 		}
+	}
+	return u.remove(name)
+}
 
-		isaDir, err = IsDir(u.layer, dir)
-		if err != nil {
-			return nil, err
+// RemoveAll removes path from the overlay and whites it out if it is
+// present in the base.
+func (u *CopyOnWriteFs) RemoveAll(path string) error {
+	if _, _, err := u.LstatIfPossible(path); err != nil {
+		return nil
+	}
+	return u.remove(path)
+}
+
+func (u *CopyOnWriteFs) remove(name string) error {
+	inBase := u.inBase(name)
+	if u.inLayer(name) {
+		// a directory of the overlay may still hold whiteouts
+		if err := u.layer.RemoveAll(name); err != nil {
+			return err
 		}
-		if isaDir {
-			return u.layer.OpenFile(name, flag, perm)
+	}
+	if inBase {
+		return u.whiteout(name)
+	}
+	return nil
+}
+
+func (u *CopyOnWriteFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) == 0 {
+		// reading, including directories
+		return u.Open(name)
+	}
+	if isWhiteoutName(name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.EINVAL}
+	}
+
+	b, err := u.isBaseFile(name)
+	if err != nil {
+		return nil, err
+	}
+	if b {
+		if err = u.copyToLayer(name); err != nil {
+			return nil, err
 		}
+		return u.layer.OpenFile(name, flag, perm)
+	}
 
+	dir := filepath.Dir(name)
+	isaDir, err := IsDir(u, dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if !isaDir {
 		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ENOTDIR} // ...or os.ErrNotExist?
 	}
-	if b {
-		return u.base.OpenFile(name, flag, perm)
+	if err = mkdirAllFromBase(u.base, u.layer, dir, 0777); err != nil {
+		return nil, err
+	}
+	f, err := u.layer.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if err := u.clearWhiteouts(name); err != nil {
+		f.Close()
+		return nil, err
 	}
-	return u.layer.OpenFile(name, flag, perm)
+	return f, nil
 }
 
 // This function handles the 9 different possibilities caused
@@ -217,6 +585,10 @@ func (u *CopyOnWriteFs) OpenFile(name string, flag int, perm os.FileMode) (File,
 //  layer: doesn't exist, exists as a file, and exists as a directory
 //  base:  doesn't exist, exists as a file, and exists as a directory
 func (u *CopyOnWriteFs) Open(name string) (File, error) {
+	if isWhiteoutName(name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+
 	// Since the overlay overrides the base we check that first
 	b, err := u.isBaseFile(name)
 	if err != nil {
@@ -239,13 +611,18 @@ func (u *CopyOnWriteFs) Open(name string) (File, error) {
 
 	// Overlay is a directory, base state now matters.
 	// Base state has 3 states to check but 2 outcomes:
-	// A. It's a file or non-readable in the base (return just the overlay)
+	// A. It's a file, non-readable or hidden in the base (return a
+	//    UnionFile of just the overlay, which hides the whiteouts)
 	// B. It's an accessible directory in the base (return a UnionFile)
 
-	// If base is file or nonreadable, return overlay
+	// If base is file, nonreadable or hidden, return overlay
 	dir, err = IsDir(u.base, name)
-	if !dir || err != nil {
-		return u.layer.Open(name)
+	if !dir || err != nil || u.isHidden(name) {
+		lfile, err := u.layer.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		return &UnionFile{Layer: lfile, Merger: u.merger, whiteouts: true}, nil
 	}
 
 	// Both base & layer are directories
@@ -258,18 +635,24 @@ func (u *CopyOnWriteFs) Open(name string) (File, error) {
 		return nil, fmt.Errorf("BaseErr: %v\nOverlayErr: %v", bErr, lErr)
 	}
 
-	return &UnionFile{Base: bfile, Layer: lfile}, nil
+	return &UnionFile{Base: bfile, Layer: lfile, Merger: u.merger, whiteouts: true}, nil
 }
 
 func (u *CopyOnWriteFs) Mkdir(name string, perm os.FileMode) error {
-	dir, err := IsDir(u.base, name)
-	if err != nil {
-		return u.layer.MkdirAll(name, perm)
+	if isWhiteoutName(name) {
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EINVAL}
 	}
-	if dir {
-		return ErrFileExists
+	if _, err := u.Stat(name); err == nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: ErrFileExists}
+	}
+	// the parent may only exist in the base
+	if err := mkdirAllFromBase(u.base, u.layer, filepath.Dir(name), 0777); err != nil {
+		return err
+	}
+	if err := u.layer.Mkdir(name, perm); err != nil {
+		return err
 	}
-	return u.layer.MkdirAll(name, perm)
+	return u.clearWhiteouts(name)
 }
 
 func (u *CopyOnWriteFs) Name() string {
@@ -277,15 +660,21 @@ func (u *CopyOnWriteFs) Name() string {
 }
 
 func (u *CopyOnWriteFs) MkdirAll(name string, perm os.FileMode) error {
-	dir, err := IsDir(u.base, name)
+	if isWhiteoutName(name) {
+		return &os.PathError{Op: "mkdir", Path: name, Err: syscall.EINVAL}
+	}
+	dir, err := IsDir(u, name)
 	if err != nil {
-		return u.layer.MkdirAll(name, perm)
+		if err := mkdirAllFromBase(u.base, u.layer, name, perm); err != nil {
+			return err
+		}
+		return u.clearWhiteouts(name)
 	}
 	if dir {
 		// This is in line with how os.MkdirAll behaves.
 		return nil
 	}
-	return u.layer.MkdirAll(name, perm)
+	return &os.PathError{Op: "mkdir", Path: name, Err: syscall.ENOTDIR}
 }
 
 func (u *CopyOnWriteFs) Create(name string) (File, error) {
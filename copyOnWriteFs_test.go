@@ -1,9 +1,13 @@
 package afero
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestCopyOnWrite(t *testing.T) {
@@ -60,3 +64,283 @@ func TestCopyOnWriteFileInMemMapBase(t *testing.T) {
 		t.Fatal(err)
 	}
 }
+
+func TestCopyOnWriteWhiteouts(t *testing.T) {
+	base := &MemMapFs{}
+	WriteFile(base, "/dir/a", []byte("a"), 0644)
+	WriteFile(base, "/dir/b", []byte("b"), 0644)
+	WriteFile(base, "/dir/sub/c", []byte("c"), 0644)
+	WriteFile(base, "/other", []byte("other"), 0644)
+	layer := &MemMapFs{}
+	ufs := NewCopyOnWriteFs(NewReadOnlyFs(base), layer)
+
+	readDirNames := func(name string) []string {
+		t.Helper()
+		fis, err := ReadDir(ufs, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		return names
+	}
+	expectNames := func(name string, want ...string) {
+		t.Helper()
+		if got := readDirNames(name); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("ReadDir(%s): got %v, expected %v", name, got, want)
+		}
+	}
+	expectMissing := func(name string) {
+		t.Helper()
+		if _, err := ufs.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("Stat(%s): got %v, expected not exist", name, err)
+		}
+		if _, err := ufs.Open(name); !os.IsNotExist(err) {
+			t.Errorf("Open(%s): got %v, expected not exist", name, err)
+		}
+	}
+
+	if err := ufs.Remove("/dir/a"); err != nil {
+		t.Fatal(err)
+	}
+	expectMissing("/dir/a")
+	expectNames("/dir", "b", "sub")
+	expectMissing("/dir/.wh.a")
+	if _, err := base.Stat("/dir/a"); err != nil {
+		t.Errorf("the base was changed: %v", err)
+	}
+
+	if err := WriteFile(ufs, "/dir/a", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := ReadFile(ufs, "/dir/a"); string(content) != "new" {
+		t.Errorf("read %q after recreating /dir/a", content)
+	}
+	expectNames("/dir", "a", "b", "sub")
+
+	if err, ok := ufs.Remove("/dir/sub").(*os.PathError); !ok || err.Err != syscall.ENOTEMPTY {
+		t.Errorf("Remove of a non empty directory: got %v", err)
+	}
+	if err := ufs.RemoveAll("/dir/sub"); err != nil {
+		t.Fatal(err)
+	}
+	expectMissing("/dir/sub/c")
+	if err := ufs.Mkdir("/dir/sub", 0755); err != nil {
+		t.Fatal(err)
+	}
+	// the new directory doesn't show the content of the removed one
+	expectNames("/dir/sub")
+	expectMissing("/dir/sub/c")
+
+	if err := ufs.Rename("/other", "/dir/moved"); err != nil {
+		t.Fatal(err)
+	}
+	expectMissing("/other")
+	if content, _ := ReadFile(ufs, "/dir/moved"); string(content) != "other" {
+		t.Errorf("read %q from the renamed file", content)
+	}
+
+	if err := ufs.Rename("/dir", "/renamed"); err != nil {
+		t.Fatal(err)
+	}
+	expectMissing("/dir")
+	expectMissing("/dir/b")
+	expectNames("/", "renamed")
+	expectNames("/renamed", "a", "b", "moved", "sub")
+	expectNames("/renamed/sub")
+	if content, _ := ReadFile(ufs, "/renamed/b"); string(content) != "b" {
+		t.Errorf("read %q from a file of the renamed directory", content)
+	}
+}
+
+func TestCopyOnWriteDiffCommit(t *testing.T) {
+	base := &MemMapFs{}
+	WriteFile(base, "/dir/a", []byte("a"), 0644)
+	WriteFile(base, "/dir/b", []byte("b"), 0644)
+	WriteFile(base, "/dir/c", []byte("c"), 0644)
+	WriteFile(base, "/old/x", []byte("x"), 0644)
+	WriteFile(base, "/other", []byte("other"), 0644)
+	ufs := NewCopyOnWriteFs(NewReadOnlyFs(base), &MemMapFs{}).(*CopyOnWriteFs)
+
+	WriteFile(ufs, "/dir/a", []byte("changed"), 0644)
+	ufs.Chmod("/dir/b", 0600)
+	now := time.Now().Add(time.Hour)
+	ufs.Chtimes("/dir/c", now, now)
+	WriteFile(ufs, "/dir/new", []byte("new"), 0644)
+	ufs.Mkdir("/newdir", 0755)
+	WriteFile(ufs, "/newdir/x", []byte("x"), 0644)
+	ufs.Remove("/other")
+	ufs.RemoveAll("/old")
+
+	changes, err := ufs.Diff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Change{
+		{"/dir/a", ChangeModified},
+		{"/dir/b", ChangeModeChanged},
+		{"/dir/new", ChangeAdded},
+		{"/newdir", ChangeAdded},
+		{"/newdir/x", ChangeAdded},
+		{"/old", ChangeDeleted},
+		{"/other", ChangeDeleted},
+	}
+	if fmt.Sprint(changes) != fmt.Sprint(want) {
+		t.Fatalf("got changes %v, expected %v", changes, want)
+	}
+
+	if err := ufs.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"/dir/a":    "changed",
+		"/dir/b":    "b",
+		"/dir/new":  "new",
+		"/newdir/x": "x",
+	} {
+		if got, err := ReadFile(base, name); err != nil || string(got) != content {
+			t.Errorf("%s in the base: got %q, %v, expected %q", name, got, err, content)
+		}
+	}
+	if fi, _ := base.Stat("/dir/b"); fi == nil || fi.Mode() != 0600 {
+		t.Errorf("the mode of /dir/b was not committed")
+	}
+	for _, name := range []string{"/old", "/other"} {
+		if _, err := base.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s is still in the base: %v", name, err)
+		}
+	}
+	if changes, _ := ufs.Diff(); len(changes) != 0 {
+		t.Errorf("got changes %v after Commit", changes)
+	}
+}
+
+func TestCopyOnWriteDiffReadOnlyDir(t *testing.T) {
+	base := &MemMapFs{}
+	base.Mkdir("/d", 0555)
+	base.Chmod("/d", os.ModeDir|0555)
+	WriteFile(base, "/d/f", []byte("f"), 0444)
+	ufs := NewCopyOnWriteFs(base, &MemMapFs{}).(*CopyOnWriteFs)
+
+	// /d is copied up with the owner permissions added
+	if err := WriteFile(ufs, "/d/f", []byte("changed"), 0444); err != nil {
+		t.Fatal(err)
+	}
+	changes, err := ufs.Diff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(changes); got != "[modified /d/f]" {
+		t.Errorf("got changes %s", got)
+	}
+	if err := ufs.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := base.Stat("/d"); fi == nil || fi.Mode().Perm() != 0555 {
+		t.Errorf("the mode of /d changed: %v", fi.Mode())
+	}
+
+	// a mode set through the CopyOnWriteFs is a change
+	WriteFile(ufs, "/d/f", []byte("again"), 0444)
+	ufs.Chmod("/d", 0755)
+	if changes, _ := ufs.Diff(); fmt.Sprint(changes) != "[mode changed /d modified /d/f]" {
+		t.Errorf("got changes %v", changes)
+	}
+	if err := ufs.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := base.Stat("/d"); fi == nil || fi.Mode().Perm() != 0755 {
+		t.Errorf("the mode of /d was not committed: %v", fi.Mode())
+	}
+}
+
+// failRenameFs fails the renames to a name.
+type failRenameFs struct {
+	Fs
+	to string
+}
+
+func (fs *failRenameFs) Rename(oldname, newname string) error {
+	if newname == fs.to {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EIO}
+	}
+	return fs.Fs.Rename(oldname, newname)
+}
+
+func TestCopyOnWriteCommitRollback(t *testing.T) {
+	mem := &MemMapFs{}
+	WriteFile(mem, "/dir/a", []byte("a"), 0644)
+	WriteFile(mem, "/dir/b", []byte("b"), 0644)
+	WriteFile(mem, "/old/x", []byte("x"), 0644)
+	base := &failRenameFs{Fs: mem, to: "/z"}
+	ufs := NewCopyOnWriteFs(base, &MemMapFs{}).(*CopyOnWriteFs)
+
+	WriteFile(ufs, "/dir/a", []byte("changed"), 0644)
+	ufs.Chmod("/dir/b", 0600)
+	ufs.RemoveAll("/old")
+	ufs.Mkdir("/newdir", 0755)
+	WriteFile(ufs, "/newdir/x", []byte("x"), 0644)
+	WriteFile(ufs, "/z", []byte("z"), 0644)
+	changes, _ := ufs.Diff()
+
+	// /z is the last change, all the others are undone
+	if err := ufs.Commit(); !isErrno(err, syscall.EIO) {
+		t.Fatalf("got %v, expected the rename to fail", err)
+	}
+	if content, _ := ReadFile(mem, "/dir/a"); string(content) != "a" {
+		t.Errorf("/dir/a in the base: got %q", content)
+	}
+	if fi, _ := mem.Stat("/dir/b"); fi == nil || fi.Mode() != 0644 {
+		t.Errorf("the mode of /dir/b was not restored")
+	}
+	if content, _ := ReadFile(mem, "/old/x"); string(content) != "x" {
+		t.Errorf("/old/x in the base: got %q", content)
+	}
+	for dir, want := range map[string]string{"/": "dir old", "/dir": "a b", "/old": "x"} {
+		if got := listNames(t, mem, dir); got != want {
+			t.Errorf("%s in the base: got %q, expected %q", dir, got, want)
+		}
+	}
+	if got, _ := ufs.Diff(); fmt.Sprint(got) != fmt.Sprint(changes) {
+		t.Errorf("got changes %v after the failure, expected %v", got, changes)
+	}
+
+	base.to = ""
+	if err := ufs.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := ReadFile(mem, "/z"); string(content) != "z" {
+		t.Errorf("/z in the base: got %q", content)
+	}
+	if got := listNames(t, mem, "/"); got != "dir newdir z" {
+		t.Errorf("/ in the base: got %q", got)
+	}
+}
+
+func TestCopyOnWriteDiscard(t *testing.T) {
+	base := &MemMapFs{}
+	WriteFile(base, "/a", []byte("a"), 0644)
+	ufs := NewCopyOnWriteFs(base, &MemMapFs{}).(*CopyOnWriteFs)
+
+	WriteFile(ufs, "/a", []byte("changed"), 0644)
+	WriteFile(ufs, "/b", []byte("b"), 0644)
+	if err := ufs.Discard(); err != nil {
+		t.Fatal(err)
+	}
+	if changes, _ := ufs.Diff(); len(changes) != 0 {
+		t.Errorf("got changes %v after Discard", changes)
+	}
+	if content, _ := ReadFile(ufs, "/a"); string(content) != "a" {
+		t.Errorf("read %q after Discard", content)
+	}
+	if _, err := ufs.Stat("/b"); !os.IsNotExist(err) {
+		t.Errorf("/b still exists after Discard: %v", err)
+	}
+
+	osLayer := NewCopyOnWriteFs(base, NewOsFs()).(*CopyOnWriteFs)
+	if err := osLayer.Discard(); err != ErrOsFsLayer {
+		t.Errorf("Discard of an OsFs layer: got %v", err)
+	}
+}
@@ -0,0 +1,490 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var _ Symlinker = (*FilterFs)(nil)
+var _ Chowner = (*FilterFs)(nil)
+
+// FilterOptions configures a FilterFs.
+type FilterOptions struct {
+	// Patterns are the patterns of the root directory, in the syntax of
+	// the lines of a .gitignore file.
+	Patterns []string
+
+	// IgnoreFile is the name of the files read from the source, like
+	// ".gitignore", with the patterns of the directory they are in. They
+	// come after Patterns, and the ones of a directory after the ones of
+	// its parent. Empty means none are read.
+	IgnoreFile string
+
+	// Dockerignore matches the patterns the way docker build matches the
+	// ones of a .dockerignore: IgnoreFile is only read in the root, every
+	// pattern is relative to the root, a pattern matches the files below
+	// the directories it matches, and a negated pattern includes the files
+	// of an excluded directory again.
+	Dockerignore bool
+}
+
+// The FilterFs hides the files and directories excluded by patterns like
+// the ones of a .gitignore file: a later pattern overrides the earlier
+// ones, "!" negates a pattern, a trailing "/" only matches directories, a
+// pattern without a slash matches at any depth, and "**" matches any
+// number of directories. Like with git, the files of an excluded directory
+// cannot be included again.
+//
+// The hidden files do not exist for any method, they are left out of the
+// directory listings. A symbolic link leading to a hidden file is listed,
+// but the hidden file cannot be reached through it. The ignore files read
+// from the source are kept, the ones changed through the FilterFs are read
+// again.
+type FilterFs struct {
+	source  Fs
+	options FilterOptions
+	rules   []filterRule
+
+	mu      sync.Mutex
+	ignores map[string][]filterRule // directory -> the rules of its IgnoreFile
+}
+
+// NewFilterFs returns a FilterFs over source, hiding the files excluded by
+// opts.
+func NewFilterFs(source Fs, opts FilterOptions) Fs {
+	return &FilterFs{
+		source:  source,
+		options: opts,
+		rules:   parseFilterRules(opts.Patterns, nil, opts.Dockerignore),
+	}
+}
+
+func (r *FilterFs) Name() string {
+	return "FilterFs"
+}
+
+// ignoreRules returns the rules of the IgnoreFile of the directory dir.
+func (r *FilterFs) ignoreRules(dir []string) ([]filterRule, error) {
+	if r.options.IgnoreFile == "" || (r.options.Dockerignore && len(dir) > 0) {
+		return nil, nil
+	}
+	key := strings.Join(dir, "/")
+	r.mu.Lock()
+	rules, ok := r.ignores[key]
+	r.mu.Unlock()
+	if ok {
+		return rules, nil
+	}
+
+	name := filepath.Join(append(append([]string{FilePathSeparator}, dir...), r.options.IgnoreFile)...)
+	data, err := ReadFile(r.source, name)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	rules = parseFilterRules(strings.Split(string(data), "\n"), dir, r.options.Dockerignore)
+
+	r.mu.Lock()
+	if r.ignores == nil {
+		r.ignores = make(map[string][]filterRule)
+	}
+	r.ignores[key] = rules
+	r.mu.Unlock()
+	return rules, nil
+}
+
+// forget drops the rules read from the ignore files, after they changed.
+func (r *FilterFs) forget() {
+	r.mu.Lock()
+	r.ignores = nil
+	r.mu.Unlock()
+}
+
+// hidden reports whether name is excluded.
+func (r *FilterFs) hidden(name string, isDir bool) (bool, error) {
+	components := splitPath(filepath.Clean(FilePathSeparator + name))
+	if len(components) == 0 {
+		return false, nil
+	}
+	if r.options.Dockerignore {
+		return r.dockerHidden(components, isDir)
+	}
+
+	rules := r.rules
+	for i := 1; i <= len(components); i++ {
+		more, err := r.ignoreRules(components[:i-1])
+		if err != nil {
+			return false, err
+		}
+		rules = append(rules[:len(rules):len(rules)], more...)
+		dir := i < len(components) || isDir
+		excluded := false
+		for _, rule := range rules {
+			if rule.matches(components[:i], dir) {
+				excluded = !rule.negate
+			}
+		}
+		if excluded {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// dockerHidden is hidden for a FilterFs matching like a .dockerignore. An
+// excluded directory is kept if a negated pattern may include files below
+// it.
+func (r *FilterFs) dockerHidden(components []string, isDir bool) (bool, error) {
+	more, err := r.ignoreRules(nil)
+	if err != nil {
+		return false, err
+	}
+	rules := append(r.rules[:len(r.rules):len(r.rules)], more...)
+	excluded := false
+	for _, rule := range rules {
+		for i := 1; i <= len(components); i++ {
+			if rule.matches(components[:i], i < len(components) || isDir) {
+				excluded = !rule.negate
+				break
+			}
+		}
+	}
+	if !excluded || !isDir {
+		return excluded, nil
+	}
+	for _, rule := range rules {
+		if rule.negate && matchesBelow(rule.pattern, components) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// check returns an error if name is hidden, or leads to a hidden file
+// through symbolic links, see checkLinks.
+func (r *FilterFs) check(op, name string, follow bool) error {
+	fi, err := lstatIfPossible(r.source, name)
+	isDir := err == nil && fi.IsDir()
+	if err := r.checkIsDir(op, name, isDir); err != nil {
+		return err
+	}
+	return r.checkLinks(op, name, follow)
+}
+
+// checkLinks returns an error if the symbolic links in name lead to a
+// hidden file, the last component of name only if follow is set. The
+// components reached through a link are checked like the ones of name.
+func (r *FilterFs) checkLinks(op, name string, follow bool) error {
+	todo := splitPath(filepath.Clean(FilePathSeparator + name))
+	var done []string
+	linked := false
+	for links := 0; len(todo) > 0; {
+		c := todo[0]
+		todo = todo[1:]
+		switch c {
+		case ".":
+			continue
+		case "..":
+			if len(done) > 0 {
+				done = done[:len(done)-1]
+			}
+			continue
+		}
+		done = append(done, c)
+		path := filepath.Join(append([]string{FilePathSeparator}, done...)...)
+		fi, err := lstatIfPossible(r.source, path)
+		if linked {
+			if err := r.checkIsDir(op, path, len(todo) > 0 || (err == nil && fi.IsDir())); err != nil {
+				return &os.PathError{Op: op, Path: name, Err: syscall.ENOENT}
+			}
+		}
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 || (len(todo) == 0 && !follow) {
+			continue
+		}
+
+		if links++; links > maxSymlinks {
+			return &os.PathError{Op: op, Path: name, Err: syscall.ELOOP}
+		}
+		target, err := readlinkIfPossible(r.source, path)
+		if err != nil {
+			return err
+		}
+		done = done[:len(done)-1]
+		if filepath.IsAbs(target) {
+			done = nil
+			target = strings.TrimPrefix(target, filepath.VolumeName(target))
+		}
+		todo = append(splitPath(target), todo...)
+		linked = true
+	}
+	return nil
+}
+
+func (r *FilterFs) checkIsDir(op, name string, isDir bool) error {
+	hidden, err := r.hidden(name, isDir)
+	if err != nil {
+		return err
+	}
+	if hidden {
+		return &os.PathError{Op: op, Path: name, Err: syscall.ENOENT}
+	}
+	return nil
+}
+
+func (r *FilterFs) Chtimes(name string, a, m time.Time) error {
+	if err := r.check("chtimes", name, true); err != nil {
+		return err
+	}
+	return r.source.Chtimes(name, a, m)
+}
+
+func (r *FilterFs) Chmod(name string, mode os.FileMode) error {
+	if err := r.check("chmod", name, true); err != nil {
+		return err
+	}
+	return r.source.Chmod(name, mode)
+}
+
+func (r *FilterFs) Chown(name string, uid, gid int) error {
+	if err := r.check("chown", name, true); err != nil {
+		return err
+	}
+	if chowner, ok := r.source.(Chowner); ok {
+		return chowner.Chown(name, uid, gid)
+	}
+	return &os.PathError{Op: "chown", Path: name, Err: ErrNoChown}
+}
+
+func (r *FilterFs) Lchown(name string, uid, gid int) error {
+	if err := r.check("lchown", name, false); err != nil {
+		return err
+	}
+	if chowner, ok := r.source.(Chowner); ok {
+		return chowner.Lchown(name, uid, gid)
+	}
+	return &os.PathError{Op: "lchown", Path: name, Err: ErrNoChown}
+}
+
+func (r *FilterFs) Stat(name string) (os.FileInfo, error) {
+	fi, err := r.source.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkIsDir("stat", name, fi.IsDir()); err != nil {
+		return nil, err
+	}
+	if err := r.checkLinks("stat", name, true); err != nil {
+		return nil, err
+	}
+	return fi, nil
+}
+
+func (r *FilterFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	var fi os.FileInfo
+	var lstat bool
+	var err error
+	if lstater, ok := r.source.(Lstater); ok {
+		fi, lstat, err = lstater.LstatIfPossible(name)
+	} else {
+		fi, err = r.source.Stat(name)
+	}
+	if err != nil {
+		return nil, lstat, err
+	}
+	if err := r.checkIsDir("lstat", name, fi.IsDir()); err != nil {
+		return nil, lstat, err
+	}
+	if err := r.checkLinks("lstat", name, false); err != nil {
+		return nil, lstat, err
+	}
+	return fi, lstat, nil
+}
+
+func (r *FilterFs) SymlinkIfPossible(oldname, newname string) error {
+	if err := r.check("symlink", newname, false); err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: syscall.ENOENT}
+	}
+	if linker, ok := r.source.(Linker); ok {
+		return linker.SymlinkIfPossible(oldname, newname)
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+}
+
+func (r *FilterFs) ReadlinkIfPossible(name string) (string, error) {
+	if err := r.check("readlink", name, false); err != nil {
+		return "", err
+	}
+	if reader, ok := r.source.(LinkReader); ok {
+		return reader.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
+
+func (r *FilterFs) Rename(oldname, newname string) error {
+	fi, err := lstatIfPossible(r.source, oldname)
+	isDir := err == nil && fi.IsDir()
+	for _, name := range []string{oldname, newname} {
+		if err := r.checkIsDir("rename", name, isDir); err != nil || r.checkLinks("rename", name, false) != nil {
+			return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.ENOENT}
+		}
+	}
+	defer r.forget()
+	return r.source.Rename(oldname, newname)
+}
+
+func (r *FilterFs) RemoveAll(path string) error {
+	if err := r.check("removeall", path, false); err != nil {
+		return err
+	}
+	defer r.forget()
+	return r.source.RemoveAll(path)
+}
+
+func (r *FilterFs) Remove(name string) error {
+	if err := r.check("remove", name, false); err != nil {
+		return err
+	}
+	if filepath.Base(name) == r.options.IgnoreFile {
+		defer r.forget()
+	}
+	return r.source.Remove(name)
+}
+
+func (r *FilterFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if err := r.check("open", name, true); err != nil {
+		return nil, err
+	}
+	f, err := r.source.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return &FilterFile{File: f, fs: r, name: name, write: flag&(os.O_WRONLY|os.O_RDWR|os.O_APPEND|os.O_TRUNC) != 0}, nil
+}
+
+func (r *FilterFs) Open(name string) (File, error) {
+	f, err := r.source.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := r.checkIsDir("open", name, fi.IsDir()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := r.checkLinks("open", name, true); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &FilterFile{File: f, fs: r, name: name}, nil
+}
+
+func (r *FilterFs) Mkdir(name string, perm os.FileMode) error {
+	if err := r.checkIsDir("mkdir", name, true); err != nil {
+		return err
+	}
+	if err := r.checkLinks("mkdir", name, true); err != nil {
+		return err
+	}
+	return r.source.Mkdir(name, perm)
+}
+
+func (r *FilterFs) MkdirAll(path string, perm os.FileMode) error {
+	if err := r.checkIsDir("mkdir", path, true); err != nil {
+		return err
+	}
+	if err := r.checkLinks("mkdir", path, true); err != nil {
+		return err
+	}
+	return r.source.MkdirAll(path, perm)
+}
+
+func (r *FilterFs) Create(name string) (File, error) {
+	if err := r.checkIsDir("create", name, false); err != nil {
+		return nil, err
+	}
+	if err := r.checkLinks("create", name, true); err != nil {
+		return nil, err
+	}
+	f, err := r.source.Create(name)
+	if err != nil {
+		return nil, err
+	}
+	return &FilterFile{File: f, fs: r, name: name, write: true}, nil
+}
+
+// FilterFile is a file opened through a FilterFs, its directory listings
+// leave the hidden files out.
+type FilterFile struct {
+	File
+	fs    *FilterFs
+	name  string
+	write bool
+}
+
+func (f *FilterFile) Close() error {
+	if f.write && filepath.Base(f.name) == f.fs.options.IgnoreFile {
+		f.fs.forget()
+	}
+	return f.File.Close()
+}
+
+// Readdir returns up to count entries which are not hidden, count <= 0
+// means all of them.
+func (f *FilterFile) Readdir(count int) ([]os.FileInfo, error) {
+	var fis []os.FileInfo
+	for {
+		n := count
+		if count > 0 {
+			n = count - len(fis)
+		}
+		batch, err := f.File.Readdir(n)
+		for _, fi := range batch {
+			hidden, herr := f.fs.hidden(filepath.Join(f.name, fi.Name()), fi.IsDir())
+			if herr != nil {
+				return fis, herr
+			}
+			if !hidden {
+				fis = append(fis, fi)
+			}
+		}
+		if count <= 0 || err != nil {
+			if err == io.EOF && len(fis) > 0 {
+				err = nil
+			}
+			return fis, err
+		}
+		if len(fis) >= count {
+			return fis, nil
+		}
+	}
+}
+
+func (f *FilterFile) Readdirnames(count int) ([]string, error) {
+	fis, err := f.Readdir(count)
+	names := make([]string, 0, len(fis))
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	return names, err
+}
@@ -0,0 +1,160 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"path"
+	"strings"
+)
+
+// filterRule is a pattern of a FilterFs.
+type filterRule struct {
+	base    []string // the directory the pattern is relative to
+	pattern []string // the components, "**" matches any number of them
+	negate  bool
+	dirOnly bool
+}
+
+// parseFilterRules parses the patterns of lines, the lines of an ignore file
+// of the directory base.
+func parseFilterRules(lines []string, base []string, docker bool) []filterRule {
+	var rules []filterRule
+	for _, line := range lines {
+		if rule, ok := parseFilterRule(line, base, docker); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// parseFilterRule parses a line of an ignore file, and reports whether it
+// holds a pattern.
+func parseFilterRule(line string, base []string, docker bool) (filterRule, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	if docker {
+		line = strings.TrimSpace(line)
+	} else {
+		// trailing spaces are kept if escaped
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+	}
+	if line == "" || strings.HasPrefix(line, "#") {
+		return filterRule{}, false
+	}
+
+	rule := filterRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		// .dockerignore patterns are cleaned, "dir/" is "dir"
+		rule.dirOnly = !docker
+		line = strings.TrimRight(line, "/")
+	}
+
+	// a pattern with a slash is relative to base, the other ones match at
+	// any depth, except in a .dockerignore
+	anchored := docker || strings.Contains(line, "/")
+	for _, p := range strings.Split(line, "/") {
+		if p == "" || p == "." {
+			continue
+		}
+		if p == ".." && docker {
+			if len(rule.pattern) > 0 {
+				rule.pattern = rule.pattern[:len(rule.pattern)-1]
+			}
+			continue
+		}
+		rule.pattern = append(rule.pattern, p)
+	}
+	if len(rule.pattern) == 0 {
+		return filterRule{}, false
+	}
+	if !anchored {
+		rule.pattern = append([]string{"**"}, rule.pattern...)
+	}
+	return rule, true
+}
+
+// relative returns name relative to the base of r, and whether it is below
+// it.
+func (r filterRule) relative(name []string) ([]string, bool) {
+	if len(name) <= len(r.base) {
+		return nil, false
+	}
+	for i, b := range r.base {
+		if name[i] != b {
+			return nil, false
+		}
+	}
+	return name[len(r.base):], true
+}
+
+// matches reports whether r matches name.
+func (r filterRule) matches(name []string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	rel, ok := r.relative(name)
+	return ok && matchComponents(r.pattern, rel)
+}
+
+// matchComponents reports whether pattern matches name. A "**" matches any
+// number of components, at least one at the end of the pattern.
+func matchComponents(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			if len(rest) == 0 {
+				return len(name) > 0
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchComponents(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// matchesBelow reports whether pattern may match the names below the
+// directory name.
+func matchesBelow(pattern, name []string) bool {
+	for len(name) > 0 {
+		if len(pattern) == 0 {
+			return false
+		}
+		if pattern[0] == "**" {
+			return true
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(pattern) > 0
+}
@@ -0,0 +1,167 @@
+package afero
+
+import (
+	"os"
+	"testing"
+)
+
+func newTestFilterSource(t *testing.T) Fs {
+	fs := NewMemMapFs()
+	for _, name := range []string{
+		"/main.go", "/debug.log", "/build/out.bin", "/logs/keep.txt", "/logs/a.log",
+		"/src/app.go", "/src/gen/x.go", "/src/tmp/t.go", "/docs/build", "/vendor/lib/lib.go",
+	} {
+		if err := WriteFile(fs, name, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	WriteFile(fs, "/src/.gitignore", []byte("gen/\n# comment\n*.go\n!app.go\n"), 0644)
+	return fs
+}
+
+func TestFilterFsGitignore(t *testing.T) {
+	ffs := NewFilterFs(newTestFilterSource(t), FilterOptions{
+		Patterns:   []string{"*.log", "build/", "/vendor", "!logs/a.log", "**/tmp"},
+		IgnoreFile: ".gitignore",
+	})
+
+	for dir, want := range map[string]string{
+		"/":     "docs logs main.go src",
+		"/docs": "build", // a file, build/ only matches directories
+		"/logs": "a.log keep.txt",
+		"/src":  ".gitignore app.go",
+	} {
+		if got := listNames(t, ffs, dir); got != want {
+			t.Errorf("%s: got %q, want %q", dir, got, want)
+		}
+	}
+	for _, name := range []string{"/debug.log", "/build", "/build/out.bin", "/vendor/lib/lib.go", "/src/gen/x.go", "/src/tmp", "/src/other.go"} {
+		if _, err := ffs.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s: expected not exist, got %v", name, err)
+		}
+		if _, err := ffs.Open(name); !os.IsNotExist(err) {
+			t.Errorf("%s: expected not exist, got %v", name, err)
+		}
+	}
+	if err := WriteFile(ffs, "/new.log", nil, 0644); !os.IsNotExist(err) {
+		t.Errorf("created an excluded file: %v", err)
+	}
+
+	// the ignore files changed through the FilterFs are read again
+	if err := WriteFile(ffs, "/src/.gitignore", []byte("*.go\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := listNames(t, ffs, "/src"); got != ".gitignore gen" {
+		t.Errorf("/src: got %q", got)
+	}
+}
+
+func TestFilterFsSymlinks(t *testing.T) {
+	fs := &MemMapFs{}
+	WriteFile(fs, "/secret", []byte("secret"), 0600)
+	WriteFile(fs, "/private/key", []byte("key"), 0600)
+	WriteFile(fs, "/public", []byte("public"), 0644)
+	fs.SymlinkIfPossible("secret", "/visible")
+	fs.SymlinkIfPossible("/private", "/docs")
+	fs.SymlinkIfPossible("docs/../missing", "/dangling")
+	fs.SymlinkIfPossible("public", "/ok")
+	ffs := NewFilterFs(fs, FilterOptions{Patterns: []string{"secret", "private", "missing"}})
+
+	for _, name := range []string{"/visible", "/docs/key", "/dangling"} {
+		if _, err := ffs.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("stat %s: expected not exist, got %v", name, err)
+		}
+		if _, err := ffs.Open(name); !os.IsNotExist(err) {
+			t.Errorf("open %s: expected not exist, got %v", name, err)
+		}
+	}
+	if err := WriteFile(ffs, "/dangling", nil, 0644); !os.IsNotExist(err) {
+		t.Errorf("created a hidden file through a link: %v", err)
+	}
+	if ok, _ := Exists(fs, "/missing"); ok {
+		t.Error("/missing was created")
+	}
+
+	// the links themselves are not hidden
+	lstater := ffs.(Lstater)
+	if _, _, err := lstater.LstatIfPossible("/visible"); err != nil {
+		t.Errorf("lstat of the link: %v", err)
+	}
+	if data, err := ReadFile(ffs, "/ok"); err != nil || string(data) != "public" {
+		t.Errorf("read through link: %q, %v", data, err)
+	}
+}
+
+func TestFilterFsReaddirPaging(t *testing.T) {
+	fs := NewMemMapFs()
+	for _, name := range []string{"/a", "/b.log", "/c.log", "/d", "/e.log"} {
+		WriteFile(fs, name, nil, 0644)
+	}
+	ffs := NewFilterFs(fs, FilterOptions{Patterns: []string{"*.log"}})
+	f, err := ffs.Open("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var names []string
+	for {
+		fis, err := f.Readdir(1)
+		if err != nil {
+			break
+		}
+		if len(fis) != 1 {
+			t.Fatalf("got %d entries", len(fis))
+		}
+		names = append(names, fis[0].Name())
+	}
+	if len(names) != 2 {
+		t.Errorf("got %v", names)
+	}
+}
+
+func TestFilterFsDockerignore(t *testing.T) {
+	fs := newTestFilterSource(t)
+	WriteFile(fs, "/.dockerignore", []byte("*.log\nlogs\n!logs/keep.txt\nsrc/**/*.go\n!src/app.go\nbuild/\n"), 0644)
+	ffs := NewFilterFs(fs, FilterOptions{IgnoreFile: ".dockerignore", Dockerignore: true})
+
+	for dir, want := range map[string]string{
+		"/":        ".dockerignore docs logs main.go src vendor",
+		"/docs":    "build", // the patterns are relative to the root
+		"/logs":    "keep.txt",
+		"/src":     ".gitignore app.go gen tmp",
+		"/src/gen": "",
+	} {
+		if got := listNames(t, ffs, dir); got != want {
+			t.Errorf("%s: got %q, want %q", dir, got, want)
+		}
+	}
+}
+
+func TestFilterRuleMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		isDir, want   bool
+	}{
+		{"*.go", "a/b/c.go", false, true},
+		{"/*.go", "a/c.go", false, false},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"a/**", "a", true, false},
+		{"a/**", "a/x/y", false, true},
+		{"**/foo", "x/foo", false, true},
+		{"foo/", "x/foo", false, false},
+		{"foo/", "x/foo", true, true},
+		{"doc/*.txt", "doc/x/y.txt", false, false},
+		{"\\#file", "#file", false, true},
+		{"trailing\\ ", "trailing ", false, true},
+		{"[ab]?", "bz", false, true},
+	} {
+		rule, ok := parseFilterRule(tc.pattern, nil, false)
+		if !ok {
+			t.Fatalf("%s: not parsed", tc.pattern)
+		}
+		if got := rule.matches(splitPath(tc.name), tc.isDir); got != tc.want {
+			t.Errorf("%s on %s: got %v", tc.pattern, tc.name, got)
+		}
+	}
+}
@@ -1,3 +1,8 @@
 module github.com/spf13/afero
 
-require golang.org/x/text v0.3.0
+require (
+	github.com/pkg/sftp v1.10.1
+	golang.org/x/text v0.3.0
+)
+
+go 1.13
@@ -0,0 +1,264 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.16
+// +build go1.16
+
+package afero
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"testing/fstest"
+)
+
+func TestIOFS(t *testing.T) {
+	t.Run("MemMapFs", func(t *testing.T) {
+		mmfs := NewMemMapFs()
+
+		err := mmfs.MkdirAll("dir1/dir2", os.ModePerm)
+		if err != nil {
+			t.Fatal("MkdirAll failed:", err)
+		}
+
+		f, err := mmfs.OpenFile("dir1/dir2/test.txt", os.O_RDWR|os.O_CREATE, os.ModePerm)
+		if err != nil {
+			t.Fatal("OpenFile (O_CREATE) failed:", err)
+		}
+
+		f.Close()
+
+		if err := fstest.TestFS(NewIOFS(mmfs), "dir1/dir2/test.txt"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("BasePathFs(MemMapFs)", func(t *testing.T) {
+		mmfs := NewMemMapFs()
+		WriteFile(mmfs, "/dir1/dir2/test.txt", []byte("content"), 0644)
+		WriteFile(mmfs, "/dir1/other.txt", []byte("other content"), 0644)
+
+		if err := fstest.TestFS(NewIOFS(NewBasePathFs(mmfs, "/")), "dir1/dir2/test.txt", "dir1/other.txt"); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("OsFs", func(t *testing.T) {
+		osfs := NewOsFs()
+		tmp, err := TempDir(osfs, "", "afero-iofs")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer osfs.RemoveAll(tmp)
+
+		osfs.MkdirAll(filepath.Join(tmp, "dir1", "dir2"), 0755)
+		WriteFile(osfs, filepath.Join(tmp, "dir1", "dir2", "test.txt"), []byte("content"), 0644)
+
+		if err := fstest.TestFS(NewIOFS(NewBasePathFs(osfs, tmp)), "dir1/dir2/test.txt"); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestIOFSErrors(t *testing.T) {
+	mmfs := NewMemMapFs()
+	WriteFile(mmfs, "/base/file.txt", []byte("content"), 0644)
+	iofs := NewIOFS(NewBasePathFs(mmfs, "/base"))
+
+	checkErr := func(op string, err error, target error, path string) {
+		t.Helper()
+		var perr *fs.PathError
+		if !errors.As(err, &perr) {
+			t.Fatalf("%s: expected *fs.PathError, got %T: %v", op, err, err)
+		}
+		if perr.Path != path {
+			t.Errorf("%s: expected path %q, got %q", op, path, perr.Path)
+		}
+		if !errors.Is(err, target) {
+			t.Errorf("%s: expected %v, got %v", op, target, err)
+		}
+	}
+
+	_, err := iofs.Open("missing.txt")
+	checkErr("Open", err, fs.ErrNotExist, "missing.txt")
+
+	_, err = iofs.Stat("missing.txt")
+	checkErr("Stat", err, fs.ErrNotExist, "missing.txt")
+
+	_, err = iofs.ReadFile("missing.txt")
+	checkErr("ReadFile", err, fs.ErrNotExist, "missing.txt")
+
+	_, err = iofs.ReadDir("missing")
+	checkErr("ReadDir", err, fs.ErrNotExist, "missing")
+
+	_, err = iofs.Open("/file.txt")
+	checkErr("Open", err, fs.ErrInvalid, "/file.txt")
+
+	_, err = iofs.Open("../file.txt")
+	checkErr("Open", err, fs.ErrInvalid, "../file.txt")
+
+	_, err = iofs.Glob("[")
+	checkErr("Glob", err, path.ErrBadPattern, "[")
+}
+
+func TestFromIOFS(t *testing.T) {
+	fsys := NewFromIOFS(fstest.MapFS{
+		"test.txt":               {Data: []byte("File in root")},
+		"dir1/dir2/hello.txt":    {Data: []byte("Hello world!")},
+		"dir1/dir2/goodbye.txt":  {Data: []byte("Goodbye world!")},
+		"dir1/dir3/readme.md":    {Data: []byte("Readme")},
+		"dir1/dir3/subdir/x.txt": {Data: []byte("x")},
+	})
+
+	t.Run("ReadFile", func(t *testing.T) {
+		for _, name := range []string{"dir1/dir2/hello.txt", "/dir1/dir2/hello.txt", "./dir1/dir2/../dir2/hello.txt"} {
+			content, err := ReadFile(fsys, name)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if string(content) != "Hello world!" {
+				t.Errorf("%s: read %q", name, content)
+			}
+		}
+	})
+
+	t.Run("File", func(t *testing.T) {
+		file, err := fsys.Open("/dir1/dir2/hello.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+
+		if file.Name() != "/dir1/dir2/hello.txt" {
+			t.Errorf("unexpected name %s", file.Name())
+		}
+
+		buf := make([]byte, 5)
+		if _, err := file.ReadAt(buf, 6); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != "world" {
+			t.Errorf("ReadAt read %q", buf)
+		}
+
+		if _, err := file.Seek(-6, io.SeekEnd); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.ReadFull(file, buf); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != "world" {
+			t.Errorf("Read after Seek read %q", buf)
+		}
+
+		if _, err := file.Write([]byte("x")); !errors.Is(err, syscall.EPERM) {
+			t.Errorf("expected EPERM writing, got %v", err)
+		}
+	})
+
+	t.Run("Readdir", func(t *testing.T) {
+		names, err := readDirNames(fsys, "/dir1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != 2 || names[0] != "dir2" || names[1] != "dir3" {
+			t.Errorf("unexpected listing %v", names)
+		}
+
+		file, err := fsys.Open("/dir1/dir2")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		fis, err := file.Readdir(1)
+		if err != nil || len(fis) != 1 {
+			t.Fatalf("expected 1 entry, got %d: %v", len(fis), err)
+		}
+		fis, err = file.Readdir(5)
+		if err != nil || len(fis) != 1 {
+			t.Fatalf("expected 1 entry, got %d: %v", len(fis), err)
+		}
+		if _, err := file.Readdir(5); err != io.EOF {
+			t.Errorf("expected io.EOF, got %v", err)
+		}
+	})
+
+	t.Run("Walk", func(t *testing.T) {
+		var files []string
+		err := Walk(fsys, "/dir1", func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() {
+				files = append(files, filepath.ToSlash(path))
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := []string{"/dir1/dir2/goodbye.txt", "/dir1/dir2/hello.txt", "/dir1/dir3/readme.md", "/dir1/dir3/subdir/x.txt"}
+		if len(files) != len(expected) {
+			t.Fatalf("expected %v, got %v", expected, files)
+		}
+		for i := range expected {
+			if files[i] != expected[i] {
+				t.Errorf("expected %v, got %v", expected, files)
+			}
+		}
+	})
+
+	t.Run("ReadOnly", func(t *testing.T) {
+		checkEPERM := func(op string, err error) {
+			t.Helper()
+			if _, ok := err.(*os.PathError); !ok || !errors.Is(err, syscall.EPERM) {
+				t.Errorf("%s: expected EPERM in a *os.PathError, got %v", op, err)
+			}
+		}
+		_, err := fsys.Create("/new.txt")
+		checkEPERM("Create", err)
+		_, err = fsys.OpenFile("/test.txt", os.O_RDWR, 0)
+		checkEPERM("OpenFile", err)
+		checkEPERM("Mkdir", fsys.Mkdir("/dir", 0755))
+		checkEPERM("Remove", fsys.Remove("/test.txt"))
+		checkEPERM("Rename", fsys.Rename("/test.txt", "/x.txt"))
+		checkEPERM("Chmod", fsys.Chmod("/test.txt", 0644))
+
+		if _, err := fsys.OpenFile("/test.txt", os.O_RDONLY, 0); err != nil {
+			t.Errorf("OpenFile read only: %v", err)
+		}
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		_, err := fsys.Stat("/missing.txt")
+		perr, ok := err.(*os.PathError)
+		if !ok || !os.IsNotExist(err) {
+			t.Fatalf("expected a not exist *os.PathError, got %v", err)
+		}
+		if perr.Path != "/missing.txt" {
+			t.Errorf("expected the afero path, got %s", perr.Path)
+		}
+	})
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		if err := fstest.TestFS(NewIOFS(NewBasePathFs(fsys, "/")), "test.txt", "dir1/dir3/subdir/x.txt"); err != nil {
+			t.Error(err)
+		}
+	})
+}
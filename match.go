@@ -15,6 +15,7 @@
 package afero
 
 import (
+	"os"
 	"path/filepath"
 	"sort"
 	"strings"
@@ -108,3 +109,195 @@ func hasMeta(path string) bool {
 	// TODO(niemeyer): Should other magic characters be added here?
 	return strings.IndexAny(path, "*?[") >= 0
 }
+
+// GlobOptions configures GlobWithOptions.
+type GlobOptions struct {
+	// CaseInsensitive matches the names regardless of their case.
+	CaseInsensitive bool
+}
+
+// GlobWithOptions returns the sorted names of all files matching pattern,
+// or nil if there is none, like Glob, with two more kinds of patterns:
+//
+//	**      as a whole path component, matches any number of directories,
+//	        including none, so a/**/b matches a/b and a/x/y/b, and at the
+//	        end everything below, so a/** matches a and all files in it
+//	{a,b}   matches any of the comma separated alternatives, which are
+//	        patterns themselves and may contain braces and separators
+//
+// Only the directories which may hold matches are read. A ** does not
+// descend into the symbolic links to directories.
+//
+// GlobWithOptions ignores file system errors such as I/O errors reading
+// directories. The only possible returned error is ErrBadPattern, when
+// pattern is malformed.
+func GlobWithOptions(fs Fs, pattern string, opts GlobOptions) ([]string, error) {
+	patterns, err := expandBraces(pattern)
+	if err != nil {
+		return nil, err
+	}
+	g := &globber{fs: fs, opts: opts, dirs: make(map[string][]os.FileInfo), seen: make(map[string]bool)}
+	for _, p := range patterns {
+		root, components := splitGlob(p)
+		for _, c := range components {
+			if _, err := filepath.Match(c, ""); err != nil {
+				return nil, err
+			}
+		}
+		g.walk(root, components)
+	}
+	sort.Strings(g.matches)
+	return g.matches, nil
+}
+
+// globber is the state of a GlobWithOptions.
+type globber struct {
+	fs      Fs
+	opts    GlobOptions
+	dirs    map[string][]os.FileInfo // the directories read
+	seen    map[string]bool
+	matches []string
+}
+
+// splitGlob returns the directory pattern starts in and its components.
+func splitGlob(pattern string) (string, []string) {
+	root := ""
+	if filepath.IsAbs(pattern) {
+		root = filepath.VolumeName(pattern) + string(filepath.Separator)
+		pattern = pattern[len(root):]
+	}
+	var components []string
+	for _, c := range strings.Split(pattern, string(filepath.Separator)) {
+		// a sequence of ** is the same as one
+		if c == "" || c == "." || (c == "**" && len(components) > 0 && components[len(components)-1] == "**") {
+			continue
+		}
+		components = append(components, c)
+	}
+	return root, components
+}
+
+// walk adds the matches of components in the directory dir, "" for the
+// current directory.
+func (g *globber) walk(dir string, components []string) {
+	if len(components) == 0 {
+		if dir != "" && !g.seen[dir] {
+			g.seen[dir] = true
+			g.matches = append(g.matches, dir)
+		}
+		return
+	}
+
+	c := components[0]
+	switch {
+	case c == "**":
+		g.walk(dir, components[1:])
+		for _, fi := range g.readDir(dir) {
+			if fi.IsDir() {
+				g.walk(g.join(dir, fi.Name()), components)
+			} else if len(components) == 1 {
+				// a trailing ** matches the files too
+				g.walk(g.join(dir, fi.Name()), nil)
+			}
+		}
+	case !hasMeta(c) && !g.opts.CaseInsensitive:
+		name := g.join(dir, c)
+		if _, err := lstatIfPossible(g.fs, name); err == nil {
+			g.walk(name, components[1:])
+		}
+	default:
+		if g.opts.CaseInsensitive {
+			c = strings.ToLower(c)
+		}
+		for _, fi := range g.readDir(dir) {
+			n := fi.Name()
+			if g.opts.CaseInsensitive {
+				n = strings.ToLower(n)
+			}
+			if ok, _ := filepath.Match(c, n); ok {
+				g.walk(g.join(dir, fi.Name()), components[1:])
+			}
+		}
+	}
+}
+
+func (g *globber) join(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return filepath.Join(dir, name)
+}
+
+// readDir returns the entries of dir, sorted, or none if it cannot be read.
+func (g *globber) readDir(dir string) []os.FileInfo {
+	if fis, ok := g.dirs[dir]; ok {
+		return fis
+	}
+	name := dir
+	if name == "" {
+		name = "."
+	}
+	var fis []os.FileInfo
+	if f, err := g.fs.Open(name); err == nil {
+		fis, _ = f.Readdir(-1)
+		f.Close()
+		sort.Slice(fis, func(i, j int) bool { return fis[i].Name() < fis[j].Name() })
+	}
+	g.dirs[dir] = fis
+	return fis
+}
+
+// expandBraces returns the patterns of the alternatives of the braces in
+// pattern.
+func expandBraces(pattern string) ([]string, error) {
+	start, end := -1, -1
+	depth := 0
+	var commas []int
+	for i := 0; i < len(pattern) && end < 0; i++ {
+		switch pattern[i] {
+		case '\\':
+			if filepath.Separator != '\\' {
+				i++
+			}
+		case '[':
+			// a brace in a character class is not one
+			if j := strings.IndexByte(pattern[i+1:], ']'); j >= 0 {
+				i += j + 1
+			}
+		case '{':
+			if depth == 0 {
+				start = i
+			}
+			depth++
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		case '}':
+			if depth == 0 {
+				continue
+			}
+			if depth--; depth == 0 {
+				end = i
+			}
+		}
+	}
+	if start < 0 {
+		return []string{pattern}, nil
+	}
+	if end < 0 {
+		return nil, filepath.ErrBadPattern
+	}
+
+	var patterns []string
+	from := start + 1
+	for _, to := range append(commas, end) {
+		expanded, err := expandBraces(pattern[:start] + pattern[from:to] + pattern[end+1:])
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, expanded...)
+		from = to + 1
+	}
+	return patterns, nil
+}
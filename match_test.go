@@ -18,6 +18,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -181,3 +182,73 @@ func TestGlobError(t *testing.T) {
 		}
 	}
 }
+
+func TestGlobWithOptions(t *testing.T) {
+	fs := NewMemMapFs()
+	for _, name := range []string{
+		"/src/main.go", "/src/Util.GO", "/src/a/b/deep.go", "/src/a/b/deep.txt",
+		"/docs/readme.md", "/docs/api/index.md", "/test/x_test.go",
+	} {
+		if err := WriteFile(fs, name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tt := range []struct {
+		pattern string
+		opts    GlobOptions
+		want    string
+	}{
+		{"/**/*.go", GlobOptions{}, "/src/a/b/deep.go /src/main.go /test/x_test.go"},
+		{"/src/**/*.go", GlobOptions{CaseInsensitive: true}, "/src/Util.GO /src/a/b/deep.go /src/main.go"},
+		{"/src/**/deep.*", GlobOptions{}, "/src/a/b/deep.go /src/a/b/deep.txt"},
+		{"/src/**", GlobOptions{}, "/src /src/Util.GO /src/a /src/a/b /src/a/b/deep.go /src/a/b/deep.txt /src/main.go"},
+		{"/{src,test}/*.go", GlobOptions{}, "/src/main.go /test/x_test.go"},
+		{"/{docs/**/*.md,src/{main,missing}.go}", GlobOptions{}, "/docs/api/index.md /docs/readme.md /src/main.go"},
+		{"/SRC/MAIN.go", GlobOptions{CaseInsensitive: true}, "/src/main.go"},
+		{"/**/**/index.md", GlobOptions{}, "/docs/api/index.md"},
+		{"/nothing/**", GlobOptions{}, ""},
+	} {
+		pattern := filepath.FromSlash(tt.pattern)
+		matches, err := GlobWithOptions(fs, pattern, tt.opts)
+		if err != nil {
+			t.Errorf("%s: %v", tt.pattern, err)
+			continue
+		}
+		if got := filepath.ToSlash(strings.Join(matches, " ")); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.pattern, got, tt.want)
+		}
+	}
+
+	for _, pattern := range []string{"/{src", "/src/[a"} {
+		if _, err := GlobWithOptions(fs, pattern, GlobOptions{}); err != filepath.ErrBadPattern {
+			t.Errorf("%s: expected ErrBadPattern, got %v", pattern, err)
+		}
+	}
+}
+
+// openLogFs records the names opened.
+type openLogFs struct {
+	Fs
+	opened []string
+}
+
+func (fs *openLogFs) Open(name string) (File, error) {
+	fs.opened = append(fs.opened, name)
+	return fs.Fs.Open(name)
+}
+
+func TestGlobWithOptionsPrunes(t *testing.T) {
+	fs := &openLogFs{Fs: NewMemMapFs()}
+	WriteFile(fs, "/a/x/file.go", nil, 0644)
+	WriteFile(fs, "/b/y/file.go", nil, 0644)
+	matches, err := GlobWithOptions(fs, filepath.FromSlash("/a/**/*.go"), GlobOptions{})
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("got %v, %v", matches, err)
+	}
+	for _, name := range fs.opened {
+		if !strings.HasPrefix(filepath.ToSlash(name), "/a") {
+			t.Errorf("read %s", name)
+		}
+	}
+}
@@ -34,7 +34,11 @@ type File struct {
 	readDirCount int64
 	closed       bool
 	readOnly     bool
+	written      bool
+	closeHook    func(written bool)
 	fileData     *FileData
+	// name is the name f was opened by, if not the one of fileData
+	name string
 }
 
 func NewFileHandle(data *FileData) *File {
@@ -55,8 +59,11 @@ type FileData struct {
 	data    []byte
 	memDir  Dir
 	dir     bool
+	link    string
 	mode    os.FileMode
 	modtime time.Time
+	uid     int
+	gid     int
 }
 
 func (d *FileData) Name() string {
@@ -70,7 +77,19 @@ func CreateFile(name string) *FileData {
 }
 
 func CreateDir(name string) *FileData {
-	return &FileData{name: name, memDir: &DirMap{}, dir: true}
+	return &FileData{name: name, memDir: &DirMap{}, dir: true, mode: os.ModeDir}
+}
+
+// CreateSymlink returns a symbolic link named name which points to target.
+func CreateSymlink(name, target string) *FileData {
+	return &FileData{name: name, link: target, mode: os.ModeSymlink | 0777, modtime: time.Now()}
+}
+
+// GetLinkTarget returns the target of f and whether f is a symbolic link.
+func GetLinkTarget(f *FileData) (string, bool) {
+	f.Lock()
+	defer f.Unlock()
+	return f.link, f.link != ""
 }
 
 func ChangeFileName(f *FileData, newname string) {
@@ -85,6 +104,18 @@ func SetMode(f *FileData, mode os.FileMode) {
 	f.Unlock()
 }
 
+func SetUID(f *FileData, uid int) {
+	f.Lock()
+	f.uid = uid
+	f.Unlock()
+}
+
+func SetGID(f *FileData, gid int) {
+	f.Lock()
+	f.gid = gid
+	f.Unlock()
+}
+
 func SetModTime(f *FileData, mtime time.Time) {
 	f.Lock()
 	setModTime(f, mtime)
@@ -108,21 +139,44 @@ func (f *File) Open() error {
 	return nil
 }
 
+// SetCloseHook sets a function which is called when f is closed, with
+// whether f was written to or truncated.
+func (f *File) SetCloseHook(fn func(written bool)) {
+	f.closeHook = fn
+}
+
 func (f *File) Close() error {
 	f.fileData.Lock()
+	wasClosed := f.closed
 	f.closed = true
 	if !f.readOnly {
 		setModTime(f.fileData, time.Now())
 	}
+	written := f.written
 	f.fileData.Unlock()
+	if f.closeHook != nil && !wasClosed {
+		f.closeHook(written)
+	}
 	return nil
 }
 
+// SetName makes f report name, the name it was opened by, instead of the
+// name of its data, e.g. when it was opened through a symbolic link.
+func (f *File) SetName(name string) {
+	f.name = name
+}
+
 func (f *File) Name() string {
+	if f.name != "" {
+		return f.name
+	}
 	return f.fileData.Name()
 }
 
 func (f *File) Stat() (os.FileInfo, error) {
+	if f.name != "" {
+		return namedFileInfo{&FileInfo{f.fileData}, filepath.Base(f.name)}, nil
+	}
 	return &FileInfo{f.fileData}, nil
 }
 
@@ -176,11 +230,11 @@ func (f *File) Read(b []byte) (n int, err error) {
 	if f.closed == true {
 		return 0, ErrFileClosed
 	}
-	if len(b) > 0 && int(f.at) == len(f.fileData.data) {
-		return 0, io.EOF
-	}
-	if int(f.at) > len(f.fileData.data) {
-		return 0, io.ErrUnexpectedEOF
+	if int(f.at) >= len(f.fileData.data) {
+		if len(b) > 0 {
+			return 0, io.EOF
+		}
+		return 0, nil
 	}
 	if len(f.fileData.data)-int(f.at) >= len(b) {
 		n = len(b)
@@ -193,8 +247,15 @@ func (f *File) Read(b []byte) (n int, err error) {
 }
 
 func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
+	prev := atomic.LoadInt64(&f.at)
 	atomic.StoreInt64(&f.at, off)
-	return f.Read(b)
+	n, err = f.Read(b)
+	atomic.StoreInt64(&f.at, prev)
+	if err == nil && n < len(b) {
+		// io.ReaderAt reports short reads as errors
+		err = io.EOF
+	}
+	return
 }
 
 func (f *File) Truncate(size int64) error {
@@ -214,6 +275,7 @@ func (f *File) Truncate(size int64) error {
 		f.fileData.data = f.fileData.data[0:size]
 	}
 	setModTime(f.fileData, time.Now())
+	f.written = true
 	return nil
 }
 
@@ -246,13 +308,14 @@ func (f *File) Write(b []byte) (n int, err error) {
 		tail = f.fileData.data[n+int(cur):]
 	}
 	if diff > 0 {
-		f.fileData.data = append(bytes.Repeat([]byte{00}, int(diff)), b...)
-		f.fileData.data = append(f.fileData.data, tail...)
+		f.fileData.data = append(f.fileData.data, bytes.Repeat([]byte{00}, int(diff))...)
+		f.fileData.data = append(f.fileData.data, b...)
 	} else {
 		f.fileData.data = append(f.fileData.data[:cur], b...)
 		f.fileData.data = append(f.fileData.data, tail...)
 	}
 	setModTime(f.fileData, time.Now())
+	f.written = true
 
 	atomic.StoreInt64(&f.at, int64(len(f.fileData.data)))
 	return
@@ -275,6 +338,14 @@ type FileInfo struct {
 	*FileData
 }
 
+// namedFileInfo is the FileInfo of a file opened by another name.
+type namedFileInfo struct {
+	*FileInfo
+	name string
+}
+
+func (s namedFileInfo) Name() string { return s.name }
+
 // Implements os.FileInfo
 func (s *FileInfo) Name() string {
 	s.Lock()
@@ -297,13 +368,28 @@ func (s *FileInfo) IsDir() bool {
 	defer s.Unlock()
 	return s.dir
 }
-func (s *FileInfo) Sys() interface{} { return nil }
+
+// Stat_t is what FileInfo.Sys() returns. Its fields mirror the ones of
+// syscall.Stat_t on unix systems.
+type Stat_t struct {
+	Uid uint32
+	Gid uint32
+}
+
+func (s *FileInfo) Sys() interface{} {
+	s.Lock()
+	defer s.Unlock()
+	return &Stat_t{Uid: uint32(s.uid), Gid: uint32(s.gid)}
+}
 func (s *FileInfo) Size() int64 {
 	if s.IsDir() {
 		return int64(42)
 	}
 	s.Lock()
 	defer s.Unlock()
+	if s.link != "" {
+		return int64(len(s.link))
+	}
 	return int64(len(s.data))
 }
 
@@ -1,6 +1,7 @@
 package mem
 
 import (
+	"io"
 	"testing"
 	"time"
 )
@@ -152,3 +153,26 @@ func TestFileDataSizeRace(t *testing.T) {
 		t.Errorf("Failed to read correct value for dir, was %v", s.Size())
 	}
 }
+
+func TestFileReadAtOffset(t *testing.T) {
+	f := NewFileHandle(CreateFile("abc"))
+	f.WriteString("hello, world")
+	f.Seek(2, 0)
+
+	b := make([]byte, 10)
+	n, err := f.ReadAt(b, 7)
+	if n != 5 || err != io.EOF {
+		t.Errorf("ReadAt past the end = %d, %v, want 5, io.EOF", n, err)
+	}
+	if string(b[:n]) != "world" {
+		t.Errorf("ReadAt read %q", b[:n])
+	}
+
+	b = make([]byte, 3)
+	if _, err := f.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "llo" {
+		t.Errorf("ReadAt moved the offset, read %q", b)
+	}
+}
@@ -18,17 +18,39 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/spf13/afero/mem"
 )
 
+var _ Symlinker = (*MemMapFs)(nil)
+var _ Chowner = (*MemMapFs)(nil)
+var _ Watcher = (*MemMapFs)(nil)
+
+// maxSymlinkHops is the number of symbolic links MemMapFs follows while
+// resolving a single path before giving up with ELOOP, like Linux does.
+const maxSymlinkHops = 40
+
 type MemMapFs struct {
 	mu   sync.RWMutex
 	data map[string]*mem.FileData
 	init sync.Once
+
+	watchMu sync.Mutex
+	watches []*memWatch
+
+	// perms is set by EnforcePermissions
+	perms *memPerms
+}
+
+// memPerms are the credentials permissions are checked against.
+type memPerms struct {
+	uid, gid int
+	umask    os.FileMode
 }
 
 func NewMemMapFs() Fs {
@@ -40,7 +62,9 @@ func (m *MemMapFs) getData() map[string]*mem.FileData {
 		m.data = make(map[string]*mem.FileData)
 		// Root should always exist, right?
 		// TODO: what about windows?
-		m.data[FilePathSeparator] = mem.CreateDir(FilePathSeparator)
+		root := mem.CreateDir(FilePathSeparator)
+		mem.SetMode(root, os.ModeDir|0755)
+		m.data[FilePathSeparator] = root
 	})
 	return m.data
 }
@@ -48,13 +72,64 @@ func (m *MemMapFs) getData() map[string]*mem.FileData {
 func (*MemMapFs) Name() string { return "MemMapFS" }
 
 func (m *MemMapFs) Create(name string) (File, error) {
-	name = normalizePath(name)
+	opened := name
 	m.mu.Lock()
+	name, err := m.lockfreeResolve(name, true)
+	if err != nil {
+		m.mu.Unlock()
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	prev, existed := m.getData()[name]
+	if existed {
+		err = m.lockfreeCheckAccess(name, prev, permWrite)
+	} else {
+		err = m.lockfreeCheckPath(name, permWrite|permExec)
+	}
+	if err != nil {
+		m.mu.Unlock()
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
 	file := mem.CreateFile(name)
+	if m.perms != nil {
+		if existed {
+			mode, uid, gid := fileMode(prev)
+			mem.SetMode(file, mode)
+			mem.SetUID(file, uid)
+			mem.SetGID(file, gid)
+		} else {
+			m.lockfreeSetOwner(file, 0666)
+		}
+	}
 	m.getData()[name] = file
 	m.registerWithParent(file)
 	m.mu.Unlock()
-	return mem.NewFileHandle(file), nil
+	if existed {
+		m.notify(name, WatchWrite)
+	} else {
+		m.notify(name, WatchCreate)
+	}
+	return openedAs(m.newFileHandle(file), opened), nil
+}
+
+// openedAs makes h report the name it was opened by, if it is not the name
+// of its data, i.e. it was opened through a symbolic link.
+func openedAs(h *mem.File, name string) *mem.File {
+	if name = normalizePath(name); name != h.Data().Name() {
+		h.SetName(name)
+	}
+	return h
+}
+
+// newFileHandle returns a writable handle of f which reports a WatchWrite
+// when it is closed after it was written to.
+func (m *MemMapFs) newFileHandle(f *mem.FileData) *mem.File {
+	h := mem.NewFileHandle(f)
+	h.SetCloseHook(func(written bool) {
+		if written {
+			m.notify(f.Name(), WatchWrite)
+		}
+	})
+	return h
 }
 
 func (m *MemMapFs) unRegisterWithParent(fileName string) error {
@@ -119,29 +194,46 @@ func (m *MemMapFs) lockfreeMkdir(name string, perm os.FileMode) error {
 		}
 	} else {
 		item := mem.CreateDir(name)
+		mem.SetMode(item, os.ModeDir|perm)
+		if m.perms != nil {
+			m.lockfreeSetOwner(item, perm)
+		}
 		m.getData()[name] = item
 		m.registerWithParent(item)
+		m.notify(name, WatchCreate)
 	}
 	return nil
 }
 
 func (m *MemMapFs) Mkdir(name string, perm os.FileMode) error {
-	name = normalizePath(name)
-
 	m.mu.RLock()
+	name, err := m.lockfreeResolve(name, false)
+	if err != nil {
+		m.mu.RUnlock()
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
 	_, ok := m.getData()[name]
+	if !ok {
+		err = m.lockfreeCheckPath(name, permWrite|permExec)
+	}
 	m.mu.RUnlock()
 	if ok {
 		return &os.PathError{Op: "mkdir", Path: name, Err: ErrFileExists}
 	}
+	if err != nil {
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
+	}
 
 	m.mu.Lock()
 	item := mem.CreateDir(name)
+	mem.SetMode(item, os.ModeDir|perm&^os.ModeType)
+	if m.perms != nil {
+		m.lockfreeSetOwner(item, perm)
+	}
 	m.getData()[name] = item
 	m.registerWithParent(item)
 	m.mu.Unlock()
-
-	m.Chmod(name, perm|os.ModeDir)
+	m.notify(name, WatchCreate)
 
 	return nil
 }
@@ -150,6 +242,9 @@ func (m *MemMapFs) MkdirAll(path string, perm os.FileMode) error {
 	err := m.Mkdir(path, perm)
 	if err != nil {
 		if err.(*os.PathError).Err == ErrFileExists {
+			if fi, serr := m.Stat(path); serr == nil && !fi.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: path, Err: syscall.ENOTDIR}
+			}
 			return nil
 		}
 		return err
@@ -173,24 +268,34 @@ func normalizePath(path string) string {
 
 func (m *MemMapFs) Open(name string) (File, error) {
 	f, err := m.open(name)
-	if f != nil {
-		return mem.NewReadOnlyFileHandle(f), err
+	if err == nil {
+		err = m.checkAccess("open", name, f, permRead)
 	}
-	return nil, err
+	if err != nil {
+		return nil, err
+	}
+	return openedAs(mem.NewReadOnlyFileHandle(f), name), nil
 }
 
 func (m *MemMapFs) openWrite(name string) (File, error) {
 	f, err := m.open(name)
 	if f != nil {
-		return mem.NewFileHandle(f), err
+		return m.newFileHandle(f), err
 	}
 	return nil, err
 }
 
 func (m *MemMapFs) open(name string) (*mem.FileData, error) {
-	name = normalizePath(name)
-
 	m.mu.RLock()
+	name, err := m.lockfreeResolve(name, true)
+	if err != nil {
+		m.mu.RUnlock()
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	if err := m.lockfreeCheckPath(name, 0); err != nil {
+		m.mu.RUnlock()
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
 	f, ok := m.getData()[name]
 	m.mu.RUnlock()
 	if !ok {
@@ -209,9 +314,59 @@ func (m *MemMapFs) lockfreeOpen(name string) (*mem.FileData, error) {
 	}
 }
 
+// lockfreeResolve follows the symbolic links in name and returns the path of
+// the file it refers to. The last element of name is only followed if
+// followLast is set, which gives the Lstat semantics. Elements which do not
+// exist are kept as they are. The caller must hold m.mu.
+func (m *MemMapFs) lockfreeResolve(name string, followLast bool) (string, error) {
+	name = normalizePath(name)
+	for hops := 0; ; hops++ {
+		resolved, followed := m.lockfreeFollowLink(name, followLast)
+		if !followed {
+			return name, nil
+		}
+		if hops == maxSymlinkHops {
+			return name, syscall.ELOOP
+		}
+		name = resolved
+	}
+}
+
+// lockfreeFollowLink replaces the first symbolic link found in name by its
+// target and reports whether there was one to replace.
+func (m *MemMapFs) lockfreeFollowLink(name string, followLast bool) (string, bool) {
+	for i := 1; i <= len(name); i++ {
+		if i < len(name) && !os.IsPathSeparator(name[i]) {
+			continue
+		}
+		if i == len(name) && !followLast {
+			break
+		}
+		f, ok := m.getData()[name[:i]]
+		if !ok {
+			continue
+		}
+		target, isLink := mem.GetLinkTarget(f)
+		if !isLink {
+			continue
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(name[:i]), target)
+		}
+		return normalizePath(target + name[i:]), true
+	}
+	return name, false
+}
+
 func (m *MemMapFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
 	chmod := false
 	file, err := m.openWrite(name)
+	if err == nil && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		return nil, &os.PathError{Op: "open", Path: name, Err: ErrFileExists}
+	}
+	if err == nil {
+		err = m.checkAccess("open", name, file.(*mem.File).Data(), openPerm(flag))
+	}
 	if os.IsNotExist(err) && (flag&os.O_CREATE > 0) {
 		file, err = m.Create(name)
 		chmod = true
@@ -237,23 +392,38 @@ func (m *MemMapFs) OpenFile(name string, flag int, perm os.FileMode) (File, erro
 		}
 	}
 	if chmod {
-		m.Chmod(name, perm)
+		// set the mode directly, a new file is reported as created only
+		m.mu.RLock()
+		if m.perms != nil {
+			perm &^= m.perms.umask
+		}
+		m.mu.RUnlock()
+		mem.SetMode(file.(*mem.File).Data(), perm&^os.ModeType)
 	}
-	return file, nil
+	return openedAs(file.(*mem.File), name), nil
 }
 
 func (m *MemMapFs) Remove(name string) error {
-	name = normalizePath(name)
-
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	name, err := m.lockfreeResolve(name, false)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
 	if _, ok := m.getData()[name]; ok {
+		if err := m.lockfreeCheckRemove(name); err != nil {
+			return &os.PathError{Op: "remove", Path: name, Err: err}
+		}
+		if len(m.lockfreeDescendants(name)) > 0 {
+			return &os.PathError{Op: "remove", Path: name, Err: syscall.ENOTEMPTY}
+		}
 		err := m.unRegisterWithParent(name)
 		if err != nil {
 			return &os.PathError{Op: "remove", Path: name, Err: err}
 		}
 		delete(m.getData(), name)
+		m.notify(name, WatchRemove)
 	} else {
 		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
 	}
@@ -261,96 +431,523 @@ func (m *MemMapFs) Remove(name string) error {
 }
 
 func (m *MemMapFs) RemoveAll(path string) error {
-	path = normalizePath(path)
 	m.mu.Lock()
-	m.unRegisterWithParent(path)
-	m.mu.Unlock()
-
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	defer m.mu.Unlock()
 
-	for p, _ := range m.getData() {
-		if strings.HasPrefix(p, path) {
-			m.mu.RUnlock()
-			m.mu.Lock()
-			delete(m.getData(), p)
-			m.mu.Unlock()
-			m.mu.RLock()
+	path, err := m.lockfreeResolve(path, false)
+	if err != nil {
+		return &os.PathError{Op: "remove", Path: path, Err: err}
+	}
+	if _, ok := m.getData()[path]; !ok {
+		return nil
+	}
+	descendants := m.lockfreeDescendants(path)
+	// Unlike os.RemoveAll nothing is removed unless everything can be.
+	if err := m.lockfreeCheckRemove(path); err != nil {
+		return &os.PathError{Op: "remove", Path: path, Err: err}
+	}
+	for _, p := range descendants {
+		if err := m.lockfreeCheckRemove(p); err != nil {
+			return &os.PathError{Op: "remove", Path: p, Err: err}
 		}
 	}
+	m.unRegisterWithParent(path)
+	// report the entries of a directory before the directory itself
+	sort.Sort(sort.Reverse(sort.StringSlice(descendants)))
+	for _, p := range descendants {
+		delete(m.getData(), p)
+		m.notify(p, WatchRemove)
+	}
+	delete(m.getData(), path)
+	m.notify(path, WatchRemove)
 	return nil
 }
 
+// lockfreeDescendants returns the names of all files below the directory
+// name. The caller must hold m.mu.
+func (m *MemMapFs) lockfreeDescendants(name string) []string {
+	prefix := name
+	if !strings.HasSuffix(prefix, FilePathSeparator) {
+		prefix += FilePathSeparator
+	}
+	var names []string
+	for p := range m.getData() {
+		if p != prefix && strings.HasPrefix(p, prefix) {
+			names = append(names, p)
+		}
+	}
+	return names
+}
+
 func (m *MemMapFs) Rename(oldname, newname string) error {
-	oldname = normalizePath(oldname)
-	newname = normalizePath(newname)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	oldname, err := m.lockfreeResolve(oldname, false)
+	if err != nil {
+		return &os.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+	newname, err = m.lockfreeResolve(newname, false)
+	if err != nil {
+		return &os.PathError{Op: "rename", Path: newname, Err: err}
+	}
 
 	if oldname == newname {
 		return nil
 	}
 
-	m.mu.RLock()
-	defer m.mu.RUnlock()
-	if _, ok := m.getData()[oldname]; ok {
-		m.mu.RUnlock()
-		m.mu.Lock()
-		m.unRegisterWithParent(oldname)
-		fileData := m.getData()[oldname]
-		delete(m.getData(), oldname)
-		mem.ChangeFileName(fileData, newname)
-		m.getData()[newname] = fileData
-		m.registerWithParent(fileData)
-		m.mu.Unlock()
-		m.mu.RLock()
-	} else {
+	fileData, ok := m.getData()[oldname]
+	if !ok {
 		return &os.PathError{Op: "rename", Path: oldname, Err: ErrFileNotFound}
 	}
+	isDir := mem.GetFileInfo(fileData).IsDir()
+	if isDir && strings.HasPrefix(newname, oldname+FilePathSeparator) {
+		return &os.PathError{Op: "rename", Path: oldname, Err: syscall.EINVAL}
+	}
+	if err := m.lockfreeCheckRename(oldname, newname, fileData); err != nil {
+		return &os.PathError{Op: "rename", Path: oldname, Err: err}
+	}
+
+	// Like rename(2), replace an existing file or empty directory of the
+	// same kind.
+	if target, ok := m.getData()[newname]; ok {
+		targetIsDir := mem.GetFileInfo(target).IsDir()
+		switch {
+		case isDir && !targetIsDir:
+			return &os.PathError{Op: "rename", Path: newname, Err: syscall.ENOTDIR}
+		case !isDir && targetIsDir:
+			return &os.PathError{Op: "rename", Path: newname, Err: syscall.EISDIR}
+		case targetIsDir && len(m.lockfreeDescendants(newname)) > 0:
+			return &os.PathError{Op: "rename", Path: newname, Err: syscall.ENOTEMPTY}
+		}
+		m.unRegisterWithParent(newname)
+		delete(m.getData(), newname)
+		m.notify(newname, WatchRemove)
+	}
+
+	// Move the content of a directory along with it. The directories are
+	// keyed by the full names of their entries, so every entry is
+	// re-registered under its new name.
+	moved := make(map[string]*mem.FileData)
+	for _, p := range m.lockfreeDescendants(oldname) {
+		f := m.getData()[p]
+		parent := m.getData()[filepath.Dir(p)]
+		parent.Lock()
+		mem.RemoveFromMemDir(parent, f)
+		mem.ChangeFileName(f, newname+strings.TrimPrefix(p, oldname))
+		mem.AddToMemDir(parent, f)
+		parent.Unlock()
+		moved[p] = f
+	}
+	for p, f := range moved {
+		delete(m.getData(), p)
+		m.getData()[f.Name()] = f
+	}
+
+	m.unRegisterWithParent(oldname)
+	delete(m.getData(), oldname)
+	mem.ChangeFileName(fileData, newname)
+	m.getData()[newname] = fileData
+	m.registerWithParent(fileData)
+	m.notify(oldname, WatchRename)
+	m.notify(newname, WatchCreate)
 	return nil
 }
 
 func (m *MemMapFs) Stat(name string) (os.FileInfo, error) {
-	f, err := m.Open(name)
+	f, err := m.open(name)
 	if err != nil {
 		return nil, err
 	}
-	fi := mem.GetFileInfo(f.(*mem.File).Data())
-	return fi, nil
+	if name = normalizePath(name); name != f.Name() {
+		// through a symbolic link, whose name is reported like os.Stat does
+		return namedInfo{mem.GetFileInfo(f), filepath.Base(name)}, nil
+	}
+	return mem.GetFileInfo(f), nil
 }
 
 func (m *MemMapFs) Chmod(name string, mode os.FileMode) error {
-	name = normalizePath(name)
-
 	m.mu.RLock()
+	name, err := m.lockfreeResolve(name, true)
+	if err != nil {
+		m.mu.RUnlock()
+		return &os.PathError{Op: "chmod", Path: name, Err: err}
+	}
 	f, ok := m.getData()[name]
 	m.mu.RUnlock()
 	if !ok {
 		return &os.PathError{Op: "chmod", Path: name, Err: ErrFileNotFound}
 	}
 
+	if !m.isOwner(f) {
+		return &os.PathError{Op: "chmod", Path: name, Err: os.ErrPermission}
+	}
+
+	// Like os.Chmod, Chmod never changes the type of the file.
+	prev := mem.GetFileInfo(f).Mode()
 	m.mu.Lock()
-	mem.SetMode(f, mode)
+	mem.SetMode(f, mode&^os.ModeType|prev&os.ModeType)
 	m.mu.Unlock()
+	m.notify(name, WatchChmod)
 
 	return nil
 }
 
 func (m *MemMapFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
-	name = normalizePath(name)
-
 	m.mu.RLock()
+	name, err := m.lockfreeResolve(name, true)
+	if err != nil {
+		m.mu.RUnlock()
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
+	}
 	f, ok := m.getData()[name]
 	m.mu.RUnlock()
 	if !ok {
 		return &os.PathError{Op: "chtimes", Path: name, Err: ErrFileNotFound}
 	}
+	if !m.isOwner(f) {
+		return &os.PathError{Op: "chtimes", Path: name, Err: os.ErrPermission}
+	}
 
 	m.mu.Lock()
 	mem.SetModTime(f, mtime)
 	m.mu.Unlock()
+	m.notify(name, WatchChmod)
+
+	return nil
+}
+
+func (m *MemMapFs) Chown(name string, uid, gid int) error {
+	return m.chown("chown", name, uid, gid, true)
+}
+
+func (m *MemMapFs) Lchown(name string, uid, gid int) error {
+	return m.chown("lchown", name, uid, gid, false)
+}
+
+func (m *MemMapFs) chown(op, name string, uid, gid int, followLast bool) error {
+	m.mu.RLock()
+	name, err := m.lockfreeResolve(name, followLast)
+	if err != nil {
+		m.mu.RUnlock()
+		return &os.PathError{Op: op, Path: name, Err: err}
+	}
+	f, ok := m.getData()[name]
+	m.mu.RUnlock()
+	if !ok {
+		return &os.PathError{Op: op, Path: name, Err: ErrFileNotFound}
+	}
+	if !m.mayChown(f, uid, gid) {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	}
+
+	if uid != -1 {
+		mem.SetUID(f, uid)
+	}
+	if gid != -1 {
+		mem.SetGID(f, gid)
+	}
+	m.notify(name, WatchChmod)
+
+	return nil
+}
+
+func (m *MemMapFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	name, err := m.lockfreeResolve(name, false)
+	if err != nil {
+		return nil, true, &os.PathError{Op: "lstat", Path: name, Err: err}
+	}
+	f, ok := m.getData()[name]
+	if !ok {
+		return nil, true, &os.PathError{Op: "lstat", Path: name, Err: ErrFileNotFound}
+	}
+	return mem.GetFileInfo(f), true, nil
+}
+
+// SymlinkIfPossible creates newname as a symbolic link to oldname. Like on
+// the os filesystem, oldname is not required to exist.
+func (m *MemMapFs) SymlinkIfPossible(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	newname, err := m.lockfreeResolve(newname, false)
+	if err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	if _, ok := m.getData()[newname]; ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrFileExists}
+	}
+	if err := m.lockfreeCheckPath(newname, permWrite|permExec); err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	link := mem.CreateSymlink(newname, oldname)
+	if m.perms != nil {
+		m.lockfreeSetOwner(link, 0777)
+		mem.SetMode(link, os.ModeSymlink|0777)
+	}
+	m.getData()[newname] = link
+	m.registerWithParent(link)
+	m.notify(newname, WatchCreate)
+	return nil
+}
+
+func (m *MemMapFs) ReadlinkIfPossible(name string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	name, err := m.lockfreeResolve(name, false)
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	f, ok := m.getData()[name]
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: ErrFileNotFound}
+	}
+	target, isLink := mem.GetLinkTarget(f)
+	if !isLink {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return target, nil
+}
+
+// memWatch is a watch of a MemMapFs.
+type memWatch struct {
+	*queuedWatch
+	root      string
+	recursive bool
+}
+
+// Watch reports the changes made through m. Writes are reported once when
+// a written file is closed. The names in the events are the cleaned names
+// with symbolic links resolved.
+func (m *MemMapFs) Watch(name string, recursive bool) (Watch, error) {
+	m.mu.RLock()
+	name, err := m.lockfreeResolve(name, true)
+	if err == nil {
+		if _, ok := m.getData()[name]; !ok {
+			err = ErrFileNotFound
+		}
+	}
+	m.mu.RUnlock()
+	if err != nil {
+		return nil, &os.PathError{Op: "watch", Path: name, Err: err}
+	}
+
+	w := &memWatch{root: name, recursive: recursive}
+	w.queuedWatch = newQueuedWatch(func() error {
+		m.removeWatch(w)
+		return nil
+	})
+	m.watchMu.Lock()
+	m.watches = append(m.watches, w)
+	m.watchMu.Unlock()
+	return w, nil
+}
+
+func (m *MemMapFs) removeWatch(w *memWatch) {
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+	for i, x := range m.watches {
+		if x == w {
+			m.watches = append(m.watches[:i], m.watches[i+1:]...)
+			return
+		}
+	}
+}
+
+// notify reports the change of name to the watches interested in it.
+func (m *MemMapFs) notify(name string, op WatchOp) {
+	m.watchMu.Lock()
+	defer m.watchMu.Unlock()
+	for _, w := range m.watches {
+		if watchMatches(w.root, w.recursive, name) {
+			w.pushEvent(name, op)
+		}
+	}
+}
+
+const (
+	permRead  os.FileMode = 04
+	permWrite os.FileMode = 02
+	permExec  os.FileMode = 01
+)
+
+// EnforcePermissions makes m check the permission bits of its files the way
+// Linux does for a process running with the effective uid and gid given.
+// Denied operations fail with an error wrapping os.ErrPermission. Files
+// created afterwards are owned by uid and gid and get their permission bits
+// masked by umask. Like root, a uid of 0 is not restricted.
+//
+// The directories MemMapFs creates on demand for missing parents are not
+// checked, only the existing ones are.
+func (m *MemMapFs) EnforcePermissions(uid, gid int, umask os.FileMode) {
+	m.mu.Lock()
+	m.perms = &memPerms{uid: uid, gid: gid, umask: umask.Perm()}
+	m.mu.Unlock()
+}
+
+func fileMode(f *mem.FileData) (mode os.FileMode, uid, gid int) {
+	fi := mem.GetFileInfo(f)
+	st := fi.Sys().(*mem.Stat_t)
+	return fi.Mode(), int(st.Uid), int(st.Gid)
+}
+
+func openPerm(flag int) os.FileMode {
+	switch flag & (os.O_RDONLY | os.O_WRONLY | os.O_RDWR) {
+	case os.O_WRONLY:
+		return permWrite
+	case os.O_RDWR:
+		return permRead | permWrite
+	}
+	return permRead
+}
+
+// lockfreeSetOwner makes the new file f owned by the effective user, with
+// perm masked by the umask. The caller must hold m.mu and m.perms must be
+// set.
+func (m *MemMapFs) lockfreeSetOwner(f *mem.FileData, perm os.FileMode) {
+	mode, _, _ := fileMode(f)
+	mem.SetMode(f, mode&os.ModeType|perm.Perm()&^m.perms.umask)
+	mem.SetUID(f, m.perms.uid)
+	mem.SetGID(f, m.perms.gid)
+}
+
+// lockfreeAccess reports whether f grants want to the effective user. The
+// caller must hold m.mu.
+func (m *MemMapFs) lockfreeAccess(f *mem.FileData, want os.FileMode) bool {
+	if m.perms == nil || m.perms.uid == 0 {
+		return true
+	}
+	mode, uid, gid := fileMode(f)
+	perm := mode.Perm()
+	switch {
+	case uid == m.perms.uid:
+		perm >>= 6
+	case gid == m.perms.gid:
+		perm >>= 3
+	}
+	return perm&want == want
+}
+
+// lockfreeCheckPath checks that the directories leading to name can be
+// searched and that its parent directory grants want. The caller must hold
+// m.mu.
+func (m *MemMapFs) lockfreeCheckPath(name string, want os.FileMode) error {
+	if m.perms == nil || name == FilePathSeparator {
+		return nil
+	}
+	parent := true
+	for dir := filepath.Dir(name); ; dir = filepath.Dir(dir) {
+		// missing parents are created on demand, they are not checked
+		if f, ok := m.getData()[dir]; ok {
+			need := permExec
+			if parent {
+				need |= want
+				parent = false
+			}
+			if !m.lockfreeAccess(f, need) {
+				return os.ErrPermission
+			}
+		}
+		if dir == filepath.Dir(dir) {
+			return nil
+		}
+	}
+}
+
+// lockfreeCheckAccess checks that f, found at name, grants want. The caller
+// must hold m.mu.
+func (m *MemMapFs) lockfreeCheckAccess(name string, f *mem.FileData, want os.FileMode) error {
+	if err := m.lockfreeCheckPath(name, 0); err != nil {
+		return err
+	}
+	if !m.lockfreeAccess(f, want) {
+		return os.ErrPermission
+	}
+	return nil
+}
+
+// checkAccess checks that f grants want, the path to it was already checked.
+func (m *MemMapFs) checkAccess(op, name string, f *mem.FileData, want os.FileMode) error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if !m.lockfreeAccess(f, want) {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	}
+	return nil
+}
+
+// lockfreeCheckRemove checks that name may be removed from its directory.
+// In a sticky directory only the owners of the file and of the directory
+// may do so. The caller must hold m.mu.
+func (m *MemMapFs) lockfreeCheckRemove(name string) error {
+	if err := m.lockfreeCheckPath(name, permWrite|permExec); err != nil {
+		return err
+	}
+	if m.perms == nil || m.perms.uid == 0 {
+		return nil
+	}
+	parent, ok := m.getData()[filepath.Dir(name)]
+	f, fok := m.getData()[name]
+	if !ok || !fok {
+		return nil
+	}
+	pmode, puid, _ := fileMode(parent)
+	_, uid, _ := fileMode(f)
+	if pmode&os.ModeSticky != 0 && puid != m.perms.uid && uid != m.perms.uid {
+		return os.ErrPermission
+	}
+	return nil
+}
 
+// lockfreeCheckRename checks that f may be moved from oldname to newname.
+// The caller must hold m.mu.
+func (m *MemMapFs) lockfreeCheckRename(oldname, newname string, f *mem.FileData) error {
+	if err := m.lockfreeCheckRemove(oldname); err != nil {
+		return err
+	}
+	// checks the sticky bit too if newname is replaced
+	if err := m.lockfreeCheckRemove(newname); err != nil {
+		return err
+	}
+	// the ".." entry of a directory changes when it moves to another parent
+	if mem.GetFileInfo(f).IsDir() && filepath.Dir(oldname) != filepath.Dir(newname) &&
+		!m.lockfreeAccess(f, permWrite) {
+		return os.ErrPermission
+	}
 	return nil
 }
 
+// isOwner reports whether the effective user may change the mode and times
+// of f.
+func (m *MemMapFs) isOwner(f *mem.FileData) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.perms == nil || m.perms.uid == 0 {
+		return true
+	}
+	_, uid, _ := fileMode(f)
+	return uid == m.perms.uid
+}
+
+// mayChown reports whether the effective user may change the owner of f to
+// uid and gid. Others than root may only change the group of their own files
+// to their group.
+func (m *MemMapFs) mayChown(f *mem.FileData, uid, gid int) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.perms == nil || m.perms.uid == 0 {
+		return true
+	}
+	_, fuid, fgid := fileMode(f)
+	return fuid == m.perms.uid &&
+		(uid == -1 || uid == fuid) &&
+		(gid == -1 || gid == fgid || gid == m.perms.gid)
+}
+
 func (m *MemMapFs) List() {
 	for _, x := range m.data {
 		y := mem.FileInfo{FileData: x}
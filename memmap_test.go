@@ -8,6 +8,8 @@ import (
 	"runtime"
 	"testing"
 	"time"
+
+	"github.com/spf13/afero/mem"
 )
 
 func TestNormalizePath(t *testing.T) {
@@ -421,7 +423,7 @@ func TestMemFsDirMode(t *testing.T) {
 	}
 }
 
-func TestMemFsUnexpectedEOF(t *testing.T) {
+func TestMemFsReadPastEnd(t *testing.T) {
 	t.Parallel()
 
 	fs := NewMemMapFs()
@@ -442,10 +444,97 @@ func TestMemFsUnexpectedEOF(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	// Like os.File, reading past the end is EOF, not an unexpected one.
 	buff := make([]byte, 256)
 	_, err = io.ReadAtLeast(f, buff, 256)
 
-	if err != io.ErrUnexpectedEOF {
-		t.Fatal("Expected ErrUnexpectedEOF")
+	if err != io.EOF {
+		t.Fatal("Expected EOF")
+	}
+}
+
+func TestMemFsEnforcePermissions(t *testing.T) {
+	fs := &MemMapFs{}
+	fs.MkdirAll("/root", 0700)
+	fs.MkdirAll("/tmp", 0777|os.ModeSticky)
+	fs.Chmod("/tmp", 0777|os.ModeSticky)
+	fs.Mkdir("/home", 0755)
+	fs.Mkdir("/home/user", 0755)
+	fs.Chown("/home/user", 1000, 1000)
+	fs.EnforcePermissions(1000, 1000, 022)
+
+	if err := WriteFile(fs, "/home/user/f", []byte("data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := fs.Stat("/home/user/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != 0644 {
+		t.Errorf("got mode %v, expected the umask to be applied", fi.Mode())
+	}
+	if st := fi.Sys().(*mem.Stat_t); st.Uid != 1000 || st.Gid != 1000 {
+		t.Errorf("got owner %d:%d, expected 1000:1000", st.Uid, st.Gid)
+	}
+
+	fs.Chmod("/home/user/f", 0400)
+	if _, err := fs.OpenFile("/home/user/f", os.O_WRONLY, 0); !os.IsPermission(err) {
+		t.Errorf("OpenFile for write of a 0400 file: got %v", err)
+	}
+	if f, err := fs.OpenFile("/home/user/f", os.O_RDONLY, 0); err != nil {
+		t.Errorf("OpenFile for read of a 0400 file: %v", err)
+	} else {
+		f.Close()
+	}
+
+	fs.Mkdir("/home/user/ro", 0555)
+	for _, err := range []error{
+		WriteFile(fs, "/home/user/ro/f", []byte("data"), 0644),
+		fs.Mkdir("/home/user/ro/d", 0755),
+		fs.Mkdir("/home/d", 0755),
+		fs.Remove("/home/user"),
+		fs.Rename("/home/user/f", "/home/user/ro/f"),
+		fs.Chmod("/home", 0777),
+		fs.Chown("/home/user/f", 0, 0),
+	} {
+		if !os.IsPermission(err) {
+			t.Errorf("got %v, expected a permission error", err)
+		}
+	}
+	if err := fs.Rename("/home/user/f", "/home/user/g"); err != nil {
+		t.Errorf("Rename in a writable directory: %v", err)
+	}
+
+	// Readdir needs read permission on the directory, the files in it need
+	// search permission.
+	fs.Chmod("/home/user/ro", 0311)
+	if _, err := ReadDir(fs, "/home/user/ro"); !os.IsPermission(err) {
+		t.Errorf("ReadDir of a 0311 directory: got %v", err)
+	}
+	if _, err := ReadDir(fs, "/root"); !os.IsPermission(err) {
+		t.Errorf("ReadDir of another user's 0700 directory: got %v", err)
+	}
+	if _, err := fs.Stat("/root/x"); !os.IsPermission(err) {
+		t.Errorf("Stat in an unsearchable directory: got %v", err)
+	}
+
+	// Only the owner may remove a file from a sticky directory.
+	fs.EnforcePermissions(0, 0, 022)
+	WriteFile(fs, "/tmp/root", []byte("data"), 0666)
+	fs.EnforcePermissions(1000, 1000, 022)
+	WriteFile(fs, "/tmp/user", []byte("data"), 0666)
+	if err := fs.Remove("/tmp/root"); !os.IsPermission(err) {
+		t.Errorf("Remove of another user's file in a sticky directory: got %v", err)
+	}
+	if err := fs.Remove("/tmp/user"); err != nil {
+		t.Errorf("Remove of an own file in a sticky directory: %v", err)
+	}
+
+	// RemoveAll removes nothing when a directory can't be emptied.
+	if err := fs.RemoveAll("/home/user"); !os.IsPermission(err) {
+		t.Errorf("RemoveAll: got %v", err)
+	}
+	if _, err := fs.Stat("/home/user/g"); err != nil {
+		t.Errorf("RemoveAll removed files: %v", err)
 	}
 }
@@ -0,0 +1,409 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var _ Lstater = (*MountFs)(nil)
+var _ Symlinker = (*MountFs)(nil)
+var _ Chowner = (*MountFs)(nil)
+var _ Watcher = (*MountFs)(nil)
+
+// The MountFs composes file systems mounted at paths, like a VFS. Every call
+// goes to the file system mounted at the longest prefix of the name, with
+// the name relative to its mount point. The root file system is mounted at
+// "/".
+//
+// The mount points are listed in the directories they are in. A directory
+// which only leads to mount points needs not exist, it is listed as an empty,
+// read only directory. A mount point, or a directory holding one, cannot be
+// removed or renamed, and renaming across mounts fails with EXDEV, like with
+// rename(2).
+type MountFs struct {
+	mu     sync.RWMutex
+	mounts map[string]Fs
+}
+
+// NewMountFs returns a MountFs with root mounted at "/". A nil root is an
+// empty, read only file system.
+func NewMountFs(root Fs) *MountFs {
+	if root == nil {
+		root = NewReadOnlyFs(NewMemMapFs())
+	}
+	return &MountFs{mounts: map[string]Fs{FilePathSeparator: root}}
+}
+
+func mountPath(name string) string {
+	return filepath.Join(FilePathSeparator, name)
+}
+
+// Mount mounts fs at path. A path already used as mount point fails with
+// EBUSY.
+func (m *MountFs) Mount(path string, fs Fs) error {
+	path = mountPath(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.mounts[path]; ok {
+		return &os.PathError{Op: "mount", Path: path, Err: syscall.EBUSY}
+	}
+	m.mounts[path] = fs
+	return nil
+}
+
+// Unmount removes the file system mounted at path. The root cannot be
+// unmounted.
+func (m *MountFs) Unmount(path string) error {
+	path = mountPath(path)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.mounts[path]; !ok || path == FilePathSeparator {
+		return &os.PathError{Op: "unmount", Path: path, Err: syscall.EINVAL}
+	}
+	delete(m.mounts, path)
+	return nil
+}
+
+// resolve returns the file system name is in, its mount point and the name
+// relative to it.
+func (m *MountFs) resolve(name string) (fs Fs, point, rel string) {
+	name = mountPath(name)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for p := name; ; p = filepath.Dir(p) {
+		if fs, ok := m.mounts[p]; ok {
+			return fs, p, mountPath(strings.TrimPrefix(name, p))
+		}
+	}
+}
+
+// children returns the names of the entries of the directory name leading
+// to mount points, sorted.
+func (m *MountFs) children(name string) []string {
+	name = mountPath(name)
+	prefix := strings.TrimSuffix(name, FilePathSeparator) + FilePathSeparator
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	seen := make(map[string]bool)
+	var names []string
+	for p := range m.mounts {
+		if p == name || !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		child := strings.SplitN(strings.TrimPrefix(p, prefix), FilePathSeparator, 2)[0]
+		if !seen[child] {
+			seen[child] = true
+			names = append(names, child)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// busy reports whether name is a mount point or holds one.
+func (m *MountFs) busy(name string) bool {
+	name = mountPath(name)
+	m.mu.RLock()
+	_, ok := m.mounts[name]
+	m.mu.RUnlock()
+	return ok || len(m.children(name)) > 0
+}
+
+// mountErr names the file with the name given to the MountFs in err.
+func mountErr(err error, name string) error {
+	switch e := err.(type) {
+	case *os.PathError:
+		return &os.PathError{Op: e.Op, Path: name, Err: e.Err}
+	}
+	return err
+}
+
+func (m *MountFs) Chtimes(name string, atime, mtime time.Time) error {
+	fs, _, rel := m.resolve(name)
+	return mountErr(fs.Chtimes(rel, atime, mtime), name)
+}
+
+func (m *MountFs) Chmod(name string, mode os.FileMode) error {
+	fs, _, rel := m.resolve(name)
+	return mountErr(fs.Chmod(rel, mode), name)
+}
+
+func (m *MountFs) Chown(name string, uid, gid int) error {
+	fs, _, rel := m.resolve(name)
+	chowner, ok := fs.(Chowner)
+	if !ok {
+		return &os.PathError{Op: "chown", Path: name, Err: ErrNoChown}
+	}
+	return mountErr(chowner.Chown(rel, uid, gid), name)
+}
+
+func (m *MountFs) Lchown(name string, uid, gid int) error {
+	fs, _, rel := m.resolve(name)
+	chowner, ok := fs.(Chowner)
+	if !ok {
+		return &os.PathError{Op: "lchown", Path: name, Err: ErrNoChown}
+	}
+	return mountErr(chowner.Lchown(rel, uid, gid), name)
+}
+
+func (m *MountFs) Name() string {
+	return "MountFs"
+}
+
+// info names fi of rel in the file system mounted at point after name.
+func (m *MountFs) info(fi os.FileInfo, point, rel, name string) os.FileInfo {
+	if rel == FilePathSeparator && point != FilePathSeparator {
+		return namedInfo{FileInfo: fi, name: filepath.Base(name)}
+	}
+	return fi
+}
+
+func (m *MountFs) Stat(name string) (os.FileInfo, error) {
+	fs, point, rel := m.resolve(name)
+	fi, err := fs.Stat(rel)
+	if err != nil {
+		if os.IsNotExist(err) && len(m.children(name)) > 0 {
+			return mountDirInfo(filepath.Base(mountPath(name))), nil
+		}
+		return nil, mountErr(err, name)
+	}
+	return m.info(fi, point, rel, name), nil
+}
+
+func (m *MountFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	fs, point, rel := m.resolve(name)
+	var fi os.FileInfo
+	var lstat bool
+	var err error
+	if lstater, ok := fs.(Lstater); ok {
+		fi, lstat, err = lstater.LstatIfPossible(rel)
+	} else {
+		fi, err = fs.Stat(rel)
+	}
+	if err != nil {
+		if os.IsNotExist(err) && len(m.children(name)) > 0 {
+			return mountDirInfo(filepath.Base(mountPath(name))), lstat, nil
+		}
+		return nil, lstat, mountErr(err, name)
+	}
+	return m.info(fi, point, rel, name), lstat, nil
+}
+
+func (m *MountFs) SymlinkIfPossible(oldname, newname string) error {
+	fs, _, rel := m.resolve(newname)
+	linker, ok := fs.(Linker)
+	if !ok {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+	}
+	if err := linker.SymlinkIfPossible(oldname, rel); err != nil {
+		if e, ok := err.(*os.LinkError); ok {
+			return &os.LinkError{Op: e.Op, Old: oldname, New: newname, Err: e.Err}
+		}
+		return err
+	}
+	return nil
+}
+
+func (m *MountFs) ReadlinkIfPossible(name string) (string, error) {
+	fs, _, rel := m.resolve(name)
+	target, err := readlinkIfPossible(fs, rel)
+	return target, mountErr(err, name)
+}
+
+func (m *MountFs) Rename(oldname, newname string) error {
+	linkErr := func(err error) error {
+		if e, ok := err.(*os.PathError); ok {
+			err = e.Err
+		}
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	if m.busy(oldname) || m.busy(newname) {
+		return linkErr(syscall.EBUSY)
+	}
+	ofs, opoint, orel := m.resolve(oldname)
+	_, npoint, nrel := m.resolve(newname)
+	if opoint != npoint {
+		return linkErr(syscall.EXDEV)
+	}
+	if err := ofs.Rename(orel, nrel); err != nil {
+		return linkErr(err)
+	}
+	return nil
+}
+
+func (m *MountFs) RemoveAll(path string) error {
+	if m.busy(path) {
+		return &os.PathError{Op: "removeall", Path: path, Err: syscall.EBUSY}
+	}
+	fs, _, rel := m.resolve(path)
+	return mountErr(fs.RemoveAll(rel), path)
+}
+
+func (m *MountFs) Remove(name string) error {
+	if m.busy(name) {
+		return &os.PathError{Op: "remove", Path: name, Err: syscall.EBUSY}
+	}
+	fs, _, rel := m.resolve(name)
+	return mountErr(fs.Remove(rel), name)
+}
+
+func (m *MountFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|syscall.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) == 0 {
+		return m.Open(name)
+	}
+	fs, _, rel := m.resolve(name)
+	f, err := fs.OpenFile(rel, flag, perm)
+	if err != nil {
+		return nil, mountErr(err, name)
+	}
+	return &MountFile{File: f, name: name}, nil
+}
+
+// Open opens name in the file system it is in. A directory holding mount
+// points is read at once, to list them.
+func (m *MountFs) Open(name string) (File, error) {
+	fs, point, rel := m.resolve(name)
+	children := m.children(name)
+	f, err := fs.Open(rel)
+	if err != nil {
+		if os.IsNotExist(err) && len(children) > 0 {
+			return m.mountDir(name, mountDirInfo(filepath.Base(mountPath(name))), nil, children)
+		}
+		return nil, mountErr(err, name)
+	}
+	if len(children) == 0 {
+		return &MountFile{File: f, name: name}, nil
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, mountErr(err, name)
+	}
+	if !fi.IsDir() {
+		return m.mountDir(name, m.info(fi, point, rel, name), nil, children)
+	}
+	entries, err := f.Readdir(-1)
+	if err != nil {
+		return nil, mountErr(err, name)
+	}
+	return m.mountDir(name, m.info(fi, point, rel, name), entries, children)
+}
+
+// mountDir returns the directory name listing entries and the mount points
+// in it.
+func (m *MountFs) mountDir(name string, fi os.FileInfo, entries []os.FileInfo, children []string) (File, error) {
+	byName := make(map[string]os.FileInfo)
+	for _, e := range entries {
+		byName[e.Name()] = e
+	}
+	for _, child := range children {
+		cfi, err := m.Stat(filepath.Join(mountPath(name), child))
+		if err != nil {
+			return nil, err
+		}
+		byName[child] = cfi
+	}
+	listing := make([]os.FileInfo, 0, len(byName))
+	for _, e := range byName {
+		listing = append(listing, e)
+	}
+	sort.Slice(listing, func(i, j int) bool { return listing[i].Name() < listing[j].Name() })
+	return &cacheDirFile{name: name, fi: fi, entries: listing}, nil
+}
+
+func (m *MountFs) Mkdir(name string, perm os.FileMode) error {
+	fs, _, rel := m.resolve(name)
+	return mountErr(fs.Mkdir(rel, perm), name)
+}
+
+func (m *MountFs) MkdirAll(path string, perm os.FileMode) error {
+	fs, _, rel := m.resolve(path)
+	return mountErr(fs.MkdirAll(rel, perm), path)
+}
+
+func (m *MountFs) Create(name string) (File, error) {
+	fs, _, rel := m.resolve(name)
+	f, err := fs.Create(rel)
+	if err != nil {
+		return nil, mountErr(err, name)
+	}
+	return &MountFile{File: f, name: name}, nil
+}
+
+// Watch watches name in the file system it is in, which must be a Watcher.
+// A recursive watch does not cross into the file systems mounted below
+// name.
+func (m *MountFs) Watch(name string, recursive bool) (Watch, error) {
+	fs, point, rel := m.resolve(name)
+	watcher, ok := fs.(Watcher)
+	if !ok {
+		return nil, &os.PathError{Op: "watch", Path: name, Err: ErrNoWatch}
+	}
+	w, err := watcher.Watch(rel, recursive)
+	if err != nil {
+		return nil, mountErr(err, name)
+	}
+	return mapWatch(w, func(name string) (string, bool) {
+		return filepath.Join(point, name), true
+	}), nil
+}
+
+// MountFile is a file opened through a MountFs, named like it was opened.
+type MountFile struct {
+	File
+	name string
+}
+
+func (f *MountFile) Name() string {
+	return f.name
+}
+
+func (f *MountFile) Stat() (os.FileInfo, error) {
+	fi, err := f.File.Stat()
+	if err != nil {
+		return nil, mountErr(err, f.name)
+	}
+	// the root of a mounted file system
+	if name := filepath.Base(mountPath(f.name)); fi.Name() != name {
+		return namedInfo{FileInfo: fi, name: name}, nil
+	}
+	return fi, nil
+}
+
+// namedInfo is an os.FileInfo under another name, like the root of a
+// mounted file system, named after its mount point.
+type namedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi namedInfo) Name() string { return fi.name }
+
+// mountDirInfo is the os.FileInfo of a directory only leading to mount
+// points.
+type mountDirInfo string
+
+func (fi mountDirInfo) Name() string       { return string(fi) }
+func (fi mountDirInfo) Size() int64        { return 0 }
+func (fi mountDirInfo) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (fi mountDirInfo) ModTime() time.Time { return time.Time{} }
+func (fi mountDirInfo) IsDir() bool        { return true }
+func (fi mountDirInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,117 @@
+package afero
+
+import (
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestMountFs(t *testing.T) {
+	root, tmp, deep, img := NewMemMapFs(), NewMemMapFs(), NewMemMapFs(), NewMemMapFs()
+	WriteFile(root, "/etc/conf", []byte("conf"), 0644)
+	WriteFile(img, "/logo.png", []byte("png"), 0644)
+
+	mfs := NewMountFs(root)
+	for point, fs := range map[string]Fs{"/tmp": tmp, "/tmp/deep": deep, "/assets/img": img} {
+		if err := mfs.Mount(point, fs); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := mfs.Mount("/tmp", tmp); !os.IsExist(err) && !isErrno(err, syscall.EBUSY) {
+		t.Errorf("mounting twice: got %v", err)
+	}
+
+	// routing by the longest prefix
+	if err := WriteFile(mfs, "/tmp/x", []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(mfs, "/tmp/deep/y", []byte("y"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for fs, name := range map[Fs]string{tmp: "/x", deep: "/y"} {
+		if ok, _ := Exists(fs, name); !ok {
+			t.Errorf("%s not in its file system", name)
+		}
+	}
+	if data, err := ReadFile(mfs, "/assets/img/logo.png"); err != nil || string(data) != "png" {
+		t.Errorf("read %q, %v", data, err)
+	}
+
+	// the mount points are listed
+	for dir, want := range map[string]string{
+		"/":       "assets etc tmp",
+		"/assets": "img",
+		"/tmp":    "deep x",
+	} {
+		if got := listNames(t, mfs, dir); got != want {
+			t.Errorf("%s: got %q, want %q", dir, got, want)
+		}
+	}
+	if fi, err := mfs.Stat("/tmp/deep"); err != nil || fi.Name() != "deep" || !fi.IsDir() {
+		t.Errorf("stat of the mount point: %v, %v", fi, err)
+	}
+
+	var walked []string
+	err := Walk(mfs, "/", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		walked = append(walked, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "/ /assets /assets/img /assets/img/logo.png /etc /etc/conf /tmp /tmp/deep /tmp/deep/y /tmp/x"
+	if got := strings.Join(walked, " "); got != want {
+		t.Errorf("walked %s", got)
+	}
+
+	f, err := mfs.Open("/tmp/x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name() != "/tmp/x" {
+		t.Errorf("got name %s", f.Name())
+	}
+	f.Close()
+}
+
+func TestMountFsRename(t *testing.T) {
+	mfs := NewMountFs(NewMemMapFs())
+	mfs.Mount("/tmp", NewMemMapFs())
+	WriteFile(mfs, "/tmp/x", []byte("x"), 0644)
+	mfs.Mkdir("/etc", 0755)
+
+	if err := mfs.Rename("/tmp/x", "/etc/x"); !isErrno(err, syscall.EXDEV) {
+		t.Errorf("rename across mounts: got %v", err)
+	}
+	if err := mfs.Rename("/tmp/x", "/tmp/z"); err != nil {
+		t.Errorf("rename in a mount: %v", err)
+	}
+	if err := mfs.Rename("/tmp", "/temp"); !isErrno(err, syscall.EBUSY) {
+		t.Errorf("rename of a mount point: got %v", err)
+	}
+	for _, remove := range []func(string) error{mfs.Remove, mfs.RemoveAll} {
+		if err := remove("/tmp"); !isErrno(err, syscall.EBUSY) {
+			t.Errorf("removing a mount point: got %v", err)
+		}
+	}
+	if err := mfs.Unmount("/tmp"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mfs.Stat("/tmp/z"); !os.IsNotExist(err) {
+		t.Errorf("unmounted: got %v", err)
+	}
+}
+
+func isErrno(err error, errno syscall.Errno) bool {
+	switch e := err.(type) {
+	case *os.PathError:
+		err = e.Err
+	case *os.LinkError:
+		err = e.Err
+	}
+	return err == errno
+}
@@ -19,7 +19,9 @@ import (
 	"time"
 )
 
-var _ Lstater = (*OsFs)(nil)
+var _ Symlinker = (*OsFs)(nil)
+var _ Chowner = (*OsFs)(nil)
+var _ Watcher = (*OsFs)(nil)
 
 // OsFs is a Fs implementation that uses functions provided by the os package.
 //
@@ -95,7 +97,30 @@ func (OsFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
 	return os.Chtimes(name, atime, mtime)
 }
 
+func (OsFs) Chown(name string, uid, gid int) error {
+	return os.Chown(name, uid, gid)
+}
+
+func (OsFs) Lchown(name string, uid, gid int) error {
+	return os.Lchown(name, uid, gid)
+}
+
 func (OsFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
 	fi, err := os.Lstat(name)
 	return fi, true, err
 }
+
+func (OsFs) SymlinkIfPossible(oldname, newname string) error {
+	return os.Symlink(oldname, newname)
+}
+
+func (OsFs) ReadlinkIfPossible(name string) (string, error) {
+	return os.Readlink(name)
+}
+
+// Watch reports the changes of name using the notification facility of the
+// operating system. It is only supported on Linux, elsewhere an
+// os.PathError wrapping ErrNoWatch is returned.
+func (OsFs) Watch(name string, recursive bool) (Watch, error) {
+	return watchOs(name, recursive)
+}
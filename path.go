@@ -104,3 +104,85 @@ func Walk(fs Fs, root string, walkFn filepath.WalkFunc) error {
 	}
 	return walk(fs, root, info, walkFn)
 }
+
+// WalkDir walks the file tree rooted at root like Walk, calling fn with a
+// DirEntry for each file or directory in the tree, including root. The
+// entries come from Readdir, the files are not looked up one by one, and
+// DirEntry.Info returns what Readdir returned.
+//
+// Returning filepath.SkipDir from fn skips the directory, or the rest of
+// the directory holding the file; returning SkipAll stops the walk, and
+// WalkDir returns nil. The files are walked in lexical order and the
+// symbolic links are not followed.
+func (a Afero) WalkDir(root string, fn WalkDirFunc) error {
+	return WalkDir(a.Fs, root, fn)
+}
+
+func WalkDir(fs Fs, root string, fn WalkDirFunc) error {
+	info, err := lstatIfPossible(fs, root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkDir(fs, root, infoDirEntry{info}, fn)
+	}
+	if err == filepath.SkipDir || err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkDir(fs Fs, path string, d DirEntry, fn WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == filepath.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	entries, err := readDirEntries(fs, path)
+	if err != nil {
+		// a second call, to report the error
+		if err = fn(path, d, err); err != nil {
+			if err == filepath.SkipDir {
+				err = nil
+			}
+			return err
+		}
+	}
+	for _, e := range entries {
+		if err := walkDir(fs, filepath.Join(path, e.Name()), e, fn); err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// readDirEntries returns the entries of the directory name sorted by name,
+// and the ones read before an error.
+func readDirEntries(fs Fs, name string) ([]DirEntry, error) {
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := f.Readdir(-1)
+	f.Close()
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	entries := make([]DirEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = infoDirEntry{info}
+	}
+	return entries, err
+}
+
+// infoDirEntry is the DirEntry of an os.FileInfo.
+type infoDirEntry struct {
+	info os.FileInfo
+}
+
+func (e infoDirEntry) Name() string               { return e.info.Name() }
+func (e infoDirEntry) IsDir() bool                { return e.info.IsDir() }
+func (e infoDirEntry) Type() os.FileMode          { return e.info.Mode() & os.ModeType }
+func (e infoDirEntry) Info() (os.FileInfo, error) { return e.info, nil }
@@ -17,6 +17,8 @@ package afero
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -67,3 +69,86 @@ func TestWalk(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestWalkDir(t *testing.T) {
+	defer removeAllTestFiles(t)
+	var testDir string
+	for i, fs := range Fss {
+		if i == 0 {
+			testDir = setupTestDirRoot(t, fs)
+		} else {
+			setupTestDirReusePath(t, fs, testDir)
+		}
+	}
+
+	// WalkDir visits what Walk visits
+	for _, fs := range Fss {
+		var walked, walkedDir string
+		Walk(fs, testDir, func(path string, info os.FileInfo, err error) error {
+			walked += fmt.Sprintln(path, info.IsDir())
+			return err
+		})
+		err := WalkDir(fs, testDir, func(path string, d DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			info, err := d.Info()
+			if err != nil || info.Name() != d.Name() {
+				t.Errorf("%s: info %v, %v", path, info, err)
+			}
+			walkedDir += fmt.Sprintln(path, d.IsDir())
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+		if walkedDir != walked {
+			t.Errorf("%s: WalkDir:\n%s\nWalk:\n%s", fs.Name(), walkedDir, walked)
+		}
+	}
+}
+
+func TestWalkDirSkip(t *testing.T) {
+	base := NewMemMapFs()
+	for _, name := range []string{"/a/1", "/a/2", "/b/1", "/b/2", "/c/1", "/d"} {
+		WriteFile(base, name, nil, 0644)
+	}
+	fs := &countingFs{Fs: base}
+
+	var walked []string
+	err := WalkDir(fs, "/", func(path string, d DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		walked = append(walked, path)
+		switch path {
+		case "/a/1":
+			return filepath.SkipDir // the rest of /a
+		case "/b":
+			return filepath.SkipDir
+		case "/c/1":
+			return SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(walked, " "); got != "/ /a /a/1 /b /c /c/1" {
+		t.Errorf("walked %s", got)
+	}
+	// the root looked up, and the directories read
+	if fs.calls != 4 {
+		t.Errorf("%d calls to the Fs", fs.calls)
+	}
+
+	err = WalkDir(fs, "/missing", func(path string, d DirEntry, err error) error {
+		if d != nil || !os.IsNotExist(err) {
+			t.Errorf("got %v, %v", d, err)
+		}
+		return err
+	})
+	if !os.IsNotExist(err) {
+		t.Errorf("got %v", err)
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"time"
 )
 
-var _ Lstater = (*ReadOnlyFs)(nil)
+var _ Symlinker = (*ReadOnlyFs)(nil)
+var _ Chowner = (*ReadOnlyFs)(nil)
+var _ Watcher = (*ReadOnlyFs)(nil)
 
 type ReadOnlyFs struct {
 	source Fs
@@ -28,6 +30,14 @@ func (r *ReadOnlyFs) Chmod(n string, m os.FileMode) error {
 	return syscall.EPERM
 }
 
+func (r *ReadOnlyFs) Chown(n string, uid, gid int) error {
+	return syscall.EPERM
+}
+
+func (r *ReadOnlyFs) Lchown(n string, uid, gid int) error {
+	return syscall.EPERM
+}
+
 func (r *ReadOnlyFs) Name() string {
 	return "ReadOnlyFilter"
 }
@@ -44,6 +54,24 @@ func (r *ReadOnlyFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
 	return fi, false, err
 }
 
+func (r *ReadOnlyFs) SymlinkIfPossible(oldname, newname string) error {
+	return syscall.EPERM
+}
+
+func (r *ReadOnlyFs) ReadlinkIfPossible(name string) (string, error) {
+	if reader, ok := r.source.(LinkReader); ok {
+		return reader.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
+
+func (r *ReadOnlyFs) Watch(name string, recursive bool) (Watch, error) {
+	if watcher, ok := r.source.(Watcher); ok {
+		return watcher.Watch(name, recursive)
+	}
+	return nil, &os.PathError{Op: "watch", Path: name, Err: ErrNoWatch}
+}
+
 func (r *ReadOnlyFs) Rename(o, n string) error {
 	return syscall.EPERM
 }
@@ -7,6 +7,9 @@ import (
 	"time"
 )
 
+var _ Symlinker = (*RegexpFs)(nil)
+var _ Chowner = (*RegexpFs)(nil)
+
 // The RegexpFs filters files (not directories) by regular expression. Only
 // files matching the given regexp will be allowed, all others get a ENOENT error (
 // "No such file or directory").
@@ -60,6 +63,28 @@ func (r *RegexpFs) Chmod(name string, mode os.FileMode) error {
 	return r.source.Chmod(name, mode)
 }
 
+func (r *RegexpFs) Chown(name string, uid, gid int) error {
+	if err := r.dirOrMatches(name); err != nil {
+		return err
+	}
+	if chowner, ok := r.source.(Chowner); ok {
+		return chowner.Chown(name, uid, gid)
+	}
+	return &os.PathError{Op: "chown", Path: name, Err: ErrNoChown}
+}
+
+func (r *RegexpFs) Lchown(name string, uid, gid int) error {
+	if err := r.matchesName(name); err != nil {
+		if dir, _ := IsDir(r.source, name); !dir {
+			return err
+		}
+	}
+	if chowner, ok := r.source.(Chowner); ok {
+		return chowner.Lchown(name, uid, gid)
+	}
+	return &os.PathError{Op: "lchown", Path: name, Err: ErrNoChown}
+}
+
 func (r *RegexpFs) Name() string {
 	return "RegexpFs"
 }
@@ -71,13 +96,51 @@ func (r *RegexpFs) Stat(name string) (os.FileInfo, error) {
 	return r.source.Stat(name)
 }
 
+func (r *RegexpFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	lsf, ok := r.source.(Lstater)
+	if !ok {
+		fi, err := r.Stat(name)
+		return fi, false, err
+	}
+	fi, b, err := lsf.LstatIfPossible(name)
+	if err != nil {
+		return nil, b, err
+	}
+	if !fi.IsDir() {
+		if err := r.matchesName(name); err != nil {
+			return nil, b, err
+		}
+	}
+	return fi, b, nil
+}
+
+func (r *RegexpFs) SymlinkIfPossible(oldname, newname string) error {
+	if err := r.matchesName(newname); err != nil {
+		return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: err}
+	}
+	if linker, ok := r.source.(Linker); ok {
+		return linker.SymlinkIfPossible(oldname, newname)
+	}
+	return &os.LinkError{Op: "symlink", Old: oldname, New: newname, Err: ErrNoSymlink}
+}
+
+func (r *RegexpFs) ReadlinkIfPossible(name string) (string, error) {
+	if err := r.matchesName(name); err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	if reader, ok := r.source.(LinkReader); ok {
+		return reader.ReadlinkIfPossible(name)
+	}
+	return "", &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+}
+
 func (r *RegexpFs) Rename(oldname, newname string) error {
 	dir, err := IsDir(r.source, oldname)
 	if err != nil {
 		return err
 	}
 	if dir {
-		return nil
+		return r.source.Rename(oldname, newname)
 	}
 	if err := r.matchesName(oldname); err != nil {
 		return err
@@ -90,6 +153,9 @@ func (r *RegexpFs) Rename(oldname, newname string) error {
 
 func (r *RegexpFs) RemoveAll(p string) error {
 	dir, err := IsDir(r.source, p)
+	if os.IsNotExist(err) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
@@ -110,7 +176,13 @@ func (r *RegexpFs) Remove(name string) error {
 
 func (r *RegexpFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
 	if err := r.dirOrMatches(name); err != nil {
-		return nil, err
+		// a file which is about to be created only needs a matching name
+		if flag&os.O_CREATE == 0 || !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err := r.matchesName(name); err != nil {
+			return nil, err
+		}
 	}
 	return r.source.OpenFile(name, flag, perm)
 }
@@ -126,6 +198,9 @@ func (r *RegexpFs) Open(name string) (File, error) {
 		}
 	}
 	f, err := r.source.Open(name)
+	if err != nil {
+		return nil, err
+	}
 	return &RegexpFile{f: f, re: r.re}, nil
 }
 
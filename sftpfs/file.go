@@ -14,12 +14,23 @@
 package sftpfs
 
 import (
-	"github.com/pkg/sftp"
+	"io"
 	"os"
+
+	"github.com/pkg/sftp"
 )
 
 type File struct {
-	fd *sftp.File
+	client *sftp.Client
+	fd     *sftp.File
+
+	// whether the file was opened with O_APPEND
+	append bool
+
+	// the directory entries not returned by Readdir yet, read on the
+	// first call
+	entries []os.FileInfo
+	read    bool
 }
 
 func FileOpen(s *sftp.Client, name string) (*File, error) {
@@ -27,7 +38,7 @@ func FileOpen(s *sftp.Client, name string) (*File, error) {
 	if err != nil {
 		return &File{}, err
 	}
-	return &File{fd: fd}, nil
+	return &File{client: s, fd: fd}, nil
 }
 
 func FileCreate(s *sftp.Client, name string) (*File, error) {
@@ -35,7 +46,7 @@ func FileCreate(s *sftp.Client, name string) (*File, error) {
 	if err != nil {
 		return &File{}, err
 	}
-	return &File{fd: fd}, nil
+	return &File{client: s, fd: fd}, nil
 }
 
 func (f *File) Close() error {
@@ -62,19 +73,51 @@ func (f *File) Read(b []byte) (n int, err error) {
 	return f.fd.Read(b)
 }
 
-// TODO
+// ReadAt reads at off without moving the offset used by Read and Write.
+// It is not safe to call ReadAt concurrently with the other methods.
 func (f *File) ReadAt(b []byte, off int64) (n int, err error) {
-	return 0, nil
-}
-
-// TODO
+	err = f.at(off, func() error {
+		n, err = io.ReadFull(f.fd, b)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		}
+		return err
+	})
+	return n, err
+}
+
+// Readdir reads the directory entries with a single request on the first
+// call and pages through them afterwards.
 func (f *File) Readdir(count int) (res []os.FileInfo, err error) {
-	return nil, nil
+	if !f.read {
+		entries, err := f.client.ReadDir(f.fd.Name())
+		if err != nil {
+			return nil, &os.PathError{Op: "readdir", Path: f.fd.Name(), Err: err}
+		}
+		f.entries = entries
+		f.read = true
+	}
+
+	if count <= 0 {
+		res, f.entries = f.entries, nil
+		return res, nil
+	}
+	if len(f.entries) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(f.entries) {
+		count = len(f.entries)
+	}
+	res, f.entries = f.entries[:count], f.entries[count:]
+	return res, nil
 }
 
-// TODO
 func (f *File) Readdirnames(n int) (names []string, err error) {
-	return nil, nil
+	fis, err := f.Readdir(n)
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	return names, err
 }
 
 func (f *File) Seek(offset int64, whence int) (int64, error) {
@@ -82,14 +125,40 @@ func (f *File) Seek(offset int64, whence int) (int64, error) {
 }
 
 func (f *File) Write(b []byte) (n int, err error) {
+	if f.append {
+		if _, err := f.fd.Seek(0, io.SeekEnd); err != nil {
+			return 0, err
+		}
+	}
 	return f.fd.Write(b)
 }
 
-// TODO
+// WriteAt writes at off without moving the offset used by Read and Write.
+// It is not safe to call WriteAt concurrently with the other methods.
 func (f *File) WriteAt(b []byte, off int64) (n int, err error) {
-	return 0, nil
+	err = f.at(off, func() error {
+		n, err = f.fd.Write(b)
+		return err
+	})
+	return n, err
 }
 
 func (f *File) WriteString(s string) (ret int, err error) {
-	return f.fd.Write([]byte(s))
+	return f.Write([]byte(s))
+}
+
+// at runs fn with the file offset at off and restores the offset after.
+func (f *File) at(off int64, fn func() error) error {
+	prev, err := f.fd.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := f.fd.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	err = fn()
+	if _, serr := f.fd.Seek(prev, io.SeekStart); err == nil {
+		err = serr
+	}
+	return err
 }
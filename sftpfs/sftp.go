@@ -15,6 +15,8 @@ package sftpfs
 
 import (
 	"os"
+	"path"
+	"syscall"
 	"time"
 
 	"github.com/pkg/sftp"
@@ -29,6 +31,8 @@ type Fs struct {
 	client *sftp.Client
 }
 
+var _ afero.Lstater = (*Fs)(nil)
+
 func New(client *sftp.Client) afero.Fs {
 	return &Fs{client: client}
 }
@@ -36,13 +40,18 @@ func New(client *sftp.Client) afero.Fs {
 func (s Fs) Name() string { return "sftpfs" }
 
 func (s Fs) Create(name string) (afero.File, error) {
-	return FileCreate(s.client, name)
+	return s.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 }
 
 func (s Fs) Mkdir(name string, perm os.FileMode) error {
 	err := s.client.Mkdir(name)
 	if err != nil {
-		return err
+		// The sftp protocol has no status for existing files, the
+		// servers report a generic failure.
+		if _, serr := s.client.Lstat(name); serr == nil {
+			err = os.ErrExist
+		}
+		return &os.PathError{Op: "mkdir", Path: name, Err: err}
 	}
 	return s.client.Chmod(name, perm)
 }
@@ -54,7 +63,7 @@ func (s Fs) MkdirAll(path string, perm os.FileMode) error {
 		if dir.IsDir() {
 			return nil
 		}
-		return err
+		return &os.PathError{Op: "mkdir", Path: path, Err: syscall.ENOTDIR}
 	}
 
 	// Slow path: make sure parent exists and then call Mkdir for path.
@@ -91,39 +100,131 @@ func (s Fs) MkdirAll(path string, perm os.FileMode) error {
 }
 
 func (s Fs) Open(name string) (afero.File, error) {
-	return FileOpen(s.client, name)
+	return s.OpenFile(name, os.O_RDONLY, 0)
 }
 
+// OpenFile opens name with the os package flags. The sftp server of
+// github.com/pkg/sftp rejects writes to files opened with SSH_FXF_APPEND,
+// so O_APPEND is implemented by seeking to the end before every Write.
+// Data appended by another client between that seek and the write is
+// overwritten. The permissions of newly created files are set to perm
+// afterwards, so unlike with the os package, perm is not subject to the
+// umask.
 func (s Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
-	return nil, nil
+	fd, created, err := s.open(name, flag&^os.O_APPEND)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: name, Err: err}
+	}
+	if created {
+		if err := s.client.Chmod(name, perm); err != nil {
+			fd.Close()
+			return nil, err
+		}
+	}
+	return &File{client: s.client, fd: fd, append: flag&os.O_APPEND != 0}, nil
+}
+
+// open opens name and reports whether it created the file. With O_CREATE,
+// the file is first opened with O_EXCL, so the server decides whether it
+// was created. If it is removed before it is opened again without O_EXCL,
+// it is created by that second open and reported as existing.
+func (s Fs) open(name string, flag int) (fd *sftp.File, created bool, err error) {
+	if flag&os.O_CREATE == 0 {
+		fd, err = s.client.OpenFile(name, flag)
+		return fd, false, err
+	}
+	fd, err = s.client.OpenFile(name, flag|os.O_EXCL)
+	if err == nil {
+		return fd, true, nil
+	}
+	// The server does not tell why the open failed.
+	if _, serr := s.client.Lstat(name); serr != nil {
+		return nil, false, err
+	}
+	if flag&os.O_EXCL != 0 {
+		return nil, false, os.ErrExist
+	}
+	fd, err = s.client.OpenFile(name, flag)
+	return fd, false, err
 }
 
 func (s Fs) Remove(name string) error {
-	return s.client.Remove(name)
+	if err := s.client.Remove(name); err != nil {
+		return &os.PathError{Op: "remove", Path: name, Err: err}
+	}
+	return nil
 }
 
-func (s Fs) RemoveAll(path string) error {
-	// TODO have a look at os.RemoveAll
-	// https://github.com/golang/go/blob/master/src/os/path.go#L66
+// RemoveAll removes path and everything below it. Like os.RemoveAll, it
+// returns nil if path does not exist.
+func (s Fs) RemoveAll(p string) error {
+	fi, err := s.client.Lstat(p)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return &os.PathError{Op: "removeall", Path: p, Err: err}
+	}
+	if !fi.IsDir() {
+		return s.Remove(p)
+	}
+
+	entries, err := s.client.ReadDir(p)
+	if err != nil {
+		return &os.PathError{Op: "removeall", Path: p, Err: err}
+	}
+	for _, entry := range entries {
+		if err := s.RemoveAll(path.Join(p, entry.Name())); err != nil {
+			return err
+		}
+	}
+	if err := s.client.RemoveDirectory(p); err != nil && !os.IsNotExist(err) {
+		return &os.PathError{Op: "removeall", Path: p, Err: err}
+	}
 	return nil
 }
 
 func (s Fs) Rename(oldname, newname string) error {
-	return s.client.Rename(oldname, newname)
+	if err := s.client.Rename(oldname, newname); err != nil {
+		if _, serr := s.client.Lstat(oldname); os.IsNotExist(serr) {
+			err = os.ErrNotExist
+		}
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: err}
+	}
+	return nil
 }
 
 func (s Fs) Stat(name string) (os.FileInfo, error) {
-	return s.client.Stat(name)
+	fi, err := s.client.Stat(name)
+	if err != nil {
+		return nil, &os.PathError{Op: "stat", Path: name, Err: err}
+	}
+	return fi, nil
 }
 
 func (s Fs) Lstat(p string) (os.FileInfo, error) {
-	return s.client.Lstat(p)
+	fi, err := s.client.Lstat(p)
+	if err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: p, Err: err}
+	}
+	return fi, nil
+}
+
+func (s Fs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	fi, err := s.Lstat(name)
+	return fi, true, err
 }
 
 func (s Fs) Chmod(name string, mode os.FileMode) error {
-	return s.client.Chmod(name, mode)
+	if err := s.client.Chmod(name, mode); err != nil {
+		return &os.PathError{Op: "chmod", Path: name, Err: err}
+	}
+	return nil
 }
 
 func (s Fs) Chtimes(name string, atime time.Time, mtime time.Time) error {
-	return s.client.Chtimes(name, atime, mtime)
+	if err := s.client.Chtimes(name, atime, mtime); err != nil {
+		return &os.PathError{Op: "chtimes", Path: name, Err: err}
+	}
+	return nil
 }
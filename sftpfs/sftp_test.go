@@ -0,0 +1,173 @@
+package sftpfs
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"github.com/spf13/afero"
+	"github.com/spf13/afero/aferotest"
+)
+
+// newTestFs returns a Fs talking to an in-process sftp server, which serves
+// the local filesystem, over a net.Pipe.
+func newTestFs(t *testing.T) (afero.Fs, func()) {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+
+	server, err := sftp.NewServer(serverConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve()
+
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(client), func() {
+		client.Close()
+		server.Close()
+	}
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	t.Helper()
+	dir, err := afero.TempDir(afero.NewOsFs(), "", "sftpfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestConformance(t *testing.T) {
+	var cleanups []func()
+	defer func() {
+		for _, cleanup := range cleanups {
+			cleanup()
+		}
+	}()
+	aferotest.TestFs(t, func() afero.Fs {
+		fs, cleanup := newTestFs(t)
+		cleanups = append(cleanups, cleanup)
+		return fs
+	})
+}
+
+func TestOpenFile(t *testing.T) {
+	fs, cleanup := newTestFs(t)
+	defer cleanup()
+	dir, rm := tempDir(t)
+	defer rm()
+
+	name := filepath.Join(dir, "file")
+	f, err := fs.OpenFile(name, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("hello"))
+	f.Close()
+
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode() != 0600 {
+		t.Errorf("got mode %v, want %v", fi.Mode(), os.FileMode(0600))
+	}
+
+	f, err = fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte(","))
+	f.Seek(0, io.SeekStart)
+	f.Write([]byte(" world"))
+	f.Close()
+
+	data, err := afero.ReadFile(fs, name)
+	if err != nil || string(data) != "hello, world" {
+		t.Errorf("got %q, %v, want %q", data, err, "hello, world")
+	}
+	if fi, err := os.Stat(name); err != nil || fi.Mode() != 0600 {
+		t.Errorf("existing file: got mode %v, %v, want it unchanged", fi.Mode(), err)
+	}
+
+	_, err = fs.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if !os.IsExist(err) {
+		t.Errorf("O_EXCL: got %v, want an exist error", err)
+	}
+	_, err = fs.OpenFile(filepath.Join(dir, "missing"), os.O_RDONLY, 0)
+	if !os.IsNotExist(err) {
+		t.Errorf("missing file: got %v, want a not exist error", err)
+	}
+}
+
+func TestRemoveAll(t *testing.T) {
+	fs, cleanup := newTestFs(t)
+	defer cleanup()
+	dir, rm := tempDir(t)
+	defer rm()
+
+	root := filepath.Join(dir, "tree")
+	for _, name := range []string{"a", "b/c", "b/d/e", "b/d/f"} {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	os.Mkdir(filepath.Join(root, "empty"), 0755)
+	// links are removed, not followed
+	os.Symlink(dir, filepath.Join(root, "b", "link"))
+
+	if err := fs.RemoveAll(root); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(root); !os.IsNotExist(err) {
+		t.Errorf("tree still exists: %v", err)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("link target was removed: %v", err)
+	}
+	if err := fs.RemoveAll(root); err != nil {
+		t.Errorf("RemoveAll of a missing path: %v", err)
+	}
+}
+
+func TestLstatIfPossible(t *testing.T) {
+	fs, cleanup := newTestFs(t)
+	defer cleanup()
+	dir, rm := tempDir(t)
+	defer rm()
+
+	target := filepath.Join(dir, "target")
+	link := filepath.Join(dir, "link")
+	ioutil.WriteFile(target, []byte("target"), 0644)
+	if err := os.Symlink(target, link); err != nil {
+		t.Skip(err)
+	}
+
+	fi, lstatCalled, err := fs.(afero.Lstater).LstatIfPossible(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lstatCalled {
+		t.Error("Lstat was not called")
+	}
+	if fi.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("got mode %v, want a symbolic link", fi.Mode())
+	}
+
+	fi, err = fs.Stat(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode()&os.ModeSymlink != 0 {
+		t.Errorf("Stat did not follow the link")
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"syscall"
+	"testing"
+)
+
+func TestSymlinkIfPossible(t *testing.T) {
+	osFs := &OsFs{}
+	workDir, err := TempDir(osFs, "", "afero-symlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer osFs.RemoveAll(workDir)
+
+	memWorkDir := "/symlink"
+
+	// RegexpFs cannot create files which do not exist yet.
+	regexpSource := &MemMapFs{}
+	WriteFile(regexpSource, filepath.Join(memWorkDir, "file.txt"), nil, 0644)
+
+	fss := map[string]struct {
+		fs  Fs
+		dir string
+	}{
+		"OsFs":                   {osFs, workDir},
+		"MemMapFs":               {&MemMapFs{}, memWorkDir},
+		"BasePathFs(OsFs)":       {NewBasePathFs(osFs, workDir), "/os"},
+		"BasePathFs(MemMapFs)":   {NewBasePathFs(&MemMapFs{}, memWorkDir), "/"},
+		"CopyOnWriteFs":          {NewCopyOnWriteFs(&MemMapFs{}, &MemMapFs{}), memWorkDir},
+		"RegexpFs(MemMapFs)":     {NewRegexpFs(regexpSource, regexp.MustCompile(`\.txt$`)), memWorkDir},
+		"CopyOnWriteFs(OsFs)":    {NewCopyOnWriteFs(NewReadOnlyFs(osFs), osFs), filepath.Join(workDir, "cow")},
+		"BasePathFs(BasePathFs)": {NewBasePathFs(NewBasePathFs(&MemMapFs{}, "/a"), "/b"), "/c"},
+		"SecureBasePathFs(OsFs)": {NewSecureBasePathFs(osFs, workDir), "/secure"},
+	}
+
+	for name, tc := range fss {
+		if err := tc.fs.MkdirAll(tc.dir, 0777); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		file := filepath.Join(tc.dir, "file.txt")
+		link := filepath.Join(tc.dir, "link.txt")
+		if err := WriteFile(tc.fs, file, []byte("Hi, Afero!"), 0644); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		if err := tc.fs.(Linker).SymlinkIfPossible("file.txt", link); err != nil {
+			t.Fatalf("%s: SymlinkIfPossible failed: %s", name, err)
+		}
+		if err := tc.fs.(Linker).SymlinkIfPossible("file.txt", link); err == nil || !os.IsExist(err) {
+			t.Errorf("%s: expected link to exist, got %v", name, err)
+		}
+
+		target, err := tc.fs.(LinkReader).ReadlinkIfPossible(link)
+		if err != nil {
+			t.Fatalf("%s: ReadlinkIfPossible failed: %s", name, err)
+		}
+		if target != "file.txt" {
+			t.Errorf("%s: expected target file.txt, got %s", name, target)
+		}
+
+		fi, _, err := tc.fs.(Lstater).LstatIfPossible(link)
+		if err != nil {
+			t.Fatalf("%s: LstatIfPossible failed: %s", name, err)
+		}
+		if fi.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("%s: expected a symlink, got mode %s", name, fi.Mode())
+		}
+
+		content, err := ReadFile(tc.fs, link)
+		if err != nil {
+			t.Fatalf("%s: reading through link failed: %s", name, err)
+		}
+		if string(content) != "Hi, Afero!" {
+			t.Errorf("%s: read %q through link", name, content)
+		}
+
+		if _, err := tc.fs.(LinkReader).ReadlinkIfPossible(file); err == nil {
+			t.Errorf("%s: expected error reading a regular file as link", name)
+		}
+	}
+}
+
+func TestSymlinkReadOnly(t *testing.T) {
+	memFs := &MemMapFs{}
+	WriteFile(memFs, "/file.txt", []byte("content"), 0644)
+	memFs.SymlinkIfPossible("/file.txt", "/link.txt")
+
+	roFs := NewReadOnlyFs(memFs)
+	if err := roFs.(Linker).SymlinkIfPossible("/file.txt", "/other.txt"); err != syscall.EPERM {
+		t.Errorf("expected EPERM, got %v", err)
+	}
+	target, err := roFs.(LinkReader).ReadlinkIfPossible("/link.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "/file.txt" {
+		t.Errorf("expected /file.txt, got %s", target)
+	}
+}
+
+func TestSymlinkNotSupported(t *testing.T) {
+	bp := NewBasePathFs(nonLinkingFs{NewMemMapFs()}, "/base")
+
+	err := bp.(Linker).SymlinkIfPossible("a", "b")
+	if lerr, ok := err.(*os.LinkError); !ok || lerr.Err != ErrNoSymlink {
+		t.Errorf("expected ErrNoSymlink, got %v", err)
+	}
+	_, err = bp.(LinkReader).ReadlinkIfPossible("a")
+	if perr, ok := err.(*os.PathError); !ok || perr.Err != ErrNoReadlink {
+		t.Errorf("expected ErrNoReadlink, got %v", err)
+	}
+}
+
+func TestBasePathSymlinkAbsolute(t *testing.T) {
+	memFs := &MemMapFs{}
+	bp := NewBasePathFs(memFs, "/base")
+	WriteFile(bp, "/dir/file.txt", []byte("content"), 0644)
+
+	if err := bp.(Linker).SymlinkIfPossible("/dir/file.txt", "/link.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	target, _ := memFs.ReadlinkIfPossible("/base/link.txt")
+	if target != filepath.Clean("/base/dir/file.txt") {
+		t.Errorf("expected the real path as target, got %s", target)
+	}
+	target, _ = bp.(LinkReader).ReadlinkIfPossible("/link.txt")
+	if target != filepath.Clean("/dir/file.txt") {
+		t.Errorf("realpath leaked: %s", target)
+	}
+	if content, _ := ReadFile(bp, "/link.txt"); string(content) != "content" {
+		t.Errorf("read %q through link", content)
+	}
+}
+
+func TestMemMapFsSymlinkResolution(t *testing.T) {
+	fs := &MemMapFs{}
+	fs.MkdirAll("/real/dir", 0755)
+	WriteFile(fs, "/real/dir/file.txt", []byte("content"), 0644)
+
+	fs.SymlinkIfPossible("/real", "/abs")
+	fs.SymlinkIfPossible("real/dir", "/rel")
+	fs.SymlinkIfPossible("../rel/file.txt", "/real/chained")
+
+	for _, name := range []string{"/abs/dir/file.txt", "/rel/file.txt", "/real/chained", "/abs/chained"} {
+		content, err := ReadFile(fs, name)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if string(content) != "content" {
+			t.Errorf("%s: read %q", name, content)
+		}
+	}
+
+	// Like on the os filesystem, the names are the ones given.
+	if fi, err := fs.Stat("/real/chained"); err != nil || fi.Name() != "chained" {
+		t.Errorf("stat through link: %v, %v", fi, err)
+	}
+	f, err := fs.Open("/rel/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Name() != normalizePath("/rel/file.txt") {
+		t.Errorf("opened through link: got name %s", f.Name())
+	}
+	if fi, err := f.Stat(); err != nil || fi.Name() != "file.txt" || fi.Size() != 7 {
+		t.Errorf("stat of file opened through link: %v, %v", fi, err)
+	}
+	f.Close()
+	if f, err := fs.Open("/real/chained"); err != nil || f.Name() != normalizePath("/real/chained") {
+		t.Errorf("opened through link: %v, %v", f, err)
+	}
+
+	// Files created through a link end up in the link target.
+	if err := WriteFile(fs, "/abs/dir/new.txt", []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fs.Stat("/real/dir/new.txt"); err != nil {
+		t.Error(err)
+	}
+
+	names, err := readDirNames(fs, "/rel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "file.txt" || names[1] != "new.txt" {
+		t.Errorf("unexpected listing through link: %v", names)
+	}
+
+	// Removing a link leaves its target alone.
+	if err := fs.Remove("/abs"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := fs.LstatIfPossible("/abs"); !os.IsNotExist(err) {
+		t.Errorf("expected link to be removed, got %v", err)
+	}
+	if _, err := fs.Stat("/real/dir/file.txt"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMemMapFsSymlinkDangling(t *testing.T) {
+	fs := &MemMapFs{}
+	fs.SymlinkIfPossible("/missing.txt", "/dangling")
+
+	if _, err := fs.Stat("/dangling"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+	if _, _, err := fs.LstatIfPossible("/dangling"); err != nil {
+		t.Errorf("expected link to be there, got %v", err)
+	}
+
+	// Like on the os filesystem, creating a file through a dangling link
+	// creates the target.
+	if err := WriteFile(fs, "/dangling", []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := ReadFile(fs, "/missing.txt"); string(content) != "content" {
+		t.Errorf("read %q", content)
+	}
+}
+
+func TestMemMapFsSymlinkLoop(t *testing.T) {
+	fs := &MemMapFs{}
+	fs.SymlinkIfPossible("/b", "/a")
+	fs.SymlinkIfPossible("/a", "/b")
+
+	_, err := fs.Stat("/a")
+	if perr, ok := err.(*os.PathError); !ok || perr.Err != syscall.ELOOP {
+		t.Errorf("expected ELOOP, got %v", err)
+	}
+	_, err = fs.Open("/a/file")
+	if perr, ok := err.(*os.PathError); !ok || perr.Err != syscall.ELOOP {
+		t.Errorf("expected ELOOP, got %v", err)
+	}
+	if _, _, err := fs.LstatIfPossible("/a"); err != nil {
+		t.Errorf("expected Lstat of a looping link to succeed, got %v", err)
+	}
+}
+
+func TestMemMapFsSymlinkReaddir(t *testing.T) {
+	fs := &MemMapFs{}
+	fs.MkdirAll("/dir", 0755)
+	fs.SymlinkIfPossible("somewhere", "/dir/link")
+
+	fis, err := ReadDir(fs, "/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(fis))
+	}
+	if fis[0].Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected a symlink, got mode %s", fis[0].Mode())
+	}
+	if fis[0].Size() != int64(len("somewhere")) {
+		t.Errorf("expected the size to be the target length, got %d", fis[0].Size())
+	}
+}
+
+// nonLinkingFs hides the optional interfaces of the wrapped Fs.
+type nonLinkingFs struct {
+	Fs
+}
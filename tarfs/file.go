@@ -0,0 +1,181 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tarfs
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// File is a file or directory of a tar archive.
+type File struct {
+	fs     *Fs
+	name   string // as opened, maybe through symbolic links
+	path   string // of entry, with the symbolic links resolved
+	entry  *entry // nil for synthesized directories
+	isdir  bool
+	closed bool
+
+	data *io.SectionReader // content of regular files and hard links
+
+	readdir []os.FileInfo // directory listing, read on first use
+	diroff  int
+}
+
+func (f *File) check(op string) error {
+	if f.closed {
+		return afero.ErrFileClosed
+	}
+	if f.isdir {
+		return &os.PathError{Op: op, Path: f.name, Err: syscall.EISDIR}
+	}
+	if f.data == nil {
+		// devices, fifos and the like have no content
+		return io.EOF
+	}
+	return nil
+}
+
+func (f *File) Close() error {
+	if f.closed {
+		return afero.ErrFileClosed
+	}
+	f.closed = true
+	return nil
+}
+
+func (f *File) Read(p []byte) (n int, err error) {
+	if err := f.check("read"); err != nil {
+		return 0, err
+	}
+	return f.data.Read(p)
+}
+
+func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
+	if err := f.check("read"); err != nil {
+		return 0, err
+	}
+	return f.data.ReadAt(p, off)
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if err := f.check("seek"); err != nil {
+		if err == io.EOF {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return f.data.Seek(offset, whence)
+}
+
+func (f *File) Write(p []byte) (n int, err error) { return 0, syscall.EPERM }
+
+func (f *File) WriteAt(p []byte, off int64) (n int, err error) { return 0, syscall.EPERM }
+
+func (f *File) Name() string { return f.name }
+
+func (f *File) Readdir(count int) (fi []os.FileInfo, err error) {
+	if f.closed {
+		return nil, afero.ErrFileClosed
+	}
+	if !f.isdir {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+	if f.readdir == nil {
+		f.readdir = []os.FileInfo{}
+		for base, e := range f.fs.files[f.path] {
+			f.readdir = append(f.readdir, fileInfo(base, e))
+		}
+		sort.Slice(f.readdir, func(i, j int) bool { return f.readdir[i].Name() < f.readdir[j].Name() })
+	}
+
+	rest := f.readdir[f.diroff:]
+	if count <= 0 {
+		f.diroff = len(f.readdir)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(rest) {
+		count = len(rest)
+	}
+	f.diroff += count
+	return rest[:count], nil
+}
+
+func (f *File) Readdirnames(count int) (names []string, err error) {
+	fi, err := f.Readdir(count)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fi {
+		names = append(names, f.Name())
+	}
+	return names, nil
+}
+
+func (f *File) Stat() (os.FileInfo, error) {
+	return fileInfo(filepath.Base(f.name), f.entry), nil
+}
+
+func (f *File) Sync() error { return nil }
+
+func (f *File) Truncate(size int64) error { return syscall.EPERM }
+
+func (f *File) WriteString(s string) (ret int, err error) { return 0, syscall.EPERM }
+
+// fileInfo describes the archive entry e under the given name, or a
+// synthesized directory if e is nil. Hard links are described by the header
+// of the file they link to.
+func fileInfo(name string, e *entry) os.FileInfo {
+	if e == nil {
+		return dirInfo(name)
+	}
+	h := e.h
+	if e.link != nil {
+		h = e.link.h
+	}
+	return headerInfo{name: name, h: h}
+}
+
+// headerInfo describes a file by its tar header.
+type headerInfo struct {
+	name string
+	h    *tar.Header
+}
+
+func (i headerInfo) Name() string       { return i.name }
+func (i headerInfo) Size() int64        { return i.h.FileInfo().Size() }
+func (i headerInfo) Mode() os.FileMode  { return i.h.FileInfo().Mode() }
+func (i headerInfo) ModTime() time.Time { return i.h.ModTime }
+func (i headerInfo) IsDir() bool        { return i.h.FileInfo().IsDir() }
+func (i headerInfo) Sys() interface{}   { return i.h }
+
+// dirInfo describes a directory without an entry in the archive.
+type dirInfo string
+
+func (d dirInfo) Name() string       { return string(d) }
+func (d dirInfo) Size() int64        { return 0 }
+func (d dirInfo) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (d dirInfo) ModTime() time.Time { return time.Time{} }
+func (d dirInfo) IsDir() bool        { return true }
+func (d dirInfo) Sys() interface{}   { return nil }
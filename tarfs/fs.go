@@ -0,0 +1,385 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package tarfs provides a read only afero.Fs serving the content of a tar
+// archive.
+package tarfs
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+var _ afero.Symlinker = (*Fs)(nil)
+
+// maxSymlinkHops is the number of symbolic links followed while resolving a
+// single path before giving up with ELOOP.
+const maxSymlinkHops = 40
+
+// Fs is a read only afero.Fs implementation on top of a tar archive.
+//
+// The archive is indexed once by New. Modes, modification times, symbolic
+// links and hard links are taken from the headers, and directories which are
+// only implied by the names of the archived files are synthesized. Like in a
+// container image layer, absolute link targets refer to the root of the
+// archive. All paths are relative to the root of the archive, so "a/b" and
+// "/a/b" name the same file.
+type Fs struct {
+	ra    io.ReaderAt                  // the archive if contents are read lazily
+	files map[string]map[string]*entry // dir -> base name -> entry, nil for synthesized dirs
+	root  *entry
+}
+
+// entry is a file of the archive. The content of regular files is either
+// buffered in data or found at offset in the archive.
+type entry struct {
+	h      *tar.Header
+	data   []byte
+	offset int64
+	lazy   bool
+	link   *entry // target of a hard link
+}
+
+// New indexes the tar archive read from r, which may be gzip compressed.
+//
+// If r is an io.ReaderAt and the archive is not compressed, only the headers
+// are read up front and file contents are read from r on demand, so r must
+// stay usable for as long as the Fs is. Otherwise the file contents are kept
+// in memory.
+func New(r io.Reader) (afero.Fs, error) {
+	fs := &Fs{files: make(map[string]map[string]*entry)}
+	fs.files[string(filepath.Separator)] = make(map[string]*entry)
+
+	var (
+		tr     *tar.Reader
+		cursor *readerAtCursor
+	)
+	if ra, ok := r.(io.ReaderAt); ok && !isGzipAt(ra) {
+		fs.ra = ra
+		cursor = &readerAtCursor{ra: ra}
+		tr = tar.NewReader(cursor)
+	} else {
+		br := bufio.NewReader(r)
+		var tarStream io.Reader = br
+		if magic, err := br.Peek(2); err == nil && isGzip(magic) {
+			gzr, err := gzip.NewReader(br)
+			if err != nil {
+				return nil, err
+			}
+			defer gzr.Close()
+			tarStream = gzr
+		}
+		tr = tar.NewReader(tarStream)
+	}
+
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		e := &entry{h: h}
+		switch h.Typeflag {
+		case tar.TypeLink:
+			dir, base := splitpath(h.Linkname)
+			if target := fs.files[dir][base]; target != nil {
+				if target.link != nil {
+					target = target.link
+				}
+				e.link = target
+			}
+		case tar.TypeReg, tar.TypeRegA, tar.TypeGNUSparse:
+			if cursor != nil && !isSparse(h) {
+				e.offset = cursor.off
+				e.lazy = true
+			} else {
+				if e.data, err = ioutil.ReadAll(tr); err != nil {
+					return nil, err
+				}
+			}
+		}
+		fs.add(e)
+	}
+
+	fs.synthesizeDirs()
+	return fs, nil
+}
+
+func (fs *Fs) add(e *entry) {
+	d, f := splitpath(e.h.Name)
+	if f == "" {
+		// an entry for the root directory
+		fs.root = e
+		return
+	}
+	if _, ok := fs.files[d]; !ok {
+		fs.files[d] = make(map[string]*entry)
+	}
+	fs.files[d][f] = e
+	if e.h.Typeflag == tar.TypeDir {
+		dirname := filepath.Join(d, f)
+		if _, ok := fs.files[dirname]; !ok {
+			fs.files[dirname] = make(map[string]*entry)
+		}
+	}
+}
+
+// synthesizeDirs adds the directories which have no entry of their own.
+func (fs *Fs) synthesizeDirs() {
+	dirs := make([]string, 0, len(fs.files))
+	for d := range fs.files {
+		dirs = append(dirs, d)
+	}
+	for _, d := range dirs {
+		for d != string(filepath.Separator) {
+			parent, base := filepath.Split(d)
+			parent = filepath.Clean(parent)
+			if _, ok := fs.files[parent]; !ok {
+				fs.files[parent] = make(map[string]*entry)
+			}
+			if _, ok := fs.files[parent][base]; !ok {
+				fs.files[parent][base] = nil
+			}
+			d = parent
+		}
+	}
+}
+
+func isGzip(magic []byte) bool {
+	return len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b
+}
+
+func isGzipAt(ra io.ReaderAt) bool {
+	magic := make([]byte, 2)
+	if _, err := ra.ReadAt(magic, 0); err != nil {
+		return false
+	}
+	return isGzip(magic)
+}
+
+// isSparse reports whether the data of h is not stored as one block in the
+// archive.
+func isSparse(h *tar.Header) bool {
+	if h.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range h.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
+
+// readerAtCursor reads an io.ReaderAt sequentially, keeping track of the
+// offset so the position of the file contents is known.
+type readerAtCursor struct {
+	ra  io.ReaderAt
+	off int64
+}
+
+func (c *readerAtCursor) Read(p []byte) (int, error) {
+	n, err := c.ra.ReadAt(p, c.off)
+	c.off += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (c *readerAtCursor) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += c.off
+	default:
+		return 0, syscall.EINVAL
+	}
+	if offset < 0 {
+		return 0, syscall.EINVAL
+	}
+	c.off = offset
+	return offset, nil
+}
+
+// splitpath returns the cleaned directory and base name of a path.
+func splitpath(name string) (dir, file string) {
+	name = cleanPath(filepath.FromSlash(name))
+	dir, file = filepath.Split(name)
+	return filepath.Clean(dir), file
+}
+
+// cleanPath turns a file name into the rooted form used as index.
+func cleanPath(name string) string {
+	return filepath.Clean(string(filepath.Separator) + name)
+}
+
+// lookup returns the entry of the cleaned path name. ok is false if there is
+// none, e is nil for synthesized directories.
+func (fs *Fs) lookup(name string) (e *entry, ok bool) {
+	if name == string(filepath.Separator) {
+		return fs.root, true
+	}
+	d, f := filepath.Split(name)
+	e, ok = fs.files[filepath.Clean(d)][f]
+	return e, ok
+}
+
+// resolve follows the symbolic links in name. The last element is only
+// followed if followLast is set.
+func (fs *Fs) resolve(name string, followLast bool) (string, error) {
+	name = cleanPath(name)
+	for hops := 0; ; hops++ {
+		resolved, followed := fs.followLink(name, followLast)
+		if !followed {
+			return name, nil
+		}
+		if hops == maxSymlinkHops {
+			return name, syscall.ELOOP
+		}
+		name = resolved
+	}
+}
+
+// followLink replaces the first symbolic link found in name by its target
+// and reports whether there was one to replace.
+func (fs *Fs) followLink(name string, followLast bool) (string, bool) {
+	for i := 1; i <= len(name); i++ {
+		if i < len(name) && !os.IsPathSeparator(name[i]) {
+			continue
+		}
+		if i == len(name) && !followLast {
+			break
+		}
+		e, _ := fs.lookup(name[:i])
+		if e == nil || e.h.Typeflag != tar.TypeSymlink {
+			continue
+		}
+		target := filepath.FromSlash(e.h.Linkname)
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(name[:i]), target)
+		}
+		return cleanPath(target + name[i:]), true
+	}
+	return name, false
+}
+
+func (fs *Fs) open(op, name string, followLast bool) (*File, error) {
+	opened := cleanPath(name)
+	name, err := fs.resolve(name, followLast)
+	if err != nil {
+		return nil, &os.PathError{Op: op, Path: name, Err: err}
+	}
+	e, ok := fs.lookup(name)
+	if !ok {
+		return nil, &os.PathError{Op: op, Path: name, Err: syscall.ENOENT}
+	}
+	f := &File{fs: fs, name: opened, path: name, entry: e}
+	if e == nil || e.h.Typeflag == tar.TypeDir {
+		f.isdir = true
+		return f, nil
+	}
+	content := e
+	if e.link != nil {
+		content = e.link
+	} else if e.h.Typeflag == tar.TypeLink {
+		return nil, &os.PathError{Op: op, Path: name, Err: syscall.ENOENT}
+	}
+	if content.lazy {
+		f.data = io.NewSectionReader(fs.ra, content.offset, content.h.Size)
+	} else {
+		f.data = io.NewSectionReader(bytes.NewReader(content.data), 0, int64(len(content.data)))
+	}
+	return f, nil
+}
+
+func (fs *Fs) Create(name string) (afero.File, error) { return nil, syscall.EPERM }
+
+func (fs *Fs) Mkdir(name string, mode os.FileMode) error { return syscall.EPERM }
+
+func (fs *Fs) MkdirAll(path string, perm os.FileMode) error { return syscall.EPERM }
+
+func (fs *Fs) Open(name string) (afero.File, error) {
+	f, err := fs.open("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (fs *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|syscall.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, syscall.EPERM
+	}
+	return fs.Open(name)
+}
+
+func (fs *Fs) Remove(name string) error { return syscall.EPERM }
+
+func (fs *Fs) RemoveAll(path string) error { return syscall.EPERM }
+
+func (fs *Fs) Rename(oldname, newname string) error { return syscall.EPERM }
+
+func (fs *Fs) Stat(name string) (os.FileInfo, error) {
+	f, err := fs.open("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return f.Stat()
+}
+
+func (fs *Fs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	f, err := fs.open("lstat", name, false)
+	if err != nil {
+		return nil, true, err
+	}
+	fi, err := f.Stat()
+	return fi, true, err
+}
+
+func (fs *Fs) SymlinkIfPossible(oldname, newname string) error { return syscall.EPERM }
+
+func (fs *Fs) ReadlinkIfPossible(name string) (string, error) {
+	name, err := fs.resolve(name, false)
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: err}
+	}
+	e, ok := fs.lookup(name)
+	if !ok {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.ENOENT}
+	}
+	if e == nil || e.h.Typeflag != tar.TypeSymlink {
+		return "", &os.PathError{Op: "readlink", Path: name, Err: syscall.EINVAL}
+	}
+	return e.h.Linkname, nil
+}
+
+func (fs *Fs) Name() string { return "tarfs" }
+
+func (fs *Fs) Chmod(name string, mode os.FileMode) error { return syscall.EPERM }
+
+func (fs *Fs) Chtimes(name string, atime time.Time, mtime time.Time) error { return syscall.EPERM }
@@ -0,0 +1,366 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/afero/aferotest"
+)
+
+var (
+	testTime    = time.Date(2019, 1, 30, 12, 0, 0, 0, time.UTC)
+	testContent = strings.Repeat("Lorem ipsum dolor sit amet. ", 100)
+	bigContent  = bytes.Repeat([]byte{'x'}, 1<<20)
+)
+
+// newTestTar returns the bytes of an archive laid out like a container
+// image layer.
+func newTestTar(t *testing.T) []byte {
+	buf := new(bytes.Buffer)
+	w := tar.NewWriter(buf)
+
+	add := func(h *tar.Header, content []byte) {
+		if h.ModTime.IsZero() {
+			h.ModTime = testTime
+		}
+		h.Size = int64(len(content))
+		if err := w.WriteHeader(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	add(&tar.Header{Name: "./", Typeflag: tar.TypeDir, Mode: 0755}, nil)
+	add(&tar.Header{Name: "./etc/", Typeflag: tar.TypeDir, Mode: 0750, ModTime: testTime.Add(time.Hour)}, nil)
+	add(&tar.Header{Name: "./etc/motd", Typeflag: tar.TypeReg, Mode: 0644}, []byte(testContent))
+	add(&tar.Header{Name: "./etc/secret", Typeflag: tar.TypeReg, Mode: 0600}, []byte("secret"))
+	add(&tar.Header{Name: "./etc/motd.link", Typeflag: tar.TypeSymlink, Linkname: "motd"}, nil)
+	add(&tar.Header{Name: "./usr/share/big", Typeflag: tar.TypeReg, Mode: 0444}, bigContent)
+	add(&tar.Header{Name: "./usr/share/hard", Typeflag: tar.TypeLink, Linkname: "./usr/share/big"}, nil)
+	add(&tar.Header{Name: "./usr/local/bin/tool", Typeflag: tar.TypeReg, Mode: 0755}, []byte("#!/bin/sh"))
+	add(&tar.Header{Name: "./usr/bin", Typeflag: tar.TypeSymlink, Linkname: "/usr/local/bin"}, nil)
+	add(&tar.Header{Name: "./loop1", Typeflag: tar.TypeSymlink, Linkname: "loop2"}, nil)
+	add(&tar.Header{Name: "./loop2", Typeflag: tar.TypeSymlink, Linkname: "loop1"}, nil)
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func gzipped(t *testing.T, b []byte) []byte {
+	buf := new(bytes.Buffer)
+	w := gzip.NewWriter(buf)
+	w.Write(b)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// countingReaderAt counts the bytes read from it.
+type countingReaderAt struct {
+	*bytes.Reader
+	n int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.Reader.ReadAt(p, off)
+	c.n += int64(n)
+	return n, err
+}
+
+func newTestFss(t *testing.T) map[string]afero.Fs {
+	archive := newTestTar(t)
+	fss := make(map[string]afero.Fs)
+	for name, r := range map[string]io.Reader{
+		"lazy":        bytes.NewReader(archive),
+		"stream":      bytes.NewBuffer(archive),
+		"gzip":        bytes.NewBuffer(gzipped(t, archive)),
+		"gzip reader": bytes.NewReader(gzipped(t, archive)),
+	} {
+		fs, err := New(r)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		fss[name] = fs
+	}
+	return fss
+}
+
+func TestTarFSHeaders(t *testing.T) {
+	for name, fs := range newTestFss(t) {
+		fi, err := fs.Stat("/etc")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if fi.Mode() != os.ModeDir|0750 || !fi.ModTime().Equal(testTime.Add(time.Hour)) {
+			t.Errorf("%s: unexpected /etc %s %s", name, fi.Mode(), fi.ModTime())
+		}
+
+		fi, err = fs.Stat("etc/secret")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if fi.Name() != "secret" || fi.Mode() != 0600 || fi.Size() != 6 || !fi.ModTime().Equal(testTime) {
+			t.Errorf("%s: unexpected secret %s %s %d %s", name, fi.Name(), fi.Mode(), fi.Size(), fi.ModTime())
+		}
+		if _, ok := fi.Sys().(*tar.Header); !ok {
+			t.Errorf("%s: expected the tar header from Sys(), got %T", name, fi.Sys())
+		}
+
+		for _, dir := range []string{"/", "/usr", "/usr/share", "/usr/local/bin"} {
+			fi, err := fs.Stat(dir)
+			if err != nil || !fi.IsDir() {
+				t.Errorf("%s: expected %s to be a directory, got %v", name, dir, err)
+			}
+		}
+
+		if _, err := fs.Stat("/etc/missing"); !os.IsNotExist(err) {
+			t.Errorf("%s: expected not exist, got %v", name, err)
+		}
+	}
+}
+
+func TestTarFSContent(t *testing.T) {
+	for name, fs := range newTestFss(t) {
+		content, err := afero.ReadFile(fs, "/etc/motd")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if string(content) != testContent {
+			t.Errorf("%s: unexpected content", name)
+		}
+
+		content, err = afero.ReadFile(fs, "/usr/share/hard")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !bytes.Equal(content, bigContent) {
+			t.Errorf("%s: hard link has unexpected content", name)
+		}
+		fi, _ := fs.Stat("/usr/share/hard")
+		if fi.Name() != "hard" || fi.Size() != int64(len(bigContent)) || fi.Mode() != 0444 {
+			t.Errorf("%s: unexpected hard link %s %d %s", name, fi.Name(), fi.Size(), fi.Mode())
+		}
+
+		f, err := fs.Open("/etc/motd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 11)
+		if _, err := f.ReadAt(buf, 28*50); err != nil || string(buf) != "Lorem ipsum" {
+			t.Errorf("%s: ReadAt = %q, %v", name, buf, err)
+		}
+		if _, err := f.Seek(-6, io.SeekEnd); err != nil {
+			t.Fatal(err)
+		}
+		rest, _ := ioutil.ReadAll(f)
+		if string(rest) != "amet. " {
+			t.Errorf("%s: read %q after Seek", name, rest)
+		}
+		if _, err := f.Write([]byte("x")); err != syscall.EPERM {
+			t.Errorf("%s: expected EPERM, got %v", name, err)
+		}
+		f.Close()
+		if _, err := f.Read(buf); err != afero.ErrFileClosed {
+			t.Errorf("%s: expected ErrFileClosed, got %v", name, err)
+		}
+	}
+}
+
+func TestTarFSSymlinks(t *testing.T) {
+	for name, fs := range newTestFss(t) {
+		content, err := afero.ReadFile(fs, "/etc/motd.link")
+		if err != nil || string(content) != testContent {
+			t.Errorf("%s: reading through link failed: %v", name, err)
+		}
+
+		// absolute links are relative to the root of the archive
+		content, err = afero.ReadFile(fs, "/usr/bin/tool")
+		if err != nil || string(content) != "#!/bin/sh" {
+			t.Errorf("%s: reading through directory link failed: %v", name, err)
+		}
+
+		lfs := fs.(afero.Symlinker)
+		fi, lstat, err := lfs.LstatIfPossible("/usr/bin")
+		if err != nil || !lstat || fi.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("%s: expected symlink from Lstat, got %v, %v", name, fi, err)
+		}
+		fi, _ = fs.Stat("/usr/bin")
+		if !fi.IsDir() || fi.Name() != "bin" {
+			t.Errorf("%s: expected Stat to follow the link, got %v", name, fi)
+		}
+		f, err := fs.Open("/etc/motd.link")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi, _ := f.Stat(); f.Name() != filepath.FromSlash("/etc/motd.link") || fi.Name() != "motd.link" {
+			t.Errorf("%s: opened through link as %s, %s", name, f.Name(), fi.Name())
+		}
+		f.Close()
+		if names, err := afero.ReadDir(fs, "/usr/bin"); err != nil || len(names) == 0 {
+			t.Errorf("%s: listing through link: %v, %v", name, names, err)
+		}
+
+		target, err := lfs.ReadlinkIfPossible("/etc/motd.link")
+		if err != nil || target != "motd" {
+			t.Errorf("%s: Readlink = %s, %v", name, target, err)
+		}
+		if _, err := lfs.ReadlinkIfPossible("/etc/motd"); err == nil {
+			t.Errorf("%s: expected error reading a file as link", name)
+		}
+
+		_, err = fs.Open("/loop1")
+		if perr, ok := err.(*os.PathError); !ok || perr.Err != syscall.ELOOP {
+			t.Errorf("%s: expected ELOOP, got %v", name, err)
+		}
+
+		if err := lfs.SymlinkIfPossible("a", "b"); err != syscall.EPERM {
+			t.Errorf("%s: expected EPERM, got %v", name, err)
+		}
+	}
+}
+
+func TestTarFSLazy(t *testing.T) {
+	archive := newTestTar(t)
+	ra := &countingReaderAt{Reader: bytes.NewReader(archive)}
+	fs, err := New(ra)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ra.n >= int64(len(bigContent)) {
+		t.Errorf("expected the contents not to be read while indexing, read %d bytes", ra.n)
+	}
+
+	ra.n = 0
+	f, err := fs.Open("/usr/share/big")
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 10)
+	if _, err := f.ReadAt(buf, 1000); err != nil {
+		t.Fatal(err)
+	}
+	if ra.n != 10 {
+		t.Errorf("expected to read 10 bytes from the archive, read %d", ra.n)
+	}
+}
+
+func TestTarFSReaddir(t *testing.T) {
+	fs := newTestFss(t)["lazy"]
+
+	names, err := afero.ReadDir(fs, "/etc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, fi := range names {
+		got = append(got, fi.Name())
+	}
+	if !reflect.DeepEqual(got, []string{"motd", "motd.link", "secret"}) {
+		t.Errorf("unexpected listing %v", got)
+	}
+	if names[1].Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected motd.link to be listed as symlink, got %s", names[1].Mode())
+	}
+
+	f, _ := fs.Open("/")
+	defer f.Close()
+	var paged []string
+	for {
+		n, err := f.Readdirnames(2)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		paged = append(paged, n...)
+	}
+	if !reflect.DeepEqual(paged, []string{"etc", "loop1", "loop2", "usr"}) {
+		t.Errorf("unexpected paged listing %v", paged)
+	}
+}
+
+func TestTarFSWalkGlob(t *testing.T) {
+	fs := newTestFss(t)["gzip"]
+
+	var files []string
+	err := afero.Walk(fs, "/usr", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(path))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"/usr", "/usr/bin", "/usr/local", "/usr/local/bin", "/usr/local/bin/tool",
+		"/usr/share", "/usr/share/big", "/usr/share/hard",
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+
+	matches, err := afero.Glob(fs, filepath.FromSlash("/etc/m*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range matches {
+		matches[i] = filepath.ToSlash(matches[i])
+	}
+	if !reflect.DeepEqual(matches, []string{"/etc/motd", "/etc/motd.link"}) {
+		t.Errorf("unexpected matches %v", matches)
+	}
+}
+
+func TestTarFSReadOnly(t *testing.T) {
+	fs := newTestFss(t)["lazy"]
+
+	if _, err := fs.Create("/new"); err != syscall.EPERM {
+		t.Errorf("Create: expected EPERM, got %v", err)
+	}
+	if _, err := fs.OpenFile("/etc/motd", os.O_WRONLY, 0); err != syscall.EPERM {
+		t.Errorf("OpenFile: expected EPERM, got %v", err)
+	}
+	if err := fs.Chmod("/etc/motd", 0777); err != syscall.EPERM {
+		t.Errorf("Chmod: expected EPERM, got %v", err)
+	}
+}
+
+func TestTarFSCorrupt(t *testing.T) {
+	archive := newTestTar(t)
+	if _, err := New(bytes.NewReader(archive[:700])); err == nil {
+		t.Error("expected error for a truncated archive")
+	}
+}
+
+func TestConformance(t *testing.T) {
+	aferotest.TestReadOnlyFs(t, func(base afero.Fs) afero.Fs {
+		var buf bytes.Buffer
+		if err := afero.ArchiveTar(base, "/", &buf, nil); err != nil {
+			t.Fatal(err)
+		}
+		fs, err := New(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fs
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"syscall"
 )
 
@@ -14,7 +16,7 @@ import (
 // The calls to
 // Readdir() and Readdirnames() merge the file os.FileInfo / names from the
 // base and the overlay - for files present in both layers, only those
-// from the overlay will be used.
+// from the overlay will be used. The merged entries are sorted by name.
 //
 // When opening files for writing (Create() / OpenFile() with the right flags)
 // the operations will be done in both layers, starting with the overlay. A
@@ -26,6 +28,9 @@ type UnionFile struct {
 	Merger DirsMerger
 	off    int
 	files  []os.FileInfo
+
+	// whiteouts makes Readdir apply the whiteouts of a CopyOnWriteFs
+	whiteouts bool
 }
 
 func (f *UnionFile) Close() error {
@@ -126,7 +131,8 @@ func (f *UnionFile) Name() string {
 
 // DirsMerger is how UnionFile weaves two directories together.
 // It takes the FileInfo slices from the layer and the base and returns a
-// single view.
+// single view, which is then sorted by name. The merger of the directories of
+// a CopyOnWriteFs or a CacheOnReadFs is set with their SetMerger method.
 type DirsMerger func(lofi, bofi []os.FileInfo) ([]os.FileInfo, error)
 
 var defaultUnionMergeDirsFn = func(lofi, bofi []os.FileInfo) ([]os.FileInfo, error) {
@@ -163,7 +169,7 @@ func (f *UnionFile) Readdir(c int) (ofi []os.FileInfo, err error) {
 		merge = defaultUnionMergeDirsFn
 	}
 
-	if f.off == 0 {
+	if f.files == nil {
 		var lfi []os.FileInfo
 		if f.Layer != nil {
 			lfi, err = f.Layer.Readdir(-1)
@@ -180,27 +186,64 @@ func (f *UnionFile) Readdir(c int) (ofi []os.FileInfo, err error) {
 			}
 
 		}
+		if f.whiteouts {
+			lfi, bfi = applyWhiteouts(lfi, bfi)
+		}
 		merged, err := merge(lfi, bfi)
 		if err != nil {
 			return nil, err
 		}
-		f.files = append(f.files, merged...)
+		f.files = append([]os.FileInfo{}, merged...)
+		sort.SliceStable(f.files, func(i, j int) bool { return f.files[i].Name() < f.files[j].Name() })
+	}
+
+	// Like os.File, a count <= 0 reads the rest of the directory and only
+	// paging reports the end with io.EOF.
+	if c <= 0 {
+		ofi = f.files[f.off:]
+		f.off = len(f.files)
+		return ofi, nil
 	}
 
 	if f.off >= len(f.files) {
 		return nil, io.EOF
 	}
 
-	if c == -1 {
-		return f.files[f.off:], nil
+	if c > len(f.files)-f.off {
+		c = len(f.files) - f.off
 	}
 
-	if c > len(f.files) {
-		c = len(f.files)
-	}
+	ofi = f.files[f.off : f.off+c]
+	f.off += c
+	return ofi, nil
+}
 
-	defer func() { f.off += c }()
-	return f.files[f.off:c], nil
+// applyWhiteouts removes the whiteouts from the entries of the layer and the
+// entries they hide from the ones of the base.
+func applyWhiteouts(lfi, bfi []os.FileInfo) ([]os.FileInfo, []os.FileInfo) {
+	var visible []os.FileInfo
+	hidden := make(map[string]bool)
+	opaque := false
+	for _, fi := range lfi {
+		switch name := fi.Name(); {
+		case name == whiteoutOpaque:
+			opaque = true
+		case strings.HasPrefix(name, whiteoutPrefix):
+			hidden[strings.TrimPrefix(name, whiteoutPrefix)] = true
+		default:
+			visible = append(visible, fi)
+		}
+	}
+	if opaque {
+		return visible, nil
+	}
+	var base []os.FileInfo
+	for _, fi := range bfi {
+		if !hidden[fi.Name()] {
+			base = append(base, fi)
+		}
+	}
+	return visible, base
 }
 
 func (f *UnionFile) Readdirnames(c int) ([]string, error) {
@@ -267,50 +310,108 @@ func (f *UnionFile) WriteString(s string) (n int, err error) {
 	return 0, BADFD
 }
 
+// copyLinkToLayer recreates the symbolic link name of the base in the layer.
+func copyLinkToLayer(base Fs, layer Fs, name string) error {
+	reader, ok := base.(LinkReader)
+	if !ok {
+		return &os.PathError{Op: "readlink", Path: name, Err: ErrNoReadlink}
+	}
+	target, err := reader.ReadlinkIfPossible(name)
+	if err != nil {
+		return err
+	}
+	linker, ok := layer.(Linker)
+	if !ok {
+		return &os.LinkError{Op: "symlink", Old: target, New: name, Err: ErrNoSymlink}
+	}
+	if err := mkdirAllFromBase(base, layer, filepath.Dir(name), 0777); err != nil {
+		return err
+	}
+	return linker.SymlinkIfPossible(target, name)
+}
+
 func copyToLayer(base Fs, layer Fs, name string) error {
+	_, err := copyFileToLayer(base, layer, name)
+	return err
+}
+
+// copyFileToLayer is copyToLayer, also returning the number of bytes copied.
+// The copy is written to a temporary file next to name and renamed into
+// place when complete, so a copy in progress is never seen under name.
+func copyFileToLayer(base Fs, layer Fs, name string) (int64, error) {
 	bfh, err := base.Open(name)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer bfh.Close()
 
 	// First make sure the directory exists
-	exists, err := Exists(layer, filepath.Dir(name))
-	if err != nil {
-		return err
-	}
-	if !exists {
-		err = layer.MkdirAll(filepath.Dir(name), 0777) // FIXME?
-		if err != nil {
-			return err
-		}
+	if err := mkdirAllFromBase(base, layer, filepath.Dir(name), 0777); err != nil {
+		return 0, err
 	}
 
-	// Create the file on the overlay
-	lfh, err := layer.Create(name)
+	// Create the copy on the overlay
+	lfh, err := TempFile(layer, filepath.Dir(name), "."+filepath.Base(name)+".")
 	if err != nil {
-		return err
+		return 0, err
 	}
+	tmpName := lfh.Name()
 	n, err := io.Copy(lfh, bfh)
 	if err != nil {
 		// If anything fails, clean up the file
-		layer.Remove(name)
 		lfh.Close()
-		return err
+		layer.Remove(tmpName)
+		return 0, err
 	}
 
 	bfi, err := bfh.Stat()
 	if err != nil || bfi.Size() != n {
-		layer.Remove(name)
 		lfh.Close()
-		return syscall.EIO
+		layer.Remove(tmpName)
+		return 0, syscall.EIO
 	}
 
 	err = lfh.Close()
+	if err == nil {
+		err = layer.Chmod(tmpName, bfi.Mode())
+	}
+	if err == nil {
+		err = layer.Chtimes(tmpName, bfi.ModTime(), bfi.ModTime())
+	}
+	if err == nil {
+		err = layer.Rename(tmpName, name)
+	}
 	if err != nil {
-		layer.Remove(name)
-		lfh.Close()
+		layer.Remove(tmpName)
+		return 0, err
+	}
+	return n, nil
+}
+
+// mkdirAllFromBase creates the directory dir and its missing parents in the
+// layer. The directories standing for ones of the base get their modes, with
+// the owner keeping the permission to fill them, the others get perm.
+func mkdirAllFromBase(base Fs, layer Fs, dir string, perm os.FileMode) error {
+	dir = filepath.Clean(dir)
+	if _, err := layer.Stat(dir); err == nil {
+		return nil
+	}
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := mkdirAllFromBase(base, layer, parent, 0777); err != nil {
+			return err
+		}
+	}
+	fi, err := base.Stat(dir)
+	if err != nil || !fi.IsDir() {
+		if err := layer.Mkdir(dir, perm); err != nil && !os.IsExist(err) {
+			return err
+		}
+		return nil
+	}
+	// like the base, regardless of the umask, but writable to copy up
+	mode := fi.Mode().Perm() | copyUpDirPerm
+	if err := layer.Mkdir(dir, mode); err != nil && !os.IsExist(err) {
 		return err
 	}
-	return layer.Chtimes(name, bfi.ModTime(), bfi.ModTime())
+	return layer.Chmod(dir, mode)
 }
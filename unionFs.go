@@ -0,0 +1,383 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var _ Lstater = (*UnionFs)(nil)
+var _ Symlinker = (*UnionFs)(nil)
+var _ Chowner = (*UnionFs)(nil)
+var _ Lstater = (*layersFs)(nil)
+var _ LinkReader = (*layersFs)(nil)
+
+// The UnionFs stacks read only lower layers under a writable upper layer,
+// like the layers of a container image. A file of a layer hides the file
+// with the same name in the layers below it.
+//
+// The upper layer works like the overlay of a CopyOnWriteFs, with the lower
+// layers merged as its base: changes are only made in the upper layer, a
+// removed file of a lower layer is whited out. The whiteouts and opaque
+// directories in the lower layers hide the files of the layers below them
+// the same way.
+//
+// The lower layers must not change while the UnionFs is used, the lookups in
+// them are remembered.
+type UnionFs struct {
+	cow   *CopyOnWriteFs
+	lower *layersFs
+}
+
+// NewUnionFs returns a UnionFs of the upper layer over the lower layers,
+// the first of them on top.
+func NewUnionFs(upper Fs, lowers ...Fs) Fs {
+	lower := &layersFs{layers: lowers}
+	u := &UnionFs{cow: &CopyOnWriteFs{base: lower, layer: upper}, lower: lower}
+	u.SetMerger(nil)
+	return u
+}
+
+// Diff lists the changes made in the upper layer compared to the lower
+// layers, like CopyOnWriteFs.Diff. They cannot be committed, the lower
+// layers are read only.
+func (u *UnionFs) Diff() ([]Change, error) {
+	return u.cow.Diff()
+}
+
+// Discard drops the changes made through u by emptying its upper layer.
+func (u *UnionFs) Discard() error {
+	return u.cow.Discard()
+}
+
+// SetMerger sets how the directories of the layers are merged, two at a
+// time, from the top. The merged listings are sorted by name in any case.
+// A nil merger restores the default, which keeps the entry of the upper
+// layer for a name present in both. SetMerger must be called before u is
+// used.
+func (u *UnionFs) SetMerger(merge DirsMerger) {
+	u.cow.SetMerger(merge)
+	if merge == nil {
+		merge = defaultUnionMergeDirsFn
+	}
+	u.lower.mu.Lock()
+	u.lower.merger = merge
+	u.lower.listings = nil
+	u.lower.mu.Unlock()
+}
+
+func (u *UnionFs) Name() string {
+	return "UnionFs"
+}
+
+func (u *UnionFs) Create(name string) (File, error) {
+	return u.cow.Create(name)
+}
+
+func (u *UnionFs) Mkdir(name string, perm os.FileMode) error {
+	return u.cow.Mkdir(name, perm)
+}
+
+func (u *UnionFs) MkdirAll(path string, perm os.FileMode) error {
+	return u.cow.MkdirAll(path, perm)
+}
+
+func (u *UnionFs) Open(name string) (File, error) {
+	return u.cow.Open(name)
+}
+
+func (u *UnionFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return u.cow.OpenFile(name, flag, perm)
+}
+
+func (u *UnionFs) Remove(name string) error {
+	return u.cow.Remove(name)
+}
+
+func (u *UnionFs) RemoveAll(path string) error {
+	return u.cow.RemoveAll(path)
+}
+
+func (u *UnionFs) Rename(oldname, newname string) error {
+	return u.cow.Rename(oldname, newname)
+}
+
+func (u *UnionFs) Stat(name string) (os.FileInfo, error) {
+	return u.cow.Stat(name)
+}
+
+func (u *UnionFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	return u.cow.LstatIfPossible(name)
+}
+
+func (u *UnionFs) SymlinkIfPossible(oldname, newname string) error {
+	return u.cow.SymlinkIfPossible(oldname, newname)
+}
+
+func (u *UnionFs) ReadlinkIfPossible(name string) (string, error) {
+	return u.cow.ReadlinkIfPossible(name)
+}
+
+func (u *UnionFs) Chmod(name string, mode os.FileMode) error {
+	return u.cow.Chmod(name, mode)
+}
+
+func (u *UnionFs) Chown(name string, uid, gid int) error {
+	return u.cow.Chown(name, uid, gid)
+}
+
+func (u *UnionFs) Lchown(name string, uid, gid int) error {
+	return u.cow.Lchown(name, uid, gid)
+}
+
+func (u *UnionFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return u.cow.Chtimes(name, atime, mtime)
+}
+
+// The number of lookups and of directory listings of the lower layers a
+// UnionFs remembers. They are forgotten all at once when there are more.
+const (
+	maxLayerLookups  = 4096
+	maxLayerListings = 256
+)
+
+// layersFs is the read only merge of the lower layers of a UnionFs.
+type layersFs struct {
+	layers []Fs
+	merger DirsMerger
+
+	mu       sync.Mutex
+	found    map[string]int // name -> index of its layer, -1 if missing
+	listings map[string][]os.FileInfo
+}
+
+// find returns the index of the top most layer name is visible in, or -1.
+func (l *layersFs) find(name string) int {
+	name = filepath.Clean(name)
+	l.mu.Lock()
+	i, ok := l.found[name]
+	l.mu.Unlock()
+	if ok {
+		return i
+	}
+
+	i = -1
+	if !isWhiteoutName(name) {
+		for j, layer := range l.layers {
+			if _, err := lstatIfPossible(layer, name); err == nil {
+				i = j
+				break
+			}
+			if hiddenBy(layer, name) || replacedBy(layer, name) {
+				break
+			}
+		}
+	}
+
+	l.mu.Lock()
+	if l.found == nil || len(l.found) >= maxLayerLookups {
+		l.found = make(map[string]int)
+	}
+	l.found[name] = i
+	l.mu.Unlock()
+	return i
+}
+
+// replacedBy reports whether a parent of name is a file in layer, which
+// replaces the directories below it like in readdir.
+func replacedBy(layer Fs, name string) bool {
+	for p := filepath.Dir(name); ; p = filepath.Dir(p) {
+		if fi, err := lstatIfPossible(layer, p); err == nil && !fi.IsDir() {
+			return true
+		}
+		if p == filepath.Dir(p) {
+			return false
+		}
+	}
+}
+
+func (l *layersFs) layer(op, name string) (Fs, error) {
+	i := l.find(name)
+	if i < 0 {
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return l.layers[i], nil
+}
+
+func (l *layersFs) Stat(name string) (os.FileInfo, error) {
+	layer, err := l.layer("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return layer.Stat(name)
+}
+
+func (l *layersFs) LstatIfPossible(name string) (os.FileInfo, bool, error) {
+	layer, err := l.layer("lstat", name)
+	if err != nil {
+		return nil, false, err
+	}
+	if lstater, ok := layer.(Lstater); ok {
+		return lstater.LstatIfPossible(name)
+	}
+	fi, err := layer.Stat(name)
+	return fi, false, err
+}
+
+func (l *layersFs) ReadlinkIfPossible(name string) (string, error) {
+	layer, err := l.layer("readlink", name)
+	if err != nil {
+		return "", err
+	}
+	return readlinkIfPossible(layer, name)
+}
+
+func (l *layersFs) Open(name string) (File, error) {
+	layer, err := l.layer("open", name)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := layer.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return layer.Open(name)
+	}
+	entries, err := l.readdir(filepath.Clean(name))
+	if err != nil {
+		return nil, err
+	}
+	// a copy, the callers may sort it in place
+	return &cacheDirFile{name: name, fi: fi, entries: append([]os.FileInfo{}, entries...)}, nil
+}
+
+// readdir merges the directory name of the layers.
+func (l *layersFs) readdir(name string) ([]os.FileInfo, error) {
+	l.mu.Lock()
+	entries, ok := l.listings[name]
+	merge := l.merger
+	l.mu.Unlock()
+	if ok {
+		return entries, nil
+	}
+
+	var merged []os.FileInfo
+	hidden := make(map[string]bool)
+	for _, layer := range l.layers {
+		fi, err := lstatIfPossible(layer, name)
+		if err == nil && !fi.IsDir() {
+			// a file replaces the directories below it
+			break
+		}
+		if err == nil {
+			f, err := layer.Open(name)
+			if err != nil {
+				return nil, err
+			}
+			fis, err := f.Readdir(-1)
+			f.Close()
+			if err != nil {
+				return nil, err
+			}
+			var visible []os.FileInfo
+			var whiteouts []string
+			opaque := false
+			for _, fi := range fis {
+				switch n := fi.Name(); {
+				case n == whiteoutOpaque:
+					opaque = true
+				case strings.HasPrefix(n, whiteoutPrefix):
+					whiteouts = append(whiteouts, strings.TrimPrefix(n, whiteoutPrefix))
+				case !hidden[n]:
+					visible = append(visible, fi)
+				}
+			}
+			// the whiteouts hide the entries of the layers below only
+			for _, n := range whiteouts {
+				hidden[n] = true
+			}
+			if merged, err = merge(merged, visible); err != nil {
+				return nil, err
+			}
+			if opaque {
+				break
+			}
+		}
+		if hiddenBy(layer, name) {
+			break
+		}
+	}
+	if merged == nil {
+		merged = []os.FileInfo{}
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Name() < merged[j].Name() })
+
+	l.mu.Lock()
+	if l.listings == nil || len(l.listings) >= maxLayerListings {
+		l.listings = make(map[string][]os.FileInfo)
+	}
+	l.listings[name] = merged
+	l.mu.Unlock()
+	return merged, nil
+}
+
+func (l *layersFs) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|syscall.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, syscall.EPERM
+	}
+	return l.Open(name)
+}
+
+func (l *layersFs) Create(name string) (File, error) {
+	return nil, syscall.EPERM
+}
+
+func (l *layersFs) Mkdir(name string, perm os.FileMode) error {
+	return syscall.EPERM
+}
+
+func (l *layersFs) MkdirAll(path string, perm os.FileMode) error {
+	return syscall.EPERM
+}
+
+func (l *layersFs) Remove(name string) error {
+	return syscall.EPERM
+}
+
+func (l *layersFs) RemoveAll(path string) error {
+	return syscall.EPERM
+}
+
+func (l *layersFs) Rename(oldname, newname string) error {
+	return syscall.EPERM
+}
+
+func (l *layersFs) Chmod(name string, mode os.FileMode) error {
+	return syscall.EPERM
+}
+
+func (l *layersFs) Chtimes(name string, atime time.Time, mtime time.Time) error {
+	return syscall.EPERM
+}
+
+func (l *layersFs) Name() string {
+	return "UnionFs lower layers"
+}
@@ -0,0 +1,183 @@
+package afero
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+func newTestUnionLayers(t *testing.T) (bottom, middle, top Fs) {
+	bottom, middle, top = NewMemMapFs(), NewMemMapFs(), NewMemMapFs()
+	files := []struct {
+		fs   Fs
+		name string
+	}{
+		{bottom, "/a"}, {bottom, "/d/x"}, {bottom, "/d/y"}, {bottom, "/e"}, {bottom, "/g/old"},
+		{middle, "/a"}, {middle, "/.wh.e"}, {middle, "/d/.wh.x"}, {middle, "/g/.wh..wh..opq"}, {middle, "/g/new"},
+		{top, "/d/z"}, {top, "/f"},
+	}
+	for i, f := range files {
+		if err := WriteFile(f.fs, f.name, []byte(fmt.Sprint(i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return bottom, middle, top
+}
+
+func listNames(t *testing.T, fs Fs, name string) string {
+	fis, err := ReadDir(fs, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	return strings.Join(names, " ")
+}
+
+func TestUnionFsLayers(t *testing.T) {
+	bottom, middle, top := newTestUnionLayers(t)
+	ufs := NewUnionFs(NewMemMapFs(), top, middle, bottom)
+
+	if data, err := ReadFile(ufs, "/a"); err != nil || string(data) != "5" {
+		t.Errorf("/a: got %q, %v, want the middle layer", data, err)
+	}
+	for _, name := range []string{"/e", "/d/x", "/g/old", "/.wh.e"} {
+		if _, err := ufs.Stat(name); !os.IsNotExist(err) {
+			t.Errorf("%s: expected not exist, got %v", name, err)
+		}
+	}
+	for dir, want := range map[string]string{
+		"/":  "a d f g",
+		"/d": "y z",
+		"/g": "new",
+	} {
+		if got := listNames(t, ufs, dir); got != want {
+			t.Errorf("%s: got %q, want %q", dir, got, want)
+		}
+	}
+
+	// paging returns the same sorted listing
+	f, err := ufs.Open("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for {
+		fis, err := f.Readdir(1)
+		if err != nil {
+			break
+		}
+		names = append(names, fis[0].Name())
+	}
+	f.Close()
+	if got := strings.Join(names, " "); got != "a d f g" {
+		t.Errorf("paged: got %q", got)
+	}
+}
+
+func TestUnionFsWrite(t *testing.T) {
+	bottom, middle, top := newTestUnionLayers(t)
+	upper := NewMemMapFs()
+	ufs := NewUnionFs(upper, top, middle, bottom)
+
+	if err := WriteFile(ufs, "/d/y", []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ufs.Remove("/a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ufs.Mkdir("/e", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if data, _ := ReadFile(ufs, "/d/y"); string(data) != "changed" {
+		t.Errorf("/d/y: got %q", data)
+	}
+	if data, _ := ReadFile(bottom, "/d/y"); string(data) != "2" {
+		t.Errorf("lower layer changed: %q", data)
+	}
+	if _, err := ufs.Stat("/a"); !os.IsNotExist(err) {
+		t.Errorf("/a: expected not exist, got %v", err)
+	}
+	if ok, _ := Exists(middle, "/a"); !ok {
+		t.Error("/a removed from the lower layer")
+	}
+	if got := listNames(t, ufs, "/"); got != "d e f g" {
+		t.Errorf("/: got %q", got)
+	}
+	if got := listNames(t, ufs, "/e"); got != "" {
+		t.Errorf("/e: got %q", got)
+	}
+
+	changes, err := ufs.(*UnionFs).Diff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(changes); got != "[deleted /a modified /d/y added /e]" {
+		t.Errorf("got changes %s", got)
+	}
+	if _, ok := ufs.(interface{ Commit() error }); ok {
+		t.Error("the read only lower layers cannot be committed to")
+	}
+	if err := ufs.(*UnionFs).Discard(); err != nil {
+		t.Fatal(err)
+	}
+	if got := listNames(t, ufs, "/"); got != "a d f g" {
+		t.Errorf("/ after Discard: got %q", got)
+	}
+}
+
+func TestUnionFsFileOverDir(t *testing.T) {
+	top, bottom := NewMemMapFs(), NewMemMapFs()
+	WriteFile(top, "/a", []byte("file"), 0644)
+	WriteFile(bottom, "/a/b", []byte("below"), 0644)
+	ufs := NewUnionFs(NewMemMapFs(), top, bottom)
+
+	if fi, err := ufs.Stat("/a"); err != nil || fi.IsDir() {
+		t.Fatalf("/a: got %v, %v, want the file of the top layer", fi, err)
+	}
+	if _, err := ufs.Stat("/a/b"); err == nil {
+		t.Error("/a/b: found below the file /a")
+	}
+	if data, err := ReadFile(ufs, "/a/b"); err == nil {
+		t.Errorf("/a/b: read %q below the file /a", data)
+	}
+}
+
+func TestUnionFsLookupsBounded(t *testing.T) {
+	lower := NewMemMapFs()
+	ufs := NewUnionFs(NewMemMapFs(), lower).(*UnionFs)
+	for i := 0; i < 2*maxLayerLookups; i++ {
+		name := fmt.Sprintf("/dir%d", i)
+		lower.Mkdir(name, 0755)
+		ReadDir(ufs, name)
+	}
+	if n := len(ufs.lower.found); n > maxLayerLookups {
+		t.Errorf("%d lookups remembered", n)
+	}
+	if n := len(ufs.lower.listings); n > maxLayerListings {
+		t.Errorf("%d listings remembered", n)
+	}
+}
+
+func TestUnionFsMerger(t *testing.T) {
+	bottom, middle, top := newTestUnionLayers(t)
+	ufs := NewUnionFs(NewMemMapFs(), top, middle, bottom).(*UnionFs)
+	ufs.SetMerger(func(lofi, bofi []os.FileInfo) ([]os.FileInfo, error) {
+		merged, err := defaultUnionMergeDirsFn(lofi, bofi)
+		var kept []os.FileInfo
+		for _, fi := range merged {
+			if fi.Name() != "z" {
+				kept = append(kept, fi)
+			}
+		}
+		return kept, err
+	})
+
+	if got := listNames(t, ufs, "/d"); got != "y" {
+		t.Errorf("/d: got %q", got)
+	}
+}
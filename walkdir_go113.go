@@ -0,0 +1,46 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !go1.16
+// +build !go1.16
+
+package afero
+
+import (
+	"errors"
+	"os"
+)
+
+// DirEntry is an entry of a directory walked by WalkDir, like the
+// fs.DirEntry of Go 1.16.
+type DirEntry interface {
+	// Name returns the name of the file, not the full path.
+	Name() string
+
+	// IsDir reports whether the entry is a directory.
+	IsDir() bool
+
+	// Type returns the type bits of the mode of the entry.
+	Type() os.FileMode
+
+	// Info returns the os.FileInfo of the entry.
+	Info() (os.FileInfo, error)
+}
+
+// WalkDirFunc is the type of the function called by WalkDir. The err of
+// the call for root is the error of its lookup, with a nil d, and the err
+// of a second call for a directory is the error reading it.
+type WalkDirFunc func(path string, d DirEntry, err error) error
+
+// SkipAll returned by a WalkDirFunc stops the walk.
+var SkipAll = errors.New("skip everything and stop the walk")
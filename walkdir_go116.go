@@ -0,0 +1,31 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.16 && !go1.20
+// +build go1.16,!go1.20
+
+package afero
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// DirEntry is an entry of a directory walked by WalkDir.
+type DirEntry = fs.DirEntry
+
+// WalkDirFunc is the type of the function called by WalkDir.
+type WalkDirFunc = fs.WalkDirFunc
+
+// SkipAll returned by a WalkDirFunc stops the walk.
+var SkipAll = errors.New("skip everything and stop the walk")
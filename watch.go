@@ -0,0 +1,250 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package afero
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Watcher is an optional interface in Afero. It is only implemented by the
+// filesystems saying so.
+// Watch reports the changes to name and, if name is a directory, to its
+// entries. With recursive set the changes anywhere below name are reported.
+// The watched path must exist.
+type Watcher interface {
+	Watch(name string, recursive bool) (Watch, error)
+}
+
+// ErrNoWatch is the error that will be wrapped in an os.PathError if a file
+// system does not support watching for changes either directly or through
+// its delegated filesystem.
+var ErrNoWatch = errors.New("watch not supported")
+
+// ErrWatchOverflow is delivered on the Errors channel of a Watch when the
+// underlying system dropped events.
+var ErrWatchOverflow = errors.New("watch queue overflow, events were lost")
+
+// Watch is a watch started by Watcher.Watch.
+type Watch interface {
+	// Events returns the channel the events are delivered on. Events are
+	// queued, so a slow reader does not block the filesystem. The channel
+	// is closed after Close.
+	Events() <-chan WatchEvent
+
+	// Errors returns the channel errors are delivered on. It is closed
+	// after Close.
+	Errors() <-chan error
+
+	// Close stops the watch.
+	Close() error
+}
+
+// WatchOp describes the kind of change of a WatchEvent.
+type WatchOp uint32
+
+const (
+	// WatchCreate is reported for new files and directories, including
+	// the destination of a rename.
+	WatchCreate WatchOp = 1 << iota
+	// WatchWrite is reported when the content of a file changed. A
+	// filesystem may report a Write for every write call or once when the
+	// file is closed.
+	WatchWrite
+	// WatchRemove is reported for removed files and directories.
+	WatchRemove
+	// WatchRename is reported for the old name of a renamed file, the new
+	// name gets a WatchCreate.
+	WatchRename
+	// WatchChmod is reported when the mode, times or owner of a file
+	// changed.
+	WatchChmod
+)
+
+func (op WatchOp) String() string {
+	var names []string
+	for _, o := range []struct {
+		op   WatchOp
+		name string
+	}{
+		{WatchCreate, "CREATE"},
+		{WatchWrite, "WRITE"},
+		{WatchRemove, "REMOVE"},
+		{WatchRename, "RENAME"},
+		{WatchChmod, "CHMOD"},
+	} {
+		if op&o.op != 0 {
+			names = append(names, o.name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// WatchEvent is a change of a watched file.
+type WatchEvent struct {
+	Name string
+	Op   WatchOp
+}
+
+func (e WatchEvent) String() string {
+	return e.Op.String() + " " + e.Name
+}
+
+// watchMatches reports whether a change of name is reported by a watch of
+// root.
+func watchMatches(root string, recursive bool, name string) bool {
+	if name == root {
+		return true
+	}
+	if !recursive {
+		return filepath.Dir(name) == root
+	}
+	prefix := root
+	if !strings.HasSuffix(prefix, FilePathSeparator) {
+		prefix += FilePathSeparator
+	}
+	return strings.HasPrefix(name, prefix)
+}
+
+// queuedWatch is a Watch which queues the events and errors pushed to it
+// and delivers them from its own goroutine.
+type queuedWatch struct {
+	events chan WatchEvent
+	errors chan error
+	done   chan struct{}
+	wake   chan struct{}
+
+	mu      sync.Mutex
+	pending []watchItem
+	closed  bool
+
+	// stop detaches the watch from the filesystem
+	stop func() error
+}
+
+type watchItem struct {
+	event WatchEvent
+	err   error
+}
+
+func newQueuedWatch(stop func() error) *queuedWatch {
+	w := &queuedWatch{
+		events: make(chan WatchEvent),
+		errors: make(chan error),
+		done:   make(chan struct{}),
+		wake:   make(chan struct{}, 1),
+		stop:   stop,
+	}
+	go w.run()
+	return w
+}
+
+func (w *queuedWatch) Events() <-chan WatchEvent { return w.events }
+
+func (w *queuedWatch) Errors() <-chan error { return w.errors }
+
+func (w *queuedWatch) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.pending = nil
+	w.mu.Unlock()
+
+	var err error
+	if w.stop != nil {
+		err = w.stop()
+	}
+	close(w.done)
+	return err
+}
+
+func (w *queuedWatch) push(item watchItem) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	w.pending = append(w.pending, item)
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (w *queuedWatch) pushEvent(name string, op WatchOp) {
+	w.push(watchItem{event: WatchEvent{Name: name, Op: op}})
+}
+
+func (w *queuedWatch) pushError(err error) {
+	w.push(watchItem{err: err})
+}
+
+func (w *queuedWatch) run() {
+	defer close(w.errors)
+	defer close(w.events)
+	for {
+		w.mu.Lock()
+		if len(w.pending) == 0 {
+			w.mu.Unlock()
+			select {
+			case <-w.wake:
+				continue
+			case <-w.done:
+				return
+			}
+		}
+		item := w.pending[0]
+		w.pending = w.pending[1:]
+		w.mu.Unlock()
+
+		if item.err != nil {
+			select {
+			case w.errors <- item.err:
+			case <-w.done:
+				return
+			}
+			continue
+		}
+		select {
+		case w.events <- item.event:
+		case <-w.done:
+			return
+		}
+	}
+}
+
+// mapWatch returns a Watch delivering the events of w with their names
+// mapped by fn, events for which fn returns false are dropped. Closing it
+// closes w.
+func mapWatch(w Watch, fn func(name string) (string, bool)) Watch {
+	mapped := newQueuedWatch(w.Close)
+	go func() {
+		for e := range w.Events() {
+			if name, ok := fn(e.Name); ok {
+				mapped.pushEvent(name, e.Op)
+			}
+		}
+	}()
+	go func() {
+		for err := range w.Errors() {
+			mapped.pushError(err)
+		}
+	}()
+	return mapped
+}
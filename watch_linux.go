@@ -0,0 +1,223 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask = syscall.IN_CREATE | syscall.IN_MODIFY | syscall.IN_ATTRIB |
+	syscall.IN_DELETE | syscall.IN_DELETE_SELF |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_MOVE_SELF
+
+// inotifyWatch is a Watch of the OsFs backed by inotify. A recursive watch
+// adds an inotify watch for every directory below its root, including the
+// ones created after the watch was started.
+type inotifyWatch struct {
+	*queuedWatch
+	fd        int
+	file      *os.File
+	root      string
+	recursive bool
+
+	mu      sync.Mutex
+	closing bool
+
+	// only used by the reading goroutine once the watch is started
+	paths map[int32]string
+	wds   map[string]int32
+}
+
+func watchOs(name string, recursive bool) (Watch, error) {
+	name = filepath.Clean(name)
+	fi, err := os.Stat(name)
+	if err != nil {
+		if pe, ok := err.(*os.PathError); ok {
+			err = pe.Err
+		}
+		return nil, &os.PathError{Op: "watch", Path: name, Err: err}
+	}
+
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, &os.PathError{Op: "watch", Path: name, Err: err}
+	}
+	w := &inotifyWatch{
+		fd:        fd,
+		file:      os.NewFile(uintptr(fd), "inotify"),
+		root:      name,
+		recursive: recursive && fi.IsDir(),
+		paths:     make(map[int32]string),
+		wds:       make(map[string]int32),
+	}
+	if w.recursive {
+		err = w.addTree(name, false)
+	} else {
+		err = w.add(name)
+	}
+	if err != nil {
+		w.file.Close()
+		return nil, &os.PathError{Op: "watch", Path: name, Err: err}
+	}
+
+	w.queuedWatch = newQueuedWatch(w.stop)
+	go w.read()
+	return w, nil
+}
+
+func (w *inotifyWatch) stop() error {
+	w.mu.Lock()
+	w.closing = true
+	w.mu.Unlock()
+	return w.file.Close()
+}
+
+func (w *inotifyWatch) add(path string) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+	if err != nil {
+		return err
+	}
+	w.paths[int32(wd)] = path
+	w.wds[path] = int32(wd)
+	return nil
+}
+
+// addTree watches the directory path and the directories below it. With
+// emit set a WatchCreate is reported for every entry found, so the entries
+// created before the watches were in place are not missed.
+func (w *inotifyWatch) addTree(path string, emit bool) error {
+	return filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if p == path {
+				return err
+			}
+			// removed while walking, its removal is reported anyway
+			return nil
+		}
+		if emit && p != path {
+			w.pushEvent(p, WatchCreate)
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if err := w.add(p); err != nil && p == path {
+			return err
+		}
+		return nil
+	})
+}
+
+// removeTree stops watching path and the directories below it.
+func (w *inotifyWatch) removeTree(path string) {
+	for p, wd := range w.wds {
+		if p == path || strings.HasPrefix(p, path+FilePathSeparator) {
+			syscall.InotifyRmWatch(w.fd, uint32(wd))
+			delete(w.wds, p)
+			delete(w.paths, wd)
+		}
+	}
+}
+
+func (w *inotifyWatch) read() {
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			w.mu.Lock()
+			closing := w.closing
+			w.mu.Unlock()
+			if !closing {
+				w.pushError(err)
+			}
+			return
+		}
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			raw := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			start := off + syscall.SizeofInotifyEvent
+			off = start + int(raw.Len)
+			var base string
+			if raw.Len > 0 {
+				base = strings.TrimRight(string(buf[start:off]), "\x00")
+			}
+			w.handle(raw.Wd, raw.Mask, base)
+		}
+	}
+}
+
+func (w *inotifyWatch) handle(wd int32, mask uint32, base string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		w.pushError(ErrWatchOverflow)
+		return
+	}
+	dir, ok := w.paths[wd]
+	if !ok {
+		return
+	}
+	if mask&syscall.IN_IGNORED != 0 {
+		delete(w.paths, wd)
+		delete(w.wds, dir)
+		return
+	}
+	name := dir
+	if base != "" {
+		name = filepath.Join(dir, base)
+	}
+	if mask&(syscall.IN_DELETE_SELF|syscall.IN_MOVE_SELF) != 0 && name != w.root {
+		// reported through the watch of the parent directory
+		return
+	}
+
+	op := inotifyOp(mask)
+	if op == 0 {
+		return
+	}
+	w.pushEvent(name, op)
+
+	if w.recursive && mask&syscall.IN_ISDIR != 0 {
+		switch {
+		case mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0:
+			w.addTree(name, true)
+		case mask&syscall.IN_MOVED_FROM != 0:
+			w.removeTree(name)
+		}
+	}
+}
+
+func inotifyOp(mask uint32) WatchOp {
+	var op WatchOp
+	if mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+		op |= WatchCreate
+	}
+	if mask&syscall.IN_MODIFY != 0 {
+		op |= WatchWrite
+	}
+	if mask&(syscall.IN_DELETE|syscall.IN_DELETE_SELF) != 0 {
+		op |= WatchRemove
+	}
+	if mask&(syscall.IN_MOVED_FROM|syscall.IN_MOVE_SELF) != 0 {
+		op |= WatchRename
+	}
+	if mask&syscall.IN_ATTRIB != 0 {
+		op |= WatchChmod
+	}
+	return op
+}
@@ -0,0 +1,185 @@
+package afero
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func nextWatchEvent(t *testing.T, w Watch) WatchEvent {
+	t.Helper()
+	select {
+	case e := <-w.Events():
+		return e
+	case err := <-w.Errors():
+		t.Fatalf("watch error: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for a watch event")
+	}
+	return WatchEvent{}
+}
+
+func expectWatchEvents(t *testing.T, w Watch, want ...WatchEvent) {
+	t.Helper()
+	for _, e := range want {
+		if got := nextWatchEvent(t, w); got != e {
+			t.Fatalf("got event %v, expected %v", got, e)
+		}
+	}
+	select {
+	case e := <-w.Events():
+		t.Fatalf("unexpected event %v", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// waitWatchEvent skips events until one for name including op arrives.
+func waitWatchEvent(t *testing.T, w Watch, name string, op WatchOp) {
+	t.Helper()
+	for {
+		if e := nextWatchEvent(t, w); e.Name == name && e.Op&op != 0 {
+			return
+		}
+	}
+}
+
+func TestMemMapFsWatch(t *testing.T) {
+	fs := &MemMapFs{}
+	fs.MkdirAll("/a/b", 0755)
+
+	w, err := fs.Watch("/a", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	f, err := fs.Create("/a/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("data")
+	f.Close()
+	WriteFile(fs, "/a/b/g", []byte("data"), 0644)
+	fs.Chmod("/a/f", 0600)
+	fs.Rename("/a/f", "/a/h")
+	fs.Remove("/a/h")
+
+	expectWatchEvents(t, w,
+		WatchEvent{"/a/f", WatchCreate},
+		WatchEvent{"/a/f", WatchWrite},
+		WatchEvent{"/a/f", WatchChmod},
+		WatchEvent{"/a/f", WatchRename},
+		WatchEvent{"/a/h", WatchCreate},
+		WatchEvent{"/a/h", WatchRemove},
+	)
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fs.Mkdir("/a/c", 0755)
+	for e := range w.Events() {
+		t.Errorf("event %v after Close", e)
+	}
+
+	if _, err := fs.Watch("/missing", false); !os.IsNotExist(err) {
+		t.Errorf("got %v watching a missing path, expected not exist", err)
+	}
+}
+
+func TestMemMapFsWatchRecursive(t *testing.T) {
+	fs := &MemMapFs{}
+	fs.MkdirAll("/a/b", 0755)
+
+	w, err := fs.Watch("/a", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	f, _ := fs.OpenFile("/a/b/g", os.O_RDWR|os.O_CREATE, 0644)
+	f.Close()
+	fs.MkdirAll("/a/b/c/d", 0755)
+	f, _ = fs.OpenFile("/a/b/g", os.O_WRONLY|os.O_TRUNC, 0)
+	f.Close()
+	fs.RemoveAll("/a/b")
+	fs.Mkdir("/x", 0755)
+
+	expectWatchEvents(t, w,
+		WatchEvent{"/a/b/g", WatchCreate},
+		WatchEvent{"/a/b/c", WatchCreate},
+		WatchEvent{"/a/b/c/d", WatchCreate},
+		WatchEvent{"/a/b/g", WatchWrite},
+		WatchEvent{"/a/b/g", WatchRemove},
+		WatchEvent{"/a/b/c/d", WatchRemove},
+		WatchEvent{"/a/b/c", WatchRemove},
+		WatchEvent{"/a/b", WatchRemove},
+	)
+}
+
+func TestBasePathFsWatch(t *testing.T) {
+	base := &MemMapFs{}
+	base.MkdirAll("/base/dir", 0755)
+	bp := NewBasePathFs(base, "/base")
+
+	w, err := bp.(Watcher).Watch("/", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	WriteFile(bp, "/dir/f", []byte("data"), 0644)
+	WriteFile(base, "/outside", []byte("data"), 0644)
+	bp.RemoveAll("/dir")
+
+	expectWatchEvents(t, w,
+		WatchEvent{filepath.FromSlash("/dir/f"), WatchCreate},
+		WatchEvent{filepath.FromSlash("/dir/f"), WatchWrite},
+		WatchEvent{filepath.FromSlash("/dir/f"), WatchRemove},
+		WatchEvent{filepath.FromSlash("/dir"), WatchRemove},
+	)
+}
+
+func TestOsFsWatch(t *testing.T) {
+	osFs := &OsFs{}
+	dir, err := TempDir(osFs, "", "afero-watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer osFs.RemoveAll(dir)
+
+	w, err := osFs.Watch(dir, true)
+	if err != nil {
+		if pe, ok := err.(*os.PathError); ok && pe.Err == ErrNoWatch {
+			t.Skip("watching is not supported on this system")
+		}
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	name := filepath.Join(dir, "f")
+	WriteFile(osFs, name, []byte("data"), 0644)
+	waitWatchEvent(t, w, name, WatchCreate)
+	waitWatchEvent(t, w, name, WatchWrite)
+
+	sub := filepath.Join(dir, "sub")
+	osFs.Mkdir(sub, 0755)
+	waitWatchEvent(t, w, sub, WatchCreate)
+	inSub := filepath.Join(sub, "g")
+	WriteFile(osFs, inSub, []byte("data"), 0644)
+	waitWatchEvent(t, w, inSub, WatchCreate)
+
+	osFs.Chmod(inSub, 0600)
+	waitWatchEvent(t, w, inSub, WatchChmod)
+	osFs.Rename(inSub, name)
+	waitWatchEvent(t, w, inSub, WatchRename)
+	waitWatchEvent(t, w, name, WatchCreate)
+	osFs.Remove(name)
+	waitWatchEvent(t, w, name, WatchRemove)
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range w.Events() {
+	}
+}
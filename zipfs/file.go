@@ -0,0 +1,244 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package zipfs
+
+import (
+	"archive/zip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// File is a file or directory of a zip archive.
+//
+// Stored entries are read directly from the archive, from Go 1.17 on. The
+// other entries can only be decompressed front to back, so the decompressed
+// data is kept in memory as far as it has been read, which makes seeking
+// backwards cheap.
+type File struct {
+	fs      *Fs
+	name    string
+	zipfile *zip.File
+	isdir   bool
+	closed  bool
+
+	ra     io.ReaderAt   // for stored entries
+	reader io.ReadCloser // decompressor for all other entries
+	buf    []byte        // data read from reader so far
+	eof    bool          // reader is exhausted
+	offset int64
+
+	readdir []os.FileInfo // directory listing, read on first use
+	diroff  int
+}
+
+func (f *File) size() int64 {
+	return int64(f.zipfile.UncompressedSize64)
+}
+
+// prepare opens the archive entry on first use.
+func (f *File) prepare() error {
+	if f.closed {
+		return afero.ErrFileClosed
+	}
+	if f.isdir {
+		return &os.PathError{Op: "read", Path: f.name, Err: syscall.EISDIR}
+	}
+	if f.ra != nil || f.reader != nil {
+		return nil
+	}
+	if f.zipfile.Method == zip.Store {
+		if f.ra = rawReaderAt(f.zipfile); f.ra != nil {
+			return nil
+		}
+	}
+	r, err := f.zipfile.Open()
+	if err != nil {
+		return err
+	}
+	f.reader = r
+	return nil
+}
+
+// fillBuffer decompresses the entry up to offset.
+func (f *File) fillBuffer(offset int64) error {
+	if f.eof || int64(len(f.buf)) >= offset {
+		return nil
+	}
+	chunk := make([]byte, 32*1024)
+	for int64(len(f.buf)) < offset {
+		n, err := f.reader.Read(chunk)
+		f.buf = append(f.buf, chunk[:n]...)
+		if err == io.EOF {
+			f.eof = true
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *File) readAt(p []byte, off int64) (int, error) {
+	if err := f.prepare(); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, &os.PathError{Op: "readat", Path: f.name, Err: syscall.EINVAL}
+	}
+	if f.ra != nil {
+		return f.ra.ReadAt(p, off)
+	}
+	if err := f.fillBuffer(off + int64(len(p))); err != nil {
+		return 0, err
+	}
+	if off >= int64(len(f.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.buf[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *File) Close() error {
+	f.closed = true
+	f.buf = nil
+	if f.reader != nil {
+		return f.reader.Close()
+	}
+	return nil
+}
+
+func (f *File) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	n, err = f.readAt(p, f.offset)
+	f.offset += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return
+}
+
+func (f *File) ReadAt(p []byte, off int64) (n int, err error) {
+	return f.readAt(p, off)
+}
+
+func (f *File) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, afero.ErrFileClosed
+	}
+	if f.isdir {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EISDIR}
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size()
+	default:
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: syscall.EINVAL}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *File) Write(p []byte) (n int, err error) { return 0, syscall.EPERM }
+
+func (f *File) WriteAt(p []byte, off int64) (n int, err error) { return 0, syscall.EPERM }
+
+func (f *File) Name() string { return f.name }
+
+func (f *File) Readdir(count int) (fi []os.FileInfo, err error) {
+	if f.closed {
+		return nil, afero.ErrFileClosed
+	}
+	if !f.isdir {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: syscall.ENOTDIR}
+	}
+	if f.readdir == nil {
+		f.readdir = []os.FileInfo{}
+		for base, zipfile := range f.fs.files[f.name] {
+			f.readdir = append(f.readdir, fileInfo(base, zipfile))
+		}
+		sort.Slice(f.readdir, func(i, j int) bool { return f.readdir[i].Name() < f.readdir[j].Name() })
+	}
+
+	rest := f.readdir[f.diroff:]
+	if count <= 0 {
+		f.diroff = len(f.readdir)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(rest) {
+		count = len(rest)
+	}
+	f.diroff += count
+	return rest[:count], nil
+}
+
+func (f *File) Readdirnames(count int) (names []string, err error) {
+	fi, err := f.Readdir(count)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fi {
+		names = append(names, f.Name())
+	}
+	return names, nil
+}
+
+func (f *File) Stat() (os.FileInfo, error) {
+	return fileInfo(filepath.Base(f.name), f.zipfile), nil
+}
+
+func (f *File) Sync() error { return nil }
+
+func (f *File) Truncate(size int64) error { return syscall.EPERM }
+
+func (f *File) WriteString(s string) (ret int, err error) { return 0, syscall.EPERM }
+
+// fileInfo describes the archive entry file, or a synthesized directory if
+// file is nil.
+func fileInfo(name string, file *zip.File) os.FileInfo {
+	if file == nil {
+		return dirInfo(name)
+	}
+	return file.FileInfo()
+}
+
+// dirInfo describes a directory without an entry in the archive.
+type dirInfo string
+
+func (d dirInfo) Name() string       { return string(d) }
+func (d dirInfo) Size() int64        { return 0 }
+func (d dirInfo) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (d dirInfo) ModTime() time.Time { return time.Time{} }
+func (d dirInfo) IsDir() bool        { return true }
+func (d dirInfo) Sys() interface{}   { return nil }
@@ -0,0 +1,152 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package zipfs provides a read only afero.Fs serving the content of a zip
+// archive.
+package zipfs
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+// Fs is a read only afero.Fs implementation on top of a *zip.Reader.
+//
+// Directories which are only implied by the names of the archived files are
+// synthesized. All paths are relative to the root of the archive, so "a/b"
+// and "/a/b" name the same file.
+type Fs struct {
+	r     *zip.Reader
+	files map[string]map[string]*zip.File // dir -> base name -> file, nil for synthesized dirs
+}
+
+func New(r *zip.Reader) afero.Fs {
+	fs := &Fs{r: r, files: make(map[string]map[string]*zip.File)}
+	fs.files[string(filepath.Separator)] = make(map[string]*zip.File)
+
+	for _, file := range r.File {
+		d, f := splitpath(file.Name)
+		if f == "" {
+			// an entry for the root directory
+			continue
+		}
+		if _, ok := fs.files[d]; !ok {
+			fs.files[d] = make(map[string]*zip.File)
+		}
+		if prev, ok := fs.files[d][f]; !ok || prev == nil || prev.FileInfo().IsDir() {
+			fs.files[d][f] = file
+		}
+		if file.FileInfo().IsDir() {
+			dirname := filepath.Join(d, f)
+			if _, ok := fs.files[dirname]; !ok {
+				fs.files[dirname] = make(map[string]*zip.File)
+			}
+		}
+	}
+
+	// synthesize the directories which have no entry of their own
+	dirs := make([]string, 0, len(fs.files))
+	for d := range fs.files {
+		dirs = append(dirs, d)
+	}
+	for _, d := range dirs {
+		for d != string(filepath.Separator) {
+			parent, base := filepath.Split(d)
+			parent = filepath.Clean(parent)
+			if _, ok := fs.files[parent]; !ok {
+				fs.files[parent] = make(map[string]*zip.File)
+			}
+			if _, ok := fs.files[parent][base]; !ok {
+				fs.files[parent][base] = nil
+			}
+			d = parent
+		}
+	}
+
+	return fs
+}
+
+// splitpath returns the cleaned directory and base name of a zip entry.
+func splitpath(name string) (dir, file string) {
+	name = filepath.Clean(string(filepath.Separator) + filepath.FromSlash(name))
+	dir, file = filepath.Split(name)
+	return filepath.Clean(dir), file
+}
+
+// cleanPath turns a file name into the rooted form used as index.
+func cleanPath(name string) string {
+	return filepath.Clean(string(filepath.Separator) + name)
+}
+
+func (fs *Fs) open(name string) (*File, error) {
+	name = cleanPath(name)
+	if name == string(filepath.Separator) {
+		return &File{fs: fs, name: name, isdir: true}, nil
+	}
+	d, f := filepath.Split(name)
+	file, ok := fs.files[filepath.Clean(d)][f]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: syscall.ENOENT}
+	}
+	if file == nil {
+		return &File{fs: fs, name: name, isdir: true}, nil
+	}
+	return &File{fs: fs, name: name, zipfile: file, isdir: file.FileInfo().IsDir()}, nil
+}
+
+func (fs *Fs) Create(name string) (afero.File, error) { return nil, syscall.EPERM }
+
+func (fs *Fs) Mkdir(name string, mode os.FileMode) error { return syscall.EPERM }
+
+func (fs *Fs) MkdirAll(path string, perm os.FileMode) error { return syscall.EPERM }
+
+func (fs *Fs) Open(name string) (afero.File, error) {
+	f, err := fs.open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (fs *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	if flag&(os.O_WRONLY|syscall.O_RDWR|os.O_APPEND|os.O_CREATE|os.O_TRUNC) != 0 {
+		return nil, syscall.EPERM
+	}
+	return fs.Open(name)
+}
+
+func (fs *Fs) Remove(name string) error { return syscall.EPERM }
+
+func (fs *Fs) RemoveAll(path string) error { return syscall.EPERM }
+
+func (fs *Fs) Rename(oldname, newname string) error { return syscall.EPERM }
+
+func (fs *Fs) Stat(name string) (os.FileInfo, error) {
+	f, err := fs.open(name)
+	if err != nil {
+		err.(*os.PathError).Op = "stat"
+		return nil, err
+	}
+	return f.Stat()
+}
+
+func (fs *Fs) Name() string { return "zipfs" }
+
+func (fs *Fs) Chmod(name string, mode os.FileMode) error { return syscall.EPERM }
+
+func (fs *Fs) Chtimes(name string, atime time.Time, mtime time.Time) error { return syscall.EPERM }
@@ -0,0 +1,33 @@
+// Copyright © 2019 Steve Francia <spf@spf13.com>.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build go1.17
+// +build go1.17
+
+package zipfs
+
+import (
+	"archive/zip"
+	"io"
+)
+
+// rawReaderAt returns a ReaderAt of the data of zf as stored in the archive,
+// or nil if the archive cannot be read at random.
+func rawReaderAt(zf *zip.File) io.ReaderAt {
+	raw, err := zf.OpenRaw()
+	if err != nil {
+		return nil
+	}
+	ra, _ := raw.(io.ReaderAt)
+	return ra
+}
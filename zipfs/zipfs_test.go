@@ -0,0 +1,328 @@
+package zipfs
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+	"github.com/spf13/afero/aferotest"
+)
+
+var testContent = strings.Repeat("Lorem ipsum dolor sit amet. ", 100)
+
+// newTestZip returns an archive with stored and deflated files, one of the
+// directories having an entry of its own and the others being implicit.
+func newTestZip(t *testing.T) *zip.Reader {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+
+	add := func(name string, method uint16, content string) {
+		h := &zip.FileHeader{Name: name, Method: method}
+		h.Modified = time.Date(2019, 1, 30, 12, 0, 0, 0, time.UTC)
+		if strings.HasSuffix(name, "/") {
+			h.SetMode(os.ModeDir | 0755)
+		} else {
+			h.SetMode(0644)
+		}
+		fw, err := w.CreateHeader(h)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(fw, content)
+	}
+
+	add("stored.txt", zip.Store, testContent)
+	add("deflated.txt", zip.Deflate, testContent)
+	add("explicit/", zip.Store, "")
+	add("explicit/file.txt", zip.Deflate, "explicit")
+	add("implicit/sub/a.txt", zip.Deflate, "a")
+	add("implicit/sub/b.txt", zip.Store, "b")
+	add("implicit/sub/c.txt", zip.Deflate, "c")
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestZipFS(t *testing.T) {
+	zfs := New(newTestZip(t))
+
+	for _, name := range []string{"/", ".", "/explicit", "explicit/", "/implicit", "/implicit/sub"} {
+		fi, err := zfs.Stat(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !fi.IsDir() || !fi.Mode().IsDir() {
+			t.Errorf("%s: expected a directory, got %s", name, fi.Mode())
+		}
+	}
+
+	fi, err := zfs.Stat("/explicit/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Name() != "file.txt" || fi.Size() != int64(len("explicit")) || fi.Mode() != 0644 {
+		t.Errorf("unexpected FileInfo %s %d %s", fi.Name(), fi.Size(), fi.Mode())
+	}
+	if !fi.ModTime().Equal(time.Date(2019, 1, 30, 12, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected ModTime %s", fi.ModTime())
+	}
+
+	if _, err := zfs.Stat("/missing.txt"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+	if _, err := zfs.Open("/implicit/missing"); !os.IsNotExist(err) {
+		t.Errorf("expected not exist, got %v", err)
+	}
+
+	for _, name := range []string{"stored.txt", "/deflated.txt"} {
+		content, err := afero.ReadFile(zfs, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != testContent {
+			t.Errorf("%s: unexpected content", name)
+		}
+	}
+}
+
+func TestZipFSReaddir(t *testing.T) {
+	zfs := New(newTestZip(t))
+
+	names := func(dir string) []string {
+		f, err := zfs.Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		n, err := f.Readdirnames(-1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if n := names("/"); !reflect.DeepEqual(n, []string{"deflated.txt", "explicit", "implicit", "stored.txt"}) {
+		t.Errorf("unexpected root listing %v", n)
+	}
+	if n := names("/implicit"); !reflect.DeepEqual(n, []string{"sub"}) {
+		t.Errorf("unexpected listing %v", n)
+	}
+
+	f, err := zfs.Open("/implicit/sub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var got []string
+	for {
+		fis, err := f.Readdir(2)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fis) == 0 || len(fis) > 2 {
+			t.Fatalf("Readdir(2) returned %d entries", len(fis))
+		}
+		for _, fi := range fis {
+			got = append(got, fi.Name())
+		}
+	}
+	if !reflect.DeepEqual(got, []string{"a.txt", "b.txt", "c.txt"}) {
+		t.Errorf("unexpected paged listing %v", got)
+	}
+
+	file, _ := zfs.Open("/stored.txt")
+	if _, err := file.Readdir(-1); err == nil {
+		t.Error("expected error reading a file as directory")
+	}
+}
+
+func TestZipFSSeekReadAt(t *testing.T) {
+	zfs := New(newTestZip(t))
+
+	for _, name := range []string{"/stored.txt", "/deflated.txt"} {
+		f, err := zfs.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, 11)
+		if _, err := f.ReadAt(buf, 28*50); err != nil {
+			t.Fatalf("%s: ReadAt: %v", name, err)
+		}
+		if string(buf) != "Lorem ipsum" {
+			t.Errorf("%s: ReadAt read %q", name, buf)
+		}
+
+		n, err := f.ReadAt(buf, int64(len(testContent)-5))
+		if n != 5 || err != io.EOF {
+			t.Errorf("%s: ReadAt at the end = %d, %v, want 5, io.EOF", name, n, err)
+		}
+
+		if pos, err := f.Seek(-6, io.SeekEnd); err != nil || pos != int64(len(testContent)-6) {
+			t.Fatalf("%s: Seek = %d, %v", name, pos, err)
+		}
+		rest, _ := ioutil.ReadAll(f)
+		if string(rest) != "amet. " {
+			t.Errorf("%s: read %q after Seek", name, rest)
+		}
+
+		// back to the start, which needs the buffer for deflated files
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Seek(6, io.SeekCurrent); err != nil {
+			t.Fatal(err)
+		}
+		buf = make([]byte, 5)
+		if _, err := io.ReadFull(f, buf); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != "ipsum" {
+			t.Errorf("%s: read %q after Seek", name, buf)
+		}
+
+		if _, err := f.Seek(-1, io.SeekStart); err == nil {
+			t.Errorf("%s: expected error seeking before the start", name)
+		}
+
+		if _, err := f.Write([]byte("x")); err != syscall.EPERM {
+			t.Errorf("%s: expected EPERM, got %v", name, err)
+		}
+		f.Close()
+		if _, err := f.Read(buf); err != afero.ErrFileClosed {
+			t.Errorf("%s: expected ErrFileClosed, got %v", name, err)
+		}
+	}
+}
+
+func TestZipFSReadOnly(t *testing.T) {
+	zfs := New(newTestZip(t))
+
+	if _, err := zfs.Create("/new.txt"); err != syscall.EPERM {
+		t.Errorf("Create: expected EPERM, got %v", err)
+	}
+	if _, err := zfs.OpenFile("/stored.txt", os.O_RDWR, 0); err != syscall.EPERM {
+		t.Errorf("OpenFile: expected EPERM, got %v", err)
+	}
+	if err := zfs.Remove("/stored.txt"); err != syscall.EPERM {
+		t.Errorf("Remove: expected EPERM, got %v", err)
+	}
+	if f, err := zfs.OpenFile("/stored.txt", os.O_RDONLY, 0); err != nil {
+		t.Errorf("OpenFile: %v", err)
+	} else {
+		f.Close()
+	}
+}
+
+func TestZipFSWalk(t *testing.T) {
+	zfs := New(newTestZip(t))
+
+	var files []string
+	err := afero.Walk(zfs, "/", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		files = append(files, filepath.ToSlash(path))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"/", "/deflated.txt", "/explicit", "/explicit/file.txt", "/implicit",
+		"/implicit/sub", "/implicit/sub/a.txt", "/implicit/sub/b.txt",
+		"/implicit/sub/c.txt", "/stored.txt",
+	}
+	if !reflect.DeepEqual(files, expected) {
+		t.Errorf("expected %v, got %v", expected, files)
+	}
+}
+
+func TestZipFSComposition(t *testing.T) {
+	zfs := New(newTestZip(t))
+
+	t.Run("BasePathFs", func(t *testing.T) {
+		bp := afero.NewBasePathFs(zfs, "/implicit")
+		content, err := afero.ReadFile(bp, "/sub/a.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != "a" {
+			t.Errorf("read %q", content)
+		}
+	})
+
+	t.Run("HttpFs", func(t *testing.T) {
+		dir := afero.NewHttpFs(zfs).Dir("/explicit")
+		f, err := dir.Open("/file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		var _ http.File = f
+		content, _ := ioutil.ReadAll(f)
+		if string(content) != "explicit" {
+			t.Errorf("read %q", content)
+		}
+	})
+
+	t.Run("CopyOnWriteFs", func(t *testing.T) {
+		layer := afero.NewMemMapFs()
+		cow := afero.NewCopyOnWriteFs(zfs, layer)
+
+		if err := afero.WriteFile(cow, "/explicit/file.txt", []byte("changed"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		content, _ := afero.ReadFile(cow, "/explicit/file.txt")
+		if string(content) != "changed" {
+			t.Errorf("read %q from the overlay", content)
+		}
+		content, _ = afero.ReadFile(zfs, "/explicit/file.txt")
+		if string(content) != "explicit" {
+			t.Errorf("read %q from the archive", content)
+		}
+
+		names, err := afero.ReadDir(cow, "/implicit/sub")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(names) != 3 {
+			t.Errorf("expected 3 entries, got %d", len(names))
+		}
+	})
+}
+
+func TestConformance(t *testing.T) {
+	aferotest.TestReadOnlyFs(t, func(base afero.Fs) afero.Fs {
+		var buf bytes.Buffer
+		if err := afero.ArchiveZip(base, "/", &buf, nil); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return New(zr)
+	})
+}